@@ -0,0 +1,443 @@
+// Package asm is a two pass CHIP-8 assembler, the mnemonics are the same
+// with the plain syntax of the disassembler:
+//
+//	; comment
+//	WIDTH equ 8             ; constant, = works too
+//	include "sprites.s"     ; relative to the including file
+//	macro move x, y         ; \@ is unique for every expansion
+//	    LD V0, x
+//	    LD V1, y
+//	endm
+//	main:
+//	    move 10, WIDTH * 2
+//	.loop:                  ; local label, main.loop
+//	    JP .loop
+//	    db 0xF0, %10010000, "text"
+//	    dw main, $ + 2      ; $ is the address of the statement
+//	    ds 4                ; zero filled space
+//	    org 0x300
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const MAX_INCLUDE_DEPTH = 16
+const MAX_ERRORS = 20
+
+type statement struct {
+	source   SourceLine
+	label    string
+	mnemonic string
+	operands []string
+	// Global label of local label references
+	scope   string
+	address uint16
+	size    int
+}
+
+type macro struct {
+	params []string
+	body   []string
+}
+
+// Constants are evaluated when used, so they can refer to later labels
+type constant struct {
+	statement  *statement
+	expression string
+	evaluating bool
+}
+
+type assembler struct {
+	origin     uint16
+	statements []*statement
+	macros     map[string]*macro
+	constants  map[string]*constant
+	labels     map[string]uint16
+	errors     []string
+	expansions int
+	// Labels of macro expansions do not start a new scope
+	expanding int
+	scope     string
+	// Macro being defined
+	definition     *macro
+	definitionName string
+}
+
+// Assemble the file into a rom image loaded at the origin
+func AssembleFile(path string, origin uint16) ([]byte, *Symbols, error) {
+	a := &assembler{
+		origin:    origin,
+		macros:    map[string]*macro{},
+		constants: map[string]*constant{},
+		labels:    map[string]uint16{},
+	}
+	a.include(path, SourceLine{}, 0)
+	if a.definition != nil {
+		a.errorf(SourceLine{File: path}, "Macro %s is not closed with endm", a.definitionName)
+	}
+	if len(a.errors) == 0 {
+		a.layout()
+	}
+	var rom []byte
+	symbols := NewSymbols()
+	if len(a.errors) == 0 {
+		rom = a.emit(symbols)
+	}
+	if len(a.errors) > 0 {
+		return nil, nil, errors.New(strings.Join(a.errors, "\n"))
+	}
+	return rom, symbols, nil
+}
+
+func (a *assembler) errorf(source SourceLine, format string, args ...interface{}) {
+	if len(a.errors) < MAX_ERRORS {
+		a.errors = append(a.errors, source.String()+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+func (a *assembler) include(path string, from SourceLine, depth int) {
+	if depth > MAX_INCLUDE_DEPTH {
+		a.errorf(from, "Includes are nested too deep")
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		a.errorf(from, "%v", err)
+		return
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		a.parseLine(line, SourceLine{File: path, Line: i + 1}, depth)
+	}
+}
+
+// Remove the comment outside of quotes
+func stripComment(line string) string {
+	inQuote := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			inQuote = !inQuote
+		case '\\':
+			i++
+		case ';':
+			if !inQuote {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+func splitWord(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		return line[:i], strings.TrimSpace(line[i:])
+	}
+	return line, ""
+}
+
+// Split operands on commas outside of quotes and parentheses
+func splitOperands(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	var operands []string
+	depth, inQuote, start := 0, false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			inQuote = !inQuote
+		case '\\':
+			i++
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case ',':
+			if !inQuote && depth == 0 {
+				operands = append(operands, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(operands, strings.TrimSpace(s[start:]))
+}
+
+func (a *assembler) parseLine(line string, source SourceLine, depth int) {
+	line = strings.TrimSpace(stripComment(line))
+	if a.definition != nil {
+		if head, _ := splitWord(line); strings.EqualFold(head, "endm") {
+			a.macros[a.definitionName] = a.definition
+			a.definition = nil
+		} else {
+			a.definition.body = append(a.definition.body, line)
+		}
+		return
+	}
+	if line == "" {
+		return
+	}
+	head, rest := splitWord(line)
+	st := &statement{source: source}
+	if strings.HasSuffix(head, ":") {
+		st.label = strings.TrimSuffix(head, ":")
+		if strings.HasPrefix(st.label, ".") {
+			st.label = a.scope + st.label
+		} else if a.expanding == 0 {
+			a.scope = st.label
+		}
+		head, rest = splitWord(rest)
+	}
+	st.scope = a.scope
+	st.mnemonic = head
+	// Constants
+	if next, expression := splitWord(rest); strings.EqualFold(next, "equ") || next == "=" {
+		if _, isExists := a.constants[head]; isExists {
+			a.errorf(source, "Constant %s is already defined", head)
+		}
+		a.constants[head] = &constant{statement: st, expression: expression}
+		st.mnemonic = ""
+		a.statements = append(a.statements, st)
+		return
+	}
+	switch strings.ToLower(head) {
+	case "include":
+		a.statements = append(a.statements, &statement{source: source, label: st.label, scope: st.scope})
+		path, err := strconv.Unquote(rest)
+		if err != nil {
+			a.errorf(source, "Include path must be quoted")
+			return
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(source.File), path)
+		}
+		a.include(path, source, depth+1)
+		return
+	case "macro":
+		name, params := splitWord(rest)
+		if name == "" {
+			a.errorf(source, "Macro needs a name")
+			return
+		}
+		a.definition = &macro{params: splitOperands(params)}
+		a.definitionName = strings.ToLower(name)
+		st.mnemonic = ""
+		a.statements = append(a.statements, st)
+		return
+	case "endm":
+		a.errorf(source, "endm without macro")
+		return
+	}
+	st.operands = splitOperands(rest)
+	if m, isExists := a.macros[strings.ToLower(head)]; isExists {
+		st.mnemonic = ""
+		a.statements = append(a.statements, st)
+		a.expand(m, head, st, depth)
+		return
+	}
+	a.statements = append(a.statements, st)
+}
+
+// Expand the macro at the call site, parameters are replaced as whole words
+func (a *assembler) expand(m *macro, name string, call *statement, depth int) {
+	if depth > MAX_INCLUDE_DEPTH {
+		a.errorf(call.source, "Macros are nested too deep")
+		return
+	}
+	if len(call.operands) != len(m.params) {
+		a.errorf(call.source, "Macro %s takes %d arguments", name, len(m.params))
+		return
+	}
+	a.expansions++
+	a.expanding++
+	defer func() { a.expanding-- }()
+	for _, line := range m.body {
+		line = strings.ReplaceAll(line, `\@`, fmt.Sprintf("_%d", a.expansions))
+		for i, param := range m.params {
+			line = replaceWord(line, param, call.operands[i])
+		}
+		a.parseLine(line, call.source, depth+1)
+	}
+}
+
+func replaceWord(line string, word string, replacement string) string {
+	var result strings.Builder
+	for i := 0; i < len(line); {
+		if strings.HasPrefix(line[i:], word) &&
+			(i == 0 || !isSymbolChar(line[i-1])) &&
+			(i+len(word) == len(line) || !isSymbolChar(line[i+len(word)])) {
+			result.WriteString(replacement)
+			i += len(word)
+			continue
+		}
+		result.WriteByte(line[i])
+		i++
+	}
+	return result.String()
+}
+
+func (a *assembler) resolver(st *statement) func(string) (int, error) {
+	return func(name string) (int, error) {
+		if name == "$" {
+			return int(st.address), nil
+		}
+		if strings.HasPrefix(name, ".") {
+			name = st.scope + name
+		}
+		if address, isExists := a.labels[name]; isExists {
+			return int(address), nil
+		}
+		c, isExists := a.constants[name]
+		if !isExists {
+			return 0, fmt.Errorf("Undefined symbol %s", name)
+		}
+		if c.evaluating {
+			return 0, fmt.Errorf("Constant %s refers to itself", name)
+		}
+		c.evaluating = true
+		defer func() { c.evaluating = false }()
+		return Eval(c.expression, a.resolver(c.statement))
+	}
+}
+
+// First pass, assign addresses and define labels
+func (a *assembler) layout() {
+	address := int(a.origin)
+	for _, st := range a.statements {
+		st.address = uint16(address)
+		if st.label != "" {
+			if _, isExists := a.labels[st.label]; isExists {
+				a.errorf(st.source, "Label %s is already defined", st.label)
+			}
+			a.labels[st.label] = uint16(address)
+		}
+		switch strings.ToLower(st.mnemonic) {
+		case "":
+		case "org":
+			value, err := a.evalOperand(st, 0)
+			if err == nil && value < int(a.origin) {
+				err = fmt.Errorf("org 0x%X is below the origin 0x%X", value, a.origin)
+			}
+			if err != nil {
+				a.errorf(st.source, "%v", err)
+				continue
+			}
+			address = value
+		case "db":
+			for _, op := range st.operands {
+				if text, err := strconv.Unquote(op); err == nil {
+					st.size += len(text)
+				} else {
+					st.size++
+				}
+			}
+		case "dw":
+			st.size = 2 * len(st.operands)
+		case "ds":
+			value, err := a.evalOperand(st, 0)
+			if err == nil && value < 0 {
+				err = fmt.Errorf("ds size can not be negative")
+			}
+			if err != nil {
+				a.errorf(st.source, "%v", err)
+				continue
+			}
+			st.size = value
+		default:
+			st.size = 2
+		}
+		address += st.size
+		// The last byte has to be below 4KB, a label or org past the end emits nothing
+		if st.size > 0 && address > 0x1000 {
+			a.errorf(st.source, "Program does not fit into memory")
+			return
+		}
+	}
+}
+
+func (a *assembler) evalOperand(st *statement, index int) (int, error) {
+	if len(st.operands) != index+1 {
+		return 0, fmt.Errorf("%s takes one operand", st.mnemonic)
+	}
+	return Eval(st.operands[index], a.resolver(st))
+}
+
+// Second pass, encode the statements into the image
+func (a *assembler) emit(symbols *Symbols) []byte {
+	image := []byte{}
+	write := func(address int, b byte) {
+		offset := address - int(a.origin)
+		for len(image) <= offset {
+			image = append(image, 0)
+		}
+		image[offset] = b
+	}
+	for name, address := range a.labels {
+		symbols.Labels[name] = address
+	}
+	for _, st := range a.statements {
+		address := int(st.address)
+		eval := a.resolver(st)
+		switch strings.ToLower(st.mnemonic) {
+		case "", "org":
+			continue
+		case "db":
+			for _, op := range st.operands {
+				if text, err := strconv.Unquote(op); err == nil {
+					for i := 0; i < len(text); i++ {
+						write(address, text[i])
+						address++
+					}
+					continue
+				}
+				value, err := Eval(op, eval)
+				if err == nil && (value < -0x80 || value > 0xFF) {
+					err = fmt.Errorf("Byte 0x%X is out of range", value)
+				}
+				if err != nil {
+					a.errorf(st.source, "%v", err)
+				}
+				write(address, byte(value))
+				address++
+			}
+		case "dw":
+			for _, op := range st.operands {
+				value, err := Eval(op, eval)
+				if err == nil && (value < -0x8000 || value > 0xFFFF) {
+					err = fmt.Errorf("Word 0x%X is out of range", value)
+				}
+				if err != nil {
+					a.errorf(st.source, "%v", err)
+				}
+				write(address, byte(value>>8))
+				write(address+1, byte(value))
+				address += 2
+			}
+		case "ds":
+			for i := 0; i < st.size; i++ {
+				write(address+i, 0)
+			}
+		default:
+			operands := make([]operand, len(st.operands))
+			for i, op := range st.operands {
+				operands[i] = parseOperand(op)
+			}
+			opcode, err := encode(st.mnemonic, operands, func(text string) (int, error) {
+				return Eval(text, eval)
+			})
+			if err != nil {
+				a.errorf(st.source, "%v", err)
+			}
+			write(address, byte(opcode>>8))
+			write(address+1, byte(opcode))
+		}
+		symbols.Lines[st.address] = st.source
+	}
+	return image
+}
@@ -0,0 +1,226 @@
+package asm
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Write the files into a temporary directory and assemble main.s
+func assembleFiles(t *testing.T, files map[string]string) ([]byte, *Symbols, string, error) {
+	dir := t.TempDir()
+	for name, source := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "main.s")
+	rom, symbols, err := AssembleFile(path, 0x200)
+	return rom, symbols, path, err
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		source string
+		opcode uint16
+	}{
+		{"CLS", 0x00E0},
+		{"RET", 0x00EE},
+		{"SYS 0x123", 0x0123},
+		{"JP 0x345", 0x1345},
+		{"CALL 0x456", 0x2456},
+		{"SE V1, 0x22", 0x3122},
+		{"SNE VA, 0x33", 0x4A33},
+		{"SE V1, V2", 0x5120},
+		{"LD V3, 0x44", 0x6344},
+		{"ADD V4, -1", 0x74FF},
+		{"LD V5, V6", 0x8560},
+		{"OR V5, V6", 0x8561},
+		{"AND V5, V6", 0x8562},
+		{"XOR V5, V6", 0x8563},
+		{"ADD V5, V6", 0x8564},
+		{"SUB V5, V6", 0x8565},
+		{"SHR V5, V6", 0x8566},
+		{"SUBN V5, V6", 0x8567},
+		{"SHL V5, V6", 0x856E},
+		{"SNE V7, V8", 0x9780},
+		{"LD I, 0x567", 0xA567},
+		{"JP V0, 0x678", 0xB678},
+		{"RND V9, 0x0F", 0xC90F},
+		{"DRW V1, V2, 5", 0xD125},
+		{"SKP VB", 0xEB9E},
+		{"SKNP VC", 0xECA1},
+		{"LD VD, DT", 0xFD07},
+		{"LD VE, K", 0xFE0A},
+		{"LD DT, V1", 0xF115},
+		{"LD ST, V2", 0xF218},
+		{"ADD I, V3", 0xF31E},
+		{"LD F, V4", 0xF429},
+		{"LD B, V5", 0xF533},
+		{"LD [I], V6", 0xF655},
+		{"LD V7, [I]", 0xF765},
+		{"ld v1, 'A'", 0x6141},
+	}
+	for _, test := range tests {
+		rom, _, _, err := assembleFiles(t, map[string]string{"main.s": test.source})
+		if err != nil {
+			t.Errorf("%s: %v", test.source, err)
+			continue
+		}
+		if len(rom) != 2 || uint16(rom[0])<<8|uint16(rom[1]) != test.opcode {
+			t.Errorf("%s: assembled % X, want %04X", test.source, rom, test.opcode)
+		}
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	source := `
+WIDTH equ 8
+HEIGHT = WIDTH / 2 + 1           ; constants are evaluated when used
+macro wait reg, count
+    LD reg, count
+\@loop:
+    ADD reg, -1
+    SE reg, 0
+    JP \@loop
+endm
+main:
+    LD V0, WIDTH * 2
+    wait V1, HEIGHT
+    wait V2, 3
+.loop:
+    JP .loop
+other:
+.loop:
+    JP .loop
+    include "data.s"
+`
+	data := `
+sprite:
+    db 0xF0, %10010000, "ab"
+    dw main, $ + 2
+    ds 3
+    org 0x222
+    db -1
+`
+	rom, symbols, path, err := assembleFiles(t, map[string]string{"main.s": source, "data.s": data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x60, 0x10,
+		// wait V1, HEIGHT
+		0x61, 0x05, 0x71, 0xFF, 0x31, 0x00, 0x12, 0x04,
+		// wait V2, 3 jumps to its own loop
+		0x62, 0x03, 0x72, 0xFF, 0x32, 0x00, 0x12, 0x0C,
+		// main.loop and other.loop
+		0x12, 0x12, 0x12, 0x14,
+		0xF0, 0x90, 'a', 'b', 0x02, 0x00, 0x02, 0x1C,
+		0x00, 0x00, 0x00,
+		0x00,
+		0xFF,
+	}
+	if !bytes.Equal(rom, want) {
+		t.Errorf("Assembled\n% X\nwant\n% X", rom, want)
+	}
+	labels := map[string]uint16{"main": 0x200, "main.loop": 0x212, "other": 0x214, "other.loop": 0x214, "sprite": 0x216}
+	for name, address := range labels {
+		if symbols.Labels[name] != address {
+			t.Errorf("Label %s is 0x%03X, want 0x%03X", name, symbols.Labels[name], address)
+		}
+	}
+	dataPath := filepath.Join(filepath.Dir(path), "data.s")
+	if line := symbols.Lines[0x216]; line.File != dataPath || line.Line != 3 {
+		t.Errorf("0x216 is from %s", line)
+	}
+	// Expansions are on the line of the call
+	if addresses := symbols.Addresses(path, 14); len(addresses) != 4 || addresses[0] != 0x20A {
+		t.Errorf("Line 14 emitted %03X", addresses)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		line   string
+		errors string
+	}{
+		{"undefined symbol", map[string]string{"main.s": "CLS\nJP nowhere"}, "main.s:2", "Undefined symbol nowhere"},
+		{"duplicate label", map[string]string{"main.s": "a:\nCLS\na:\nRET"}, "main.s:3", "Label a is already defined"},
+		{"byte range", map[string]string{"main.s": "\n\nLD V0, 256"}, "main.s:3", "Value 0x100 of LD is out of range 0-0xFF"},
+		{"form", map[string]string{"main.s": "LD I, V0"}, "main.s:1", `Unknown instruction form "LD I,V"`},
+		{"jump offset", map[string]string{"main.s": "JP V1, 0x300"}, "main.s:1", "JP with offset only accepts V0"},
+		{"constant loop", map[string]string{"main.s": "A equ B\nB equ A\nLD V0, A"}, "main.s:3", "Constant A refers to itself"},
+		{"macro arguments", map[string]string{"main.s": "macro m x\nCLS\nendm\n\nm 1, 2"}, "main.s:5", "Macro m takes 1 arguments"},
+		{"open macro", map[string]string{"main.s": "macro m\nCLS"}, "main.s:0", "Macro m is not closed with endm"},
+		{"endm", map[string]string{"main.s": "CLS\nendm"}, "main.s:2", "endm without macro"},
+		{"org", map[string]string{"main.s": "org 0x100"}, "main.s:1", "org 0x100 is below the origin 0x200"},
+		{"included file", map[string]string{"main.s": "CLS\ninclude \"data.s\"", "data.s": "db 1\ndb 0x100"}, "data.s:2", "Byte 0x100 is out of range"},
+		{"missing include", map[string]string{"main.s": "\ninclude \"missing.s\""}, "main.s:2", "no such file"},
+		{"ds past memory", map[string]string{"main.s": "CLS\nds 8000"}, "main.s:2", "Program does not fit into memory"},
+		{"dw past memory", map[string]string{"main.s": "org 0xFFE\ndw 1, 2"}, "main.s:2", "Program does not fit into memory"},
+		{"recursive include", map[string]string{"main.s": "include \"main.s\""}, "main.s:1", "Includes are nested too deep"},
+	}
+	for _, test := range tests {
+		_, _, _, err := assembleFiles(t, test.files)
+		if err == nil {
+			t.Errorf("%s: assembled without errors", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.line+": ") || !strings.Contains(err.Error(), test.errors) {
+			t.Errorf("%s: error is %q, want %s: %s", test.name, err, test.line, test.errors)
+		}
+	}
+}
+
+func TestAssembleEndOfMemory(t *testing.T) {
+	rom, symbols, _, err := assembleFiles(t, map[string]string{"main.s": "org 0xFFE\ndw 0x1234\nend:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rom) != 0xE00 || rom[0xDFE] != 0x12 || rom[0xDFF] != 0x34 || symbols.Labels["end"] != 0x1000 {
+		t.Errorf("Assembled %d bytes ending with % X", len(rom), rom[len(rom)-2:])
+	}
+}
+
+func TestSymbolsRoundTrip(t *testing.T) {
+	_, symbols, path, err := assembleFiles(t, map[string]string{"main.s": "main:\n  CLS\n.loop:\n  JP .loop\n  db 1, 2\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	symbolsPath := strings.TrimSuffix(path, ".s") + ".sym"
+	file, err := os.Create(symbolsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = symbols.Write(file); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	read, err := ReadSymbolsFile(symbolsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Labels) != len(symbols.Labels) || len(read.Lines) != len(symbols.Lines) {
+		t.Fatalf("Read %d labels and %d lines, wrote %d and %d", len(read.Labels), len(read.Lines), len(symbols.Labels), len(symbols.Lines))
+	}
+	for name, address := range symbols.Labels {
+		if read.Labels[name] != address {
+			t.Errorf("Label %s is 0x%03X, want 0x%03X", name, read.Labels[name], address)
+		}
+	}
+	for address, line := range symbols.Lines {
+		if read.Lines[address] != line {
+			t.Errorf("0x%03X is from %s, want %s", address, read.Lines[address], line)
+		}
+	}
+	if label := read.Label(0x202); label != "main.loop" {
+		t.Errorf("0x202 is labelled %q", label)
+	}
+	if _, err = ReadSymbols(strings.NewReader("label main\n")); err == nil || err.Error() != "Symbol file line 1: Unknown record" {
+		t.Errorf("A broken record gives the error %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+type operandKind uint8
+
+const (
+	OPERAND_REGISTER operandKind = iota
+	OPERAND_KEYWORD
+	OPERAND_VALUE
+)
+
+type operand struct {
+	kind     operandKind
+	register uint16
+	// Uppercase keyword or the expression source
+	text string
+}
+
+// Keywords which can be operands, anything else except V0-VF is an expression
+var operandKeywords = map[string]bool{"I": true, "[I]": true, "DT": true, "ST": true, "K": true, "F": true, "B": true}
+
+func parseOperand(text string) operand {
+	upper := strings.ToUpper(strings.ReplaceAll(text, " ", ""))
+	if len(upper) == 2 && upper[0] == 'V' && strings.ContainsRune("0123456789ABCDEF", rune(upper[1])) {
+		return operand{kind: OPERAND_REGISTER, register: uint16(strings.IndexRune("0123456789ABCDEF", rune(upper[1]))), text: upper}
+	}
+	if operandKeywords[upper] {
+		return operand{kind: OPERAND_KEYWORD, text: upper}
+	}
+	return operand{kind: OPERAND_VALUE, text: strings.TrimSpace(text)}
+}
+
+// Pattern of the operands, V for registers, N for values and keywords as they are
+func operandPattern(operands []operand) string {
+	var parts []string
+	for _, op := range operands {
+		switch op.kind {
+		case OPERAND_REGISTER:
+			parts = append(parts, "V")
+		case OPERAND_VALUE:
+			parts = append(parts, "N")
+		default:
+			parts = append(parts, op.text)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Operand bit fields of an instruction form
+type encoding struct {
+	base uint16
+	// Operand index of X, Y and the value, -1 if not used
+	x, y, value int
+	// Maximum of the value, 0xFFF addresses, 0xFF bytes and 0xF nibbles
+	max int
+}
+
+// Every mnemonic form maps onto exactly one opcode
+var encodings = map[string]encoding{
+	"CLS":       {0x00E0, -1, -1, -1, 0},
+	"RET":       {0x00EE, -1, -1, -1, 0},
+	"SYS N":     {0x0000, -1, -1, 0, 0xFFF},
+	"JP N":      {0x1000, -1, -1, 0, 0xFFF},
+	"CALL N":    {0x2000, -1, -1, 0, 0xFFF},
+	"SE V,N":    {0x3000, 0, -1, 1, 0xFF},
+	"SNE V,N":   {0x4000, 0, -1, 1, 0xFF},
+	"SE V,V":    {0x5000, 0, 1, -1, 0},
+	"LD V,N":    {0x6000, 0, -1, 1, 0xFF},
+	"ADD V,N":   {0x7000, 0, -1, 1, 0xFF},
+	"LD V,V":    {0x8000, 0, 1, -1, 0},
+	"OR V,V":    {0x8001, 0, 1, -1, 0},
+	"AND V,V":   {0x8002, 0, 1, -1, 0},
+	"XOR V,V":   {0x8003, 0, 1, -1, 0},
+	"ADD V,V":   {0x8004, 0, 1, -1, 0},
+	"SUB V,V":   {0x8005, 0, 1, -1, 0},
+	"SHR V,V":   {0x8006, 0, 1, -1, 0},
+	"SUBN V,V":  {0x8007, 0, 1, -1, 0},
+	"SHL V,V":   {0x800E, 0, 1, -1, 0},
+	"SNE V,V":   {0x9000, 0, 1, -1, 0},
+	"LD I,N":    {0xA000, -1, -1, 1, 0xFFF},
+	"JP V,N":    {0xB000, -1, -1, 1, 0xFFF},
+	"RND V,N":   {0xC000, 0, -1, 1, 0xFF},
+	"DRW V,V,N": {0xD000, 0, 1, 2, 0xF},
+	"SKP V":     {0xE09E, 0, -1, -1, 0},
+	"SKNP V":    {0xE0A1, 0, -1, -1, 0},
+	"LD V,DT":   {0xF007, 0, -1, -1, 0},
+	"LD V,K":    {0xF00A, 0, -1, -1, 0},
+	"LD DT,V":   {0xF015, 1, -1, -1, 0},
+	"LD ST,V":   {0xF018, 1, -1, -1, 0},
+	"ADD I,V":   {0xF01E, 1, -1, -1, 0},
+	"LD F,V":    {0xF029, 1, -1, -1, 0},
+	"LD B,V":    {0xF033, 1, -1, -1, 0},
+	"LD [I],V":  {0xF055, 1, -1, -1, 0},
+	"LD V,[I]":  {0xF065, 0, -1, -1, 0},
+}
+
+// Encode an instruction, eval evaluates the value operand
+func encode(mnemonic string, operands []operand, eval func(string) (int, error)) (uint16, error) {
+	form := strings.ToUpper(mnemonic)
+	if pattern := operandPattern(operands); pattern != "" {
+		form += " " + pattern
+	}
+	e, isExists := encodings[form]
+	if !isExists {
+		return 0, fmt.Errorf("Unknown instruction form %q", form)
+	}
+	// JP V0, addr only accepts V0
+	if form == "JP V,N" && operands[0].register != 0 {
+		return 0, fmt.Errorf("JP with offset only accepts V0")
+	}
+	opcode := e.base
+	if e.x >= 0 {
+		opcode |= operands[e.x].register << 8
+	}
+	if e.y >= 0 {
+		opcode |= operands[e.y].register << 4
+	}
+	if e.value >= 0 {
+		value, err := eval(operands[e.value].text)
+		if err != nil {
+			return 0, err
+		}
+		// Negative bytes are written in two's complement
+		if e.max == 0xFF && value < 0 && value >= -0x80 {
+			value &= 0xFF
+		}
+		if value < 0 || value > e.max {
+			return 0, fmt.Errorf("Value 0x%X of %s is out of range 0-0x%X", value, mnemonic, e.max)
+		}
+		opcode |= uint16(value)
+	}
+	return opcode, nil
+}
@@ -0,0 +1,202 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Binary operators from the lowest to the highest precedence, like C
+var binaryOperators = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+type expressionParser struct {
+	tokens  []string
+	pos     int
+	resolve func(name string) (int, error)
+}
+
+// Evaluate an integer expression, symbols are looked up with resolve
+// Numbers can be decimal, 0x/$ hex, 0b/% binary or 'c' characters and $ alone is the current address
+func Eval(source string, resolve func(name string) (int, error)) (int, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return 0, err
+	}
+	if len(tokens) == 0 {
+		return 0, fmt.Errorf("Missing expression")
+	}
+	parser := &expressionParser{tokens: tokens, resolve: resolve}
+	value, err := parser.parseBinary(0)
+	if err != nil {
+		return 0, err
+	}
+	if parser.pos < len(tokens) {
+		return 0, fmt.Errorf("Unexpected %q in expression %q", tokens[parser.pos], source)
+	}
+	return value, nil
+}
+
+func isSymbolChar(c byte) bool {
+	return c == '_' || c == '.' || c == '@' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+func tokenize(source string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '\'':
+			if i+2 >= len(source) || source[i+2] != '\'' {
+				return nil, fmt.Errorf("Invalid character literal in %q", source)
+			}
+			tokens = append(tokens, source[i:i+3])
+			i += 3
+		case (c == '$' || c == '%') && i+1 < len(source) && isSymbolChar(source[i+1]) && (len(tokens) == 0 || isOperator(tokens[len(tokens)-1])):
+			// $FF and %1010 number prefixes, otherwise $ is the address and % the modulo
+			start := i
+			for i++; i < len(source) && isSymbolChar(source[i]); i++ {
+			}
+			tokens = append(tokens, source[start:i])
+		case isSymbolChar(c):
+			start := i
+			for ; i < len(source) && isSymbolChar(source[i]); i++ {
+			}
+			tokens = append(tokens, source[start:i])
+		case i+1 < len(source) && (source[i:i+2] == "<<" || source[i:i+2] == ">>"):
+			tokens = append(tokens, source[i:i+2])
+			i += 2
+		case strings.ContainsRune("+-*/%&|^~()$", rune(c)):
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			return nil, fmt.Errorf("Unexpected character %q in expression %q", c, source)
+		}
+	}
+	return tokens, nil
+}
+
+func isOperator(token string) bool {
+	return token != ")" && token != "$" && !isSymbolChar(token[0]) && token[0] != '\''
+}
+
+func (p *expressionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *expressionParser) parseBinary(level int) (int, error) {
+	if level == len(binaryOperators) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		operator := p.peek()
+		found := false
+		for _, candidate := range binaryOperators[level] {
+			found = found || operator == candidate
+		}
+		if !found {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		switch operator {
+		case "|":
+			left |= right
+		case "^":
+			left ^= right
+		case "&":
+			left &= right
+		case "<<":
+			left <<= uint(right)
+		case ">>":
+			left >>= uint(right)
+		case "+":
+			left += right
+		case "-":
+			left -= right
+		case "*":
+			left *= right
+		case "/", "%":
+			if right == 0 {
+				return 0, fmt.Errorf("Division by zero")
+			}
+			if operator == "/" {
+				left /= right
+			} else {
+				left %= right
+			}
+		}
+	}
+}
+
+func (p *expressionParser) parseUnary() (int, error) {
+	switch p.peek() {
+	case "-":
+		p.pos++
+		value, err := p.parseUnary()
+		return -value, err
+	case "~":
+		p.pos++
+		value, err := p.parseUnary()
+		return ^value, err
+	case "+":
+		p.pos++
+		return p.parseUnary()
+	}
+	return p.parsePrimary()
+}
+
+func (p *expressionParser) parsePrimary() (int, error) {
+	token := p.peek()
+	p.pos++
+	switch {
+	case token == "":
+		return 0, fmt.Errorf("Unexpected end of expression")
+	case token == "(":
+		value, err := p.parseBinary(0)
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ")" {
+			return 0, fmt.Errorf("Missing )")
+		}
+		p.pos++
+		return value, nil
+	case token[0] == '\'':
+		return int(token[1]), nil
+	case token[0] == '$' && len(token) > 1:
+		return parseInt(token[1:], 16)
+	case token[0] == '%' && len(token) > 1:
+		return parseInt(token[1:], 2)
+	case unicode.IsDigit(rune(token[0])):
+		return parseInt(token, 0)
+	}
+	return p.resolve(token)
+}
+
+func parseInt(s string, base int) (int, error) {
+	value, err := strconv.ParseInt(s, base, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid number %q", s)
+	}
+	return int(value), nil
+}
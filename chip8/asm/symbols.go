@@ -0,0 +1,126 @@
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type SourceLine struct {
+	File string
+	Line int
+}
+
+func (l SourceLine) String() string {
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+// Labels and the source line of every emitted address, written next to the rom
+// Format of the lines:
+//
+//	label <name> <address>
+//	line <address> <line> <file>
+type Symbols struct {
+	Labels map[string]uint16
+	Lines  map[uint16]SourceLine
+}
+
+func NewSymbols() *Symbols {
+	return &Symbols{Labels: map[string]uint16{}, Lines: map[uint16]SourceLine{}}
+}
+
+// Return the label of an address, global labels are preferred over local ones
+func (s *Symbols) Label(address uint16) string {
+	found := ""
+	for name, labelAddress := range s.Labels {
+		if labelAddress != address {
+			continue
+		}
+		isLocal := strings.Contains(name, ".")
+		if found == "" || (strings.Contains(found, ".") && !isLocal) ||
+			(strings.Contains(found, ".") == isLocal && name < found) {
+			found = name
+		}
+	}
+	return found
+}
+
+// Return the addresses emitted by a source line in order
+func (s *Symbols) Addresses(file string, line int) []uint16 {
+	var addresses []int
+	for address, source := range s.Lines {
+		if source.Line == line && source.File == file {
+			addresses = append(addresses, int(address))
+		}
+	}
+	sort.Ints(addresses)
+	result := make([]uint16, len(addresses))
+	for i, address := range addresses {
+		result[i] = uint16(address)
+	}
+	return result
+}
+
+func (s *Symbols) Write(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	names := make([]string, 0, len(s.Labels))
+	for name := range s.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "label %s 0x%03X\n", name, s.Labels[name])
+	}
+	addresses := make([]int, 0, len(s.Lines))
+	for address := range s.Lines {
+		addresses = append(addresses, int(address))
+	}
+	sort.Ints(addresses)
+	for _, address := range addresses {
+		source := s.Lines[uint16(address)]
+		fmt.Fprintf(out, "line 0x%03X %d %s\n", address, source.Line, source.File)
+	}
+	return out.Flush()
+}
+
+func ReadSymbols(r io.Reader) (*Symbols, error) {
+	s := NewSymbols()
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		fields := strings.SplitN(scanner.Text(), " ", 4)
+		var err error
+		switch {
+		case len(fields) == 3 && fields[0] == "label":
+			var address uint64
+			address, err = strconv.ParseUint(fields[2], 0, 16)
+			s.Labels[fields[1]] = uint16(address)
+		case len(fields) == 4 && fields[0] == "line":
+			var address uint64
+			var line int
+			if address, err = strconv.ParseUint(fields[1], 0, 16); err == nil {
+				line, err = strconv.Atoi(fields[2])
+				s.Lines[uint16(address)] = SourceLine{File: fields[3], Line: line}
+			}
+		case len(strings.TrimSpace(scanner.Text())) == 0:
+		default:
+			err = fmt.Errorf("Unknown record")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Symbol file line %d: %v", lineNum, err)
+		}
+	}
+	return s, scanner.Err()
+}
+
+func ReadSymbolsFile(path string) (*Symbols, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadSymbols(file)
+}
@@ -1,10 +1,15 @@
 package chip8
 
 import (
+	"crypto/sha1"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -34,9 +39,29 @@ type SoundTimer uint8
 // 0x200-0xFFF -> Instructions from the ROM. May not be full
 type Memory [4 * 1024]uint8
 
+// Monochrome display, 1 or 0
+// width -> 64, height -> 32
+// Width and height can be set differenlty on some interpreters
+const WIDTH = 64
+const HEIGHT = 32
+
+type Display [WIDTH][HEIGHT]uint8
+
+// 0x0 to 0xF -> store pressed or not
+type Keypad [16]bool
+
 // 0x0000
 type Opcode uint16
 
+// Kind of memory access made by an instruction
+type MemoryAccess uint8
+
+const (
+	MEMORY_READ MemoryAccess = 1 << iota
+	MEMORY_WRITE
+	MEMORY_EXECUTE
+)
+
 type CPU struct {
 	//V0-VF
 	Registers      Registers
@@ -54,6 +79,14 @@ type Chip8 struct {
 	DelayTimer DelayTimer
 	SoundTimer SoundTimer
 	Speed      uint8
+	Quirks     Quirks
+	// Path and SHA-1 of the loaded rom
+	Rom     string
+	RomHash string
+	// Cycles run since the rom was loaded, the timers tick once per cycle
+	Frame uint64
+	// Set when an instruction can't continue, e.g. a stack overflow, the machine stops
+	Fault error
 }
 
 const START_ADDRESS = uint16(0x200)
@@ -87,13 +120,58 @@ FX07 FX0A FX15 FX18 FX1E FX29 FX33 FX55 FX65
 */
 var opcodeTable = map[Opcode](*func()){}
 
+// Pauses the emulation loop between cycles, the debugger and the gdb stub
+type controller interface {
+	// Return true if the next cycle can run
+	beforeCycle() bool
+	afterCycle()
+}
+
+// Nil when the machine runs freely
+var control controller
+
+// Called on every memory read and write of instructions when set, used by the debugger watchpoints
+var memoryHook func(address uint16, access MemoryAccess)
+
+// Addresses wrap around the 4KB memory like the 12 bit address bus
+func readMemory(address uint16) uint8 {
+	address %= uint16(len(chip8.Cpu.Memory))
+	if memoryHook != nil {
+		memoryHook(address, MEMORY_READ)
+	}
+	coverMemory(address, MEMORY_READ)
+	return chip8.Cpu.Memory[address]
+}
+
+func writeMemory(address uint16, value uint8) {
+	address %= uint16(len(chip8.Cpu.Memory))
+	if memoryHook != nil {
+		memoryHook(address, MEMORY_WRITE)
+	}
+	coverMemory(address, MEMORY_WRITE)
+	chip8.Cpu.Memory[address] = value
+}
+
 // Clear the display
 func OP_00E0() {
-	ClearRenderer(&chip8.Display)
+	tracef(TRACE_DRAW, TRACE_INFO, "clear")
+	traceDisplayChanged()
+	chip8.Display = Display{}
+	frontend.Clear(&chip8.Display)
+}
+
+// Stop the machine, called by the instructions after the program counter moved past them
+func fault(format string, args ...interface{}) {
+	chip8.Fault = fmt.Errorf("0x%03X: "+format, append([]interface{}{chip8.Cpu.ProgramCounter - 2}, args...)...)
+	tracef(TRACE_CPU, TRACE_INFO, "fault: %v", chip8.Fault)
 }
 
 // Return from subroutine
 func OP_00EE() {
+	if chip8.Cpu.StackPointer == 0 || int(chip8.Cpu.StackPointer) > len(chip8.Cpu.ProgramStack) {
+		fault("Stack underflow, return without a call")
+		return
+	}
 	chip8.Cpu.StackPointer -= 1
 	//Return
 	chip8.Cpu.ProgramCounter = chip8.Cpu.ProgramStack[chip8.Cpu.StackPointer]
@@ -107,6 +185,10 @@ func OP_1NNN() {
 // Call subroutine at NNN
 func OP_2NNN() {
 	address := (chip8.Cpu.Opcode & 0x0FFF)
+	if int(chip8.Cpu.StackPointer) >= len(chip8.Cpu.ProgramStack) {
+		fault("Stack overflow, more than %d nested calls", len(chip8.Cpu.ProgramStack))
+		return
+	}
 	//Save state in stack
 	chip8.Cpu.ProgramStack[chip8.Cpu.StackPointer] = chip8.Cpu.ProgramCounter
 	chip8.Cpu.StackPointer += 1
@@ -135,9 +217,9 @@ func OP_4XNN() {
 
 // Skip the next instruction if VX equals VY (usually the next instruction is a jump to skip a code block)
 func OP_5XY0() {
-	registerIndex := (chip8.Cpu.Opcode & 0x0F00) >> 8
-	val := Register(chip8.Cpu.Opcode & 0x00FF)
-	if chip8.Cpu.Registers[registerIndex] == val {
+	regXIndex := (chip8.Cpu.Opcode & 0x0F00) >> 8
+	regYIndex := (chip8.Cpu.Opcode & 0x00F0) >> 4
+	if chip8.Cpu.Registers[regXIndex] == chip8.Cpu.Registers[regYIndex] {
 		chip8.Cpu.ProgramCounter += 2
 	}
 }
@@ -168,6 +250,9 @@ func OP_8XY1() {
 	regXIndex := (chip8.Cpu.Opcode & 0x0F00) >> 8
 	regYIndex := (chip8.Cpu.Opcode & 0x00F0) >> 4
 	chip8.Cpu.Registers[regXIndex] |= chip8.Cpu.Registers[regYIndex]
+	if chip8.Quirks.VFReset {
+		chip8.Cpu.Registers[0xF] = 0
+	}
 }
 
 // Set VX to VX and VY. (bitwise AND operation)
@@ -175,6 +260,9 @@ func OP_8XY2() {
 	regXIndex := (chip8.Cpu.Opcode & 0x0F00) >> 8
 	regYIndex := (chip8.Cpu.Opcode & 0x00F0) >> 4
 	chip8.Cpu.Registers[regXIndex] &= chip8.Cpu.Registers[regYIndex]
+	if chip8.Quirks.VFReset {
+		chip8.Cpu.Registers[0xF] = 0
+	}
 }
 
 // Set VX to VX xor VY
@@ -182,21 +270,25 @@ func OP_8XY3() {
 	regXIndex := (chip8.Cpu.Opcode & 0x0F00) >> 8
 	regYIndex := (chip8.Cpu.Opcode & 0x00F0) >> 4
 	chip8.Cpu.Registers[regXIndex] ^= chip8.Cpu.Registers[regYIndex]
+	if chip8.Quirks.VFReset {
+		chip8.Cpu.Registers[0xF] = 0
+	}
 }
 
 // Add VY to VX. VF is set to 1 when there's a carry, and to 0 when there is not
+// The flag is set after the result, it wins when X is F
 func OP_8XY4() {
 	regXIndex := (chip8.Cpu.Opcode & 0x0F00) >> 8
 	regYIndex := (chip8.Cpu.Opcode & 0x00F0) >> 4
 	newRegX := chip8.Cpu.Registers[regXIndex] + chip8.Cpu.Registers[regYIndex]
 	//Overflow detection
+	carry := Register(0)
 	if newRegX < chip8.Cpu.Registers[regXIndex] {
-		chip8.Cpu.Registers[0xF] = 1
-	} else {
-		chip8.Cpu.Registers[0xF] = 0
+		carry = 1
 	}
 	//Set register X
 	chip8.Cpu.Registers[regXIndex] = newRegX
+	chip8.Cpu.Registers[0xF] = carry
 }
 
 // VY is subtracted from VX. VF is set to 0 when there's a borrow, and 1 when there is not
@@ -204,21 +296,21 @@ func OP_8XY5() {
 	regXIndex := (chip8.Cpu.Opcode & 0x0F00) >> 8
 	regYIndex := (chip8.Cpu.Opcode & 0x00F0) >> 4
 	//Negative result, set last register to 0
+	noBorrow := Register(1)
 	if chip8.Cpu.Registers[regXIndex] < chip8.Cpu.Registers[regYIndex] {
-		chip8.Cpu.Registers[0xF] = 0
-	} else {
-		chip8.Cpu.Registers[0xF] = 1
+		noBorrow = 0
 	}
 	chip8.Cpu.Registers[regXIndex] -= chip8.Cpu.Registers[regYIndex]
+	chip8.Cpu.Registers[0xF] = noBorrow
 }
 
 // Store the least significant bit of VX in VF and then shifts VX to the right by 1
-// Ignore VY like CHIP-48 and SCHIP implementations
+// Ignore VY like CHIP-48 and SCHIP implementations unless the ShiftVY quirk is set
 func OP_8XY6() {
 	regXIndex := (chip8.Cpu.Opcode & 0x0F00) >> 8
-	lsb := Register(chip8.Cpu.Opcode & 1)
-	chip8.Cpu.Registers[regXIndex] >>= 1
-	chip8.Cpu.Registers[0xF] = lsb
+	value := shiftedRegister()
+	chip8.Cpu.Registers[regXIndex] = value >> 1
+	chip8.Cpu.Registers[0xF] = value & 1
 }
 
 // Set VX to VY minus VX. VF is set to 0 when there's a borrow, and 1 when there is not
@@ -226,21 +318,29 @@ func OP_8XY7() {
 	regXIndex := (chip8.Cpu.Opcode & 0x0F00) >> 8
 	regYIndex := (chip8.Cpu.Opcode & 0x00F0) >> 4
 	//Negative result, set last register to 0
+	noBorrow := Register(1)
 	if chip8.Cpu.Registers[regYIndex] < chip8.Cpu.Registers[regXIndex] {
-		chip8.Cpu.Registers[0xF] = 0
-	} else {
-		chip8.Cpu.Registers[0xF] = 1
+		noBorrow = 0
 	}
 	chip8.Cpu.Registers[regXIndex] = chip8.Cpu.Registers[regYIndex] - chip8.Cpu.Registers[regXIndex]
-
+	chip8.Cpu.Registers[0xF] = noBorrow
 }
 
 // Store the most significant bit of VX in VF and then shifts VX to the left by 1
+// Ignore VY like 8XY6 unless the ShiftVY quirk is set
 func OP_8XYE() {
 	regXIndex := (chip8.Cpu.Opcode & 0x0F00) >> 8
+	value := shiftedRegister()
+	chip8.Cpu.Registers[regXIndex] = value << 1
+	chip8.Cpu.Registers[0xF] = value >> 7
+}
 
-	chip8.Cpu.Registers[0xF] = chip8.Cpu.Registers[regXIndex] & 0xF0
-	chip8.Cpu.Registers[regXIndex] <<= 1
+// Register shifted by 8XY6 and 8XYE, VY on the COSMAC VIP and VX on the later interpreters
+func shiftedRegister() Register {
+	if chip8.Quirks.ShiftVY {
+		return chip8.Cpu.Registers[(chip8.Cpu.Opcode&0x00F0)>>4]
+	}
+	return chip8.Cpu.Registers[(chip8.Cpu.Opcode&0x0F00)>>8]
 }
 
 // Skip the next instruction if VX does not equal VY. (Usually the next instruction is a jump to skip a code block)
@@ -257,10 +357,15 @@ func OP_ANNN() {
 	chip8.Cpu.IndexRegister = IndexRegister(chip8.Cpu.Opcode & 0x0FFF)
 }
 
-// Jump to the address NNN plus V0
+// Jump to the address NNN plus V0, XNN plus VX with the JumpVX quirk
 func OP_BNNN() {
 	address := chip8.Cpu.Opcode & 0x0FFF
-	chip8.Cpu.ProgramCounter = ProgramCounter(address + Opcode(chip8.Cpu.Registers[0]))
+	regIndex := Opcode(0)
+	if chip8.Quirks.JumpVX {
+		regIndex = (chip8.Cpu.Opcode & 0x0F00) >> 8
+	}
+	//Wrap around the 12 bit address space
+	chip8.Cpu.ProgramCounter = ProgramCounter((address + Opcode(chip8.Cpu.Registers[regIndex])) & 0x0FFF)
 }
 
 // Set VX to the result of a bitwise and operation on a random number (Typically: 0 to 255) and NN
@@ -278,42 +383,49 @@ set to 1 if any screen pixels and sprite pixels are on at the same position othe
 set to 0. This is used for collision detection.
 */
 func OP_DXYN() {
-	log.Print("Draw sprite instruction called!")
 	regXIndex := (chip8.Cpu.Opcode & 0x0F00) >> 8
 	regYIndex := (chip8.Cpu.Opcode & 0x00F0) >> 4
 	pixelNum := chip8.Cpu.Opcode & 0x000F
 	startAddress := chip8.Cpu.IndexRegister
-	posX := uint8(chip8.Cpu.Registers[regXIndex])
-	posY := uint8(chip8.Cpu.Registers[regYIndex])
+	//The start position wraps, the pixels past the edges wrap or are clipped
+	posX := uint8(chip8.Cpu.Registers[regXIndex]) % WIDTH
+	posY := uint8(chip8.Cpu.Registers[regYIndex]) % HEIGHT
 	isCollided := uint8(0)
 	chip8.Cpu.Registers[0xF] = 0
 	//Iterate over sprite in the memory
 	for i := uint8(0); i < uint8(pixelNum); i++ {
+		if chip8.Quirks.Clipping && int(posY)+int(i) >= HEIGHT {
+			break
+		}
 		//8 pixels are loaded
-		pixelBits := chip8.Cpu.Memory[uint16(startAddress)+uint16(i)]
-		log.Printf("Pixel bits: 0x%X", pixelBits)
+		pixelBits := readMemory(uint16(startAddress) + uint16(i))
+		tracef(TRACE_DRAW, TRACE_DEBUG, "row %d: %08b", i, pixelBits)
 		for j := uint8(0); j < 8; j++ {
 			//Get left most bit
 			bit := uint8((pixelBits & 0x80) >> 7)
+			pixelBits = pixelBits << 1
+			if chip8.Quirks.Clipping && int(posX)+int(j) >= WIDTH {
+				continue
+			}
 			//Collision
 			if bit == 1 && chip8.Display[(posX+j)%WIDTH][(posY+i)%HEIGHT] == 1 {
 				isCollided = 1
 			}
 			//Limit indicies to prevent overflow
 			chip8.Display[(posX+j)%WIDTH][(posY+i)%HEIGHT] ^= bit
-			pixelBits = pixelBits << 1
-			log.Printf("Bit: 0x%X", bit)
-			log.Printf("Pixel bits in loop: 0x%X", pixelBits)
 		}
 	}
 	//Set the flip flag
 	chip8.Cpu.Registers[0xF] = Register(isCollided)
+	traceDisplayChanged()
+	tracef(TRACE_DRAW, TRACE_INFO, "sprite %d rows at %d,%d from 0x%03X collision=%d", pixelNum, posX, posY, startAddress, isCollided)
 }
 
 // Skip the next instruction if the key stored in VX is pressed (usually the next instruction is a jump to skip a code block)
 func OP_EX9E() {
 	regXIndex := (chip8.Cpu.Opcode & 0x0F00) >> 8
-	key := uint8(chip8.Cpu.Registers[regXIndex])
+	//Only the lowest nibble selects a key
+	key := uint8(chip8.Cpu.Registers[regXIndex]) & 0xF
 	//Key pressed
 	if chip8.Keypad[key] {
 		chip8.Cpu.ProgramCounter += 2
@@ -323,7 +435,7 @@ func OP_EX9E() {
 // Skip the next instruction if the key stored in VX is not pressed (usually the next instruction is a jump to skip a code block)
 func OP_EXA1() {
 	regXIndex := (chip8.Cpu.Opcode & 0x0F00) >> 8
-	key := uint8(chip8.Cpu.Registers[regXIndex])
+	key := uint8(chip8.Cpu.Registers[regXIndex]) & 0xF
 	//Key not pressed
 	if !chip8.Keypad[key] {
 		chip8.Cpu.ProgramCounter += 2
@@ -364,7 +476,7 @@ func OP_FX15() {
 func OP_FX18() {
 	regXIndex := (chip8.Cpu.Opcode & 0x0F00) >> 8
 	chip8.SoundTimer = SoundTimer(chip8.Cpu.Registers[regXIndex])
-	PlayAudio()
+	frontend.PlayAudio()
 }
 
 // Add VX to I. VF is not affected
@@ -376,7 +488,8 @@ func OP_FX1E() {
 // Set I to the location of the sprite for the character in VX. Characters 0-F (in hexadecimal) are represented by a 4x5 font
 func OP_FX29() {
 	regXIndex := (chip8.Cpu.Opcode & 0x0F00) >> 8
-	fontLocation := FONTSET_START_ADDRESS + uint16(chip8.Cpu.Registers[regXIndex]*5)
+	//Only the lowest nibble selects a character
+	fontLocation := FONTSET_START_ADDRESS + uint16(chip8.Cpu.Registers[regXIndex]&0xF)*5
 	chip8.Cpu.IndexRegister = IndexRegister(fontLocation)
 }
 
@@ -388,42 +501,51 @@ the tens digit at location I+1, and the ones digit at location I+2
 func OP_FX33() {
 	regXIndex := (chip8.Cpu.Opcode & 0x0F00) >> 8
 	num := uint8(chip8.Cpu.Registers[regXIndex])
+	address := uint16(chip8.Cpu.IndexRegister)
 	//255 -> 0010 0101 0101
 	//Ones
-	chip8.Cpu.Memory[chip8.Cpu.IndexRegister+2] = num % 10
+	writeMemory(address+2, num%10)
 	num /= 10
 	//Tens
-	chip8.Cpu.Memory[chip8.Cpu.IndexRegister+1] = num % 10
+	writeMemory(address+1, num%10)
 	num /= 10
 	//Hundreds
-	chip8.Cpu.Memory[chip8.Cpu.IndexRegister] = num % 10
+	writeMemory(address, num%10)
 	num /= 10
 }
 
 // Store from V0 to VX (including VX) in memory, starting at address I
 // The offset from I is increased by 1 for each value written, but I itself is left unmodified
+// unless the IncrementIndex quirk is set
 func OP_FX55() {
 	startAddress := chip8.Cpu.IndexRegister
 	regXIndex := uint8((chip8.Cpu.Opcode & 0x0F00) >> 8)
 	for i := uint8(0); i <= regXIndex; i++ {
-		chip8.Cpu.Memory[startAddress+IndexRegister(i)] = uint8(chip8.Cpu.Registers[i])
+		writeMemory(uint16(startAddress)+uint16(i), uint8(chip8.Cpu.Registers[i]))
+	}
+	if chip8.Quirks.IncrementIndex {
+		chip8.Cpu.IndexRegister += IndexRegister(regXIndex) + 1
 	}
 }
 
 // Fill from V0 to VX (including VX) with values from memory, starting at address I.
 // The offset from I is increased by 1 for each value read, but I itself is left unmodified
+// unless the IncrementIndex quirk is set
 func OP_FX65() {
 	startAddress := chip8.Cpu.IndexRegister
 	regXIndex := uint8((chip8.Cpu.Opcode & 0x0F00) >> 8)
 	for i := uint8(0); i <= regXIndex; i++ {
-		chip8.Cpu.Registers[i] = Register(chip8.Cpu.Memory[startAddress+IndexRegister(i)])
+		chip8.Cpu.Registers[i] = Register(readMemory(uint16(startAddress) + uint16(i)))
+	}
+	if chip8.Quirks.IncrementIndex {
+		chip8.Cpu.IndexRegister += IndexRegister(regXIndex) + 1
 	}
 }
 
 func fetch() {
 	//01010101 00000000 | 00000000 10101010 -> Opcodes are 2 byte each
 	chip8.Cpu.Opcode = Opcode(uint16(chip8.Cpu.Memory[chip8.Cpu.ProgramCounter])<<8 | uint16(chip8.Cpu.Memory[chip8.Cpu.ProgramCounter+1]))
-	log.Printf("Fetched Opcode: 0x%X", chip8.Cpu.Opcode)
+	coverInstruction(uint16(chip8.Cpu.ProgramCounter))
 }
 func decodeAndExecute() {
 	opcode := chip8.Cpu.Opcode
@@ -517,7 +639,20 @@ func decodeAndExecute() {
 }
 func halt() {
 	log.Print("Halting...")
-	os.Exit(1)
+	Shutdown(1)
+}
+
+// Close the frontend, finish the recording and write the reports before the emulator exits
+// Every exit of a running machine goes through here
+func Shutdown(code int) {
+	frontend.Close()
+	if err := StopRecording(); err != nil {
+		log.Print("Recording failed! ", err)
+	}
+	StopProfile()
+	StopCoverage()
+	StopTrace()
+	os.Exit(code)
 }
 
 func checkRomSize(romData *[]byte) error {
@@ -528,14 +663,21 @@ func checkRomSize(romData *[]byte) error {
 	return nil
 }
 func loadRom(filePath string) error {
-	romData, err := ReadFile(filePath)
+	romData, err := ReadRom(filePath)
 	if err == nil {
-		err = checkRomSize(&romData)
-		if err == nil {
-			//Push rom into memory
-			copy(chip8.Cpu.Memory[START_ADDRESS:], romData)
-			log.Printf(`%v rom loaded successfully!`, filePath)
-		}
+		err = loadRomData(filePath, romData)
+	}
+	return err
+}
+
+// Push the rom image into memory, the name is the file it came from
+func loadRomData(name string, romData []byte) error {
+	err := checkRomSize(&romData)
+	if err == nil {
+		copy(chip8.Cpu.Memory[START_ADDRESS:], romData)
+		chip8.Rom = name
+		chip8.RomHash = fmt.Sprintf("%x", sha1.Sum(romData))
+		log.Printf(`%v rom loaded successfully!`, name)
 	}
 	return err
 }
@@ -544,7 +686,7 @@ func loadFonts() {
 	log.Print("Fontset loaded successfully!")
 
 }
-func Boot(romPath string, displayScale int32, speed uint8) {
+func setup(romPath string, displayScale int32, speed uint8) {
 	log.SetFlags(4)
 	err := loadRom(romPath)
 	if err != nil {
@@ -555,38 +697,94 @@ func Boot(romPath string, displayScale int32, speed uint8) {
 	chip8.Cpu.Opcode = Opcode(0)
 	chip8.Speed = speed
 	DisplayScale = displayScale
-	StartDisplay(&chip8.Display)
+	frontend.Start(&chip8.Display)
+}
+
+// Speed of the headless runs, which don't go through setup
+func SetSpeed(speed uint8) {
+	chip8.Speed = speed
+}
+func Boot(romPath string, displayScale int32, speed uint8) {
+	setup(romPath, displayScale, speed)
+	loop()
+}
+
+// Boot with the interactive debugger, the machine starts paused
+// Logs are discarded to keep the REPL readable
+// Symbols are loaded from symbolsPath if set, otherwise from the .sym file next to the rom if it exists
+func Debug(romPath string, symbolsPath string, displayScale int32, speed uint8) {
+	setup(romPath, displayScale, speed)
+	log.SetOutput(io.Discard)
+	present(true)
+	debugger = NewDebugger()
+	if symbolsPath == "" {
+		symbolsPath = strings.TrimSuffix(romPath, filepath.Ext(romPath)) + ".sym"
+		if _, err := os.Stat(symbolsPath); err != nil {
+			symbolsPath = ""
+		}
+	}
+	if symbolsPath != "" {
+		if err := debugger.LoadSymbols(symbolsPath); err != nil {
+			fmt.Println(err)
+		}
+	}
+	go debugger.repl(os.Stdin)
+	control = debugger
 	loop()
 }
 func loop() {
 	start := time.Now()
 	for true {
 		if time.Since(start).Milliseconds() >= int64(chip8.Speed) {
-			log.Print("Running...")
-			cycle()
 			start = time.Now()
-			EventHandler(halt, &chip8.Keypad)
+			//Keep the window alive while the debugger is paused
+			if control != nil && !control.beforeCycle() {
+				present(true)
+				frontend.HandleEvents(halt, &chip8.Keypad)
+				continue
+			}
+			cycle()
+			if control != nil {
+				control.afterCycle()
+			}
+			if chip8.Fault != nil {
+				log.Print(chip8.Fault)
+				halt()
+			}
+			frontend.HandleEvents(halt, &chip8.Keypad)
 		}
 	}
 }
 func cycle() {
-	log.Println("Delay Timer:", chip8.DelayTimer)
-	log.Println("Sound Timer:", chip8.SoundTimer)
+	//A faulted machine stops
+	if chip8.Fault != nil {
+		return
+	}
+	chip8.Frame++
+	traceCycleStart()
 	if chip8.DelayTimer > 0 {
 		chip8.DelayTimer -= 1
+		tracef(TRACE_TIMER, TRACE_DEBUG, "delay=%d", chip8.DelayTimer)
+		if chip8.DelayTimer == 0 {
+			tracef(TRACE_TIMER, TRACE_INFO, "delay timer expired")
+		}
 	}
 	if chip8.SoundTimer > 0 {
 		chip8.SoundTimer -= 1
-		if chip8.SoundTimer <= 0{
-			PauseAudio()
+		tracef(TRACE_TIMER, TRACE_DEBUG, "sound=%d", chip8.SoundTimer)
+		if chip8.SoundTimer <= 0 {
+			tracef(TRACE_TIMER, TRACE_INFO, "sound timer expired")
+			frontend.PauseAudio()
 		}
 	}
-	fetch()
-	if chip8.Cpu.ProgramCounter+2 < ProgramCounter(len(chip8.Cpu.Memory)) {
-		chip8.Cpu.ProgramCounter += 2
-	} else {
-		log.Fatal("Reached to end of memory!!!")
+	if int(chip8.Cpu.ProgramCounter)+2 >= len(chip8.Cpu.Memory) {
+		chip8.Fault = errors.New("Reached to end of memory!!!")
+		return
 	}
+	fetch()
+	chip8.Cpu.ProgramCounter += 2
 	decodeAndExecute()
-	RenderDisplay(&chip8.Display)
+	traceInstructionEnd()
+	present(false)
+	recordCycle()
 }
@@ -0,0 +1,294 @@
+package chip8
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// One rom of a conformance suite, the suite file is a json array of them
+type ConformanceTest struct {
+	Name string `json:"name"`
+	// Paths are relative to the suite file
+	Rom    string     `json:"rom"`
+	Frames uint64     `json:"frames"`
+	Input  []KeyInput `json:"input,omitempty"`
+	// Golden image of the final display, rows of # and .
+	Golden string `json:"golden,omitempty"`
+	// DisplayHash of the final display, used when there is no golden image
+	Hash string `json:"hash,omitempty"`
+	// Quirk profile, modern by default
+	Quirks string `json:"quirks,omitempty"`
+}
+
+// Keys held down from the frame until the next input, hex digits, empty releases every key
+type KeyInput struct {
+	Frame uint64 `json:"frame"`
+	Keys  string `json:"keys"`
+}
+
+type ConformanceResult struct {
+	Test    ConformanceTest
+	Passed  bool
+	Message string
+	Display Display
+	// Golden image of a failed comparison
+	Expected *Display
+	Duration time.Duration
+}
+
+// Read the suite and resolve its paths
+func ReadConformanceSuite(path string) ([]ConformanceTest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tests []ConformanceTest
+	if err := json.Unmarshal(data, &tests); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	dir := filepath.Dir(path)
+	for i := range tests {
+		tests[i].Rom = filepath.Join(dir, tests[i].Rom)
+		if tests[i].Golden != "" {
+			tests[i].Golden = filepath.Join(dir, tests[i].Golden)
+		}
+		if tests[i].Name == "" {
+			tests[i].Name = filepath.Base(tests[i].Rom)
+		}
+	}
+	return tests, nil
+}
+
+// One row per display line, lit pixels are #
+func DisplayRows(display *Display) []string {
+	rows := make([]string, HEIGHT)
+	for y := range rows {
+		var row strings.Builder
+		for x := 0; x < WIDTH; x++ {
+			if display[x][y] != 0 {
+				row.WriteByte('#')
+			} else {
+				row.WriteByte('.')
+			}
+		}
+		rows[y] = row.String()
+	}
+	return rows
+}
+
+func ReadGoldenImage(path string) (*Display, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rows := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(rows) != HEIGHT {
+		return nil, fmt.Errorf("%s: %d rows, expected %d", path, len(rows), HEIGHT)
+	}
+	display := &Display{}
+	for y, row := range rows {
+		row = strings.TrimRight(row, "\r")
+		if len(row) != WIDTH {
+			return nil, fmt.Errorf("%s:%d: %d columns, expected %d", path, y+1, len(row), WIDTH)
+		}
+		for x := 0; x < WIDTH; x++ {
+			if row[x] == '#' {
+				display[x][y] = 1
+			}
+		}
+	}
+	return display, nil
+}
+
+func WriteGoldenImage(path string, display *Display) error {
+	return os.WriteFile(path, []byte(strings.Join(DisplayRows(display), "\n")+"\n"), 0644)
+}
+
+// Clear the machine for the next rom, the speed and the quirks are kept
+func reset() {
+	*chip8 = Chip8{Cpu: CPU{ProgramCounter: ProgramCounter(START_ADDRESS)}, Speed: chip8.Speed, Quirks: chip8.Quirks}
+	resetSmoothing()
+}
+
+// Run the rom headless for the frames and compare the final display, a frame is
+// one cycle of the loop which ticks the timers and renders the display
+// The golden image is written instead of compared when isUpdate is set
+func RunConformanceTest(test ConformanceTest, isUpdate bool) ConformanceResult {
+	result := ConformanceResult{Test: test}
+	start := time.Now()
+	profile := test.Quirks
+	if profile == "" {
+		profile = DEFAULT_QUIRK_PROFILE
+	}
+	if err := SetQuirkProfile(profile); err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	frontend = headlessFrontend{}
+	reset()
+	if err := runMovie(test.Rom, test.Frames, test.Input); err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Display = chip8.Display
+	result.Duration = time.Since(start)
+	if chip8.Fault != nil {
+		result.Message = chip8.Fault.Error()
+		return result
+	}
+	switch {
+	case test.Golden != "" && isUpdate:
+		if err := WriteGoldenImage(test.Golden, &result.Display); err != nil {
+			result.Message = err.Error()
+			return result
+		}
+		result.Passed = true
+		result.Message = "updated " + test.Golden
+	case test.Golden != "":
+		expected, err := ReadGoldenImage(test.Golden)
+		if err != nil {
+			result.Message = err.Error()
+			return result
+		}
+		result.Passed, result.Message = compareDisplays(expected, &result.Display)
+		if !result.Passed {
+			result.Expected = expected
+		}
+	case test.Hash != "":
+		hash := DisplayHash(&result.Display)
+		result.Passed = hash == test.Hash
+		if !result.Passed {
+			result.Message = fmt.Sprintf("display hash %s, expected %s", hash, test.Hash)
+		}
+	default:
+		result.Message = fmt.Sprintf("no golden image or hash, display hash %s", DisplayHash(&result.Display))
+	}
+	return result
+}
+
+// Set the keypad to the pressed keys
+func pressKeys(keys string) error {
+	chip8.Keypad = Keypad{}
+	for _, key := range keys {
+		index, err := strconv.ParseUint(string(key), 16, 4)
+		if err != nil {
+			return fmt.Errorf("Invalid key %q", key)
+		}
+		chip8.Keypad[index] = true
+	}
+	return nil
+}
+
+func compareDisplays(expected *Display, actual *Display) (bool, string) {
+	differences := 0
+	first := ""
+	for y := 0; y < HEIGHT; y++ {
+		for x := 0; x < WIDTH; x++ {
+			if expected[x][y] != actual[x][y] {
+				if differences == 0 {
+					first = fmt.Sprintf("%d,%d", x, y)
+				}
+				differences++
+			}
+		}
+	}
+	if differences == 0 {
+		return true, ""
+	}
+	return false, fmt.Sprintf("%d pixels differ from the golden image, first at %s", differences, first)
+}
+
+// Write a line per rom, failed golden comparisons are followed by the display with
+// + for the extra pixels and - for the missing ones
+func WriteConformanceResults(w io.Writer, results []ConformanceResult) {
+	passed := 0
+	for _, result := range results {
+		status := "FAIL"
+		if result.Passed {
+			status = "PASS"
+			passed++
+		}
+		fmt.Fprintf(w, "%s  %s (%d frames, %v)", status, result.Test.Name, result.Test.Frames, result.Duration.Round(time.Microsecond))
+		if result.Message != "" {
+			fmt.Fprintf(w, ": %s", result.Message)
+		}
+		fmt.Fprintln(w)
+		if result.Expected != nil {
+			for y := 0; y < HEIGHT; y++ {
+				var row strings.Builder
+				for x := 0; x < WIDTH; x++ {
+					switch {
+					case result.Display[x][y] == result.Expected[x][y] && result.Display[x][y] != 0:
+						row.WriteByte('#')
+					case result.Display[x][y] == result.Expected[x][y]:
+						row.WriteByte('.')
+					case result.Display[x][y] != 0:
+						row.WriteByte('+')
+					default:
+						row.WriteByte('-')
+					}
+				}
+				fmt.Fprintf(w, "      %s\n", row.String())
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d passed, %d failed\n", passed, len(results)-passed)
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+// JUnit XML report for CI
+func WriteJUnit(w io.Writer, name string, results []ConformanceResult) error {
+	suite := junitTestSuite{Name: name, Tests: len(results)}
+	total := time.Duration(0)
+	for _, result := range results {
+		testCase := junitTestCase{
+			Name:      result.Test.Name,
+			ClassName: name,
+			Time:      fmt.Sprintf("%.6f", result.Duration.Seconds()),
+		}
+		if !result.Passed {
+			suite.Failures++
+			testCase.Failure = &junitFailure{Message: result.Message, Text: strings.Join(DisplayRows(&result.Display), "\n")}
+		}
+		total += result.Duration
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+	suite.Time = fmt.Sprintf("%.6f", total.Seconds())
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,23 @@
+package chip8
+
+import (
+	"strings"
+	"testing"
+)
+
+// Regression gate over the test roms, run chip8 test -update after an intended display change
+func TestConformance(t *testing.T) {
+	tests, err := ReadConformanceSuite("../roms/conformance.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			result := RunConformanceTest(test, false)
+			if !result.Passed {
+				t.Errorf("%s\n%s", result.Message, strings.Join(DisplayRows(&result.Display), "\n"))
+			}
+		})
+	}
+}
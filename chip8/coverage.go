@@ -0,0 +1,299 @@
+package chip8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mehmetumit/CHIP-8/chip8/asm"
+	"github.com/mehmetumit/CHIP-8/chip8/disasm"
+)
+
+type CoverageConfig struct {
+	// Text report, - is stdout
+	ReportPath string
+	// LCOV file keyed by the assembler source lines, empty to skip
+	LcovPath string
+	// Stop after the number of cycles, 0 runs until the emulator exits
+	MaxCycles uint64
+}
+
+// Records how every rom byte was accessed, executed as an instruction, read as
+// data by DXYN, FX33 and FX65 or written
+type Coverage struct {
+	CoverageConfig
+	Symbols  *asm.Symbols
+	rom      []byte
+	accesses []MemoryAccess
+	// Executions of the instructions starting at the rom offsets
+	executions []uint64
+	cycles     uint64
+}
+
+// Nil when coverage is off
+var coverage *Coverage
+
+// Address range of rom bytes
+type coverageRegion struct {
+	start uint16
+	end   uint16
+}
+
+// Boot recording the coverage, the reports are written when the emulator exits
+// or after the maximum cycles
+// Symbols are loaded from symbolsPath if set, otherwise from the .sym file next to the rom if it exists
+func Cover(romPath string, symbolsPath string, config CoverageConfig, displayScale int32, speed uint8) {
+	setup(romPath, displayScale, speed)
+	rom, err := ReadRom(romPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	coverage = NewCoverage(config, rom)
+	if symbolsPath == "" {
+		symbolsPath = strings.TrimSuffix(romPath, filepath.Ext(romPath)) + ".sym"
+		if _, err := os.Stat(symbolsPath); err != nil {
+			symbolsPath = ""
+		}
+	}
+	if symbolsPath != "" {
+		symbols, err := asm.ReadSymbolsFile(symbolsPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		coverage.Symbols = symbols
+	}
+	control = coverage
+	loop()
+}
+
+func NewCoverage(config CoverageConfig, rom []byte) *Coverage {
+	return &Coverage{
+		CoverageConfig: config,
+		rom:            rom,
+		accesses:       make([]MemoryAccess, len(rom)),
+		executions:     make([]uint64, len(rom)),
+	}
+}
+
+// Rom offset of an address, -1 outside the rom
+func (c *Coverage) offset(address uint16) int {
+	offset := int(address) - int(START_ADDRESS)
+	if offset < 0 || offset >= len(c.rom) {
+		return -1
+	}
+	return offset
+}
+
+// Called by the memory accesses of the instructions
+func coverMemory(address uint16, access MemoryAccess) {
+	if coverage == nil {
+		return
+	}
+	if offset := coverage.offset(address); offset >= 0 {
+		coverage.accesses[offset] |= access
+	}
+}
+
+// Called by fetch, both bytes of the opcode are executed
+func coverInstruction(address uint16) {
+	if coverage == nil {
+		return
+	}
+	if offset := coverage.offset(address); offset >= 0 {
+		coverage.executions[offset]++
+	}
+	coverMemory(address, MEMORY_EXECUTE)
+	coverMemory(address+1, MEMORY_EXECUTE)
+}
+
+func (c *Coverage) beforeCycle() bool {
+	return true
+}
+
+func (c *Coverage) afterCycle() {
+	c.cycles++
+	if c.MaxCycles > 0 && c.cycles >= c.MaxCycles {
+		Shutdown(0)
+	}
+}
+
+// Write the reports
+func StopCoverage() {
+	if coverage == nil {
+		return
+	}
+	c := coverage
+	coverage = nil
+	lcovPath := c.LcovPath
+	if c.Symbols == nil && lcovPath != "" {
+		log.Print("No symbols, the LCOV file is skipped")
+		lcovPath = ""
+	}
+	writers := []struct {
+		path  string
+		write func(io.Writer)
+	}{{c.ReportPath, c.WriteReport}, {lcovPath, c.WriteLcov}}
+	for _, writer := range writers {
+		if writer.path == "" {
+			continue
+		}
+		if writer.path == "-" {
+			writer.write(os.Stdout)
+			continue
+		}
+		file, err := os.Create(writer.path)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		out := bufio.NewWriter(file)
+		writer.write(out)
+		out.Flush()
+		file.Close()
+	}
+}
+
+// Count the rom bytes with the access
+func (c *Coverage) count(access MemoryAccess) int {
+	count := 0
+	for _, accesses := range c.accesses {
+		if accesses&access != 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// Consecutive ranges of the rom bytes matching the predicate
+func (c *Coverage) regions(isIncluded func(offset int) bool) []coverageRegion {
+	var regions []coverageRegion
+	for offset := 0; offset < len(c.rom); offset++ {
+		if !isIncluded(offset) {
+			continue
+		}
+		address := START_ADDRESS + uint16(offset)
+		if len(regions) > 0 && regions[len(regions)-1].end+1 == address {
+			regions[len(regions)-1].end = address
+		} else {
+			regions = append(regions, coverageRegion{start: address, end: address})
+		}
+	}
+	return regions
+}
+
+// Label of an address, the symbols are preferred over the disassembler labels
+func (c *Coverage) label(program *disasm.Program, address uint16) string {
+	if c.Symbols != nil {
+		if label := c.Symbols.Label(address); label != "" {
+			return label
+		}
+	}
+	return program.Labels[address]
+}
+
+func (c *Coverage) WriteReport(w io.Writer) {
+	program := disasm.Trace(c.rom, START_ADDRESS)
+	size := len(c.rom)
+	fmt.Fprintf(w, "ROM: %d bytes at 0x%03X-0x%03X\n", size, START_ADDRESS, int(START_ADDRESS)+size-1)
+	executed := 0
+	for address := range program.Instructions {
+		if offset := c.offset(address); offset >= 0 && c.executions[offset] > 0 {
+			executed++
+		}
+	}
+	fmt.Fprintf(w, "Executed: %d bytes (%.1f%%), %d of %d reachable instructions (%.1f%%)\n",
+		c.count(MEMORY_EXECUTE), percent(uint64(c.count(MEMORY_EXECUTE)), uint64(size)),
+		executed, len(program.Instructions), percent(uint64(executed), uint64(len(program.Instructions))))
+	fmt.Fprintf(w, "Read: %d bytes (%.1f%%)\n", c.count(MEMORY_READ), percent(uint64(c.count(MEMORY_READ)), uint64(size)))
+	fmt.Fprintf(w, "Written: %d bytes (%.1f%%)\n", c.count(MEMORY_WRITE), percent(uint64(c.count(MEMORY_WRITE)), uint64(size)))
+
+	// Code found by the disassembler which never ran
+	fmt.Fprintf(w, "\nNever executed code:\n")
+	unexecuted := c.regions(func(offset int) bool {
+		return program.Kinds[offset] == disasm.BYTE_CODE && c.accesses[offset]&MEMORY_EXECUTE == 0
+	})
+	if len(unexecuted) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	for _, region := range unexecuted {
+		fmt.Fprintf(w, "0x%03X-0x%03X (%d bytes)\n", region.start, region.end, region.end-region.start+1)
+		for address := region.start; address <= region.end; address++ {
+			if _, isInstruction := program.Instructions[address]; !isInstruction {
+				continue
+			}
+			if label := c.label(program, address); label != "" {
+				fmt.Fprintf(w, "  %s:\n", label)
+			}
+			fmt.Fprintf(w, "    0x%03X: %04X  %s\n", address, uint16(opcodeAt(ProgramCounter(address))), Mnemonic(ProgramCounter(address)))
+		}
+	}
+
+	for _, access := range []struct {
+		flag MemoryAccess
+		name string
+	}{{MEMORY_READ, "Read"}, {MEMORY_WRITE, "Written"}} {
+		fmt.Fprintf(w, "\n%s data:\n", access.name)
+		regions := c.regions(func(offset int) bool {
+			return c.accesses[offset]&access.flag != 0
+		})
+		if len(regions) == 0 {
+			fmt.Fprintln(w, "  none")
+		}
+		for _, region := range regions {
+			fmt.Fprintf(w, "0x%03X-0x%03X (%d bytes)", region.start, region.end, region.end-region.start+1)
+			if label := c.label(program, region.start); label != "" {
+				fmt.Fprintf(w, " %s", label)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// LCOV tracefile of the source lines which emitted instructions, the hits of a
+// line are the executions of its most executed instruction
+func (c *Coverage) WriteLcov(w io.Writer) {
+	program := disasm.Trace(c.rom, START_ADDRESS)
+	hits := map[asm.SourceLine]uint64{}
+	for address, source := range c.Symbols.Lines {
+		offset := c.offset(address)
+		if offset < 0 {
+			continue
+		}
+		_, isInstruction := program.Instructions[address]
+		if !isInstruction && c.executions[offset] == 0 {
+			continue
+		}
+		if count, isFound := hits[source]; !isFound || c.executions[offset] > count {
+			hits[source] = c.executions[offset]
+		}
+	}
+	files := map[string][]int{}
+	for source := range hits {
+		files[source.File] = append(files[source.File], source.Line)
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "TN:")
+	for _, name := range names {
+		lines := files[name]
+		sort.Ints(lines)
+		fmt.Fprintf(w, "SF:%s\n", name)
+		covered := 0
+		for _, line := range lines {
+			count := hits[asm.SourceLine{File: name, Line: line}]
+			if count > 0 {
+				covered++
+			}
+			fmt.Fprintf(w, "DA:%d,%d\n", line, count)
+		}
+		fmt.Fprintf(w, "LH:%d\nLF:%d\nend_of_record\n", covered, len(lines))
+	}
+}
@@ -0,0 +1,709 @@
+package chip8
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mehmetumit/CHIP-8/chip8/asm"
+)
+
+// Variable references of the scopes
+const (
+	DAP_REGISTERS = 1 + iota
+	DAP_STACK
+	DAP_DISPLAY
+)
+
+const DAP_THREAD_ID = 1
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapSource struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type dapLaunchArguments struct {
+	Program     string `json:"program"`
+	Symbols     string `json:"symbols"`
+	StopOnEntry *bool  `json:"stopOnEntry"`
+	Quirks      string `json:"quirks"`
+}
+
+// Debug Adapter Protocol server, requests are read on their own goroutine and
+// handled by the emulation loop between cycles like the debugger commands
+type DAPServer struct {
+	// Breakpoints of every source file and the instruction breakpoints
+	sourceBreakpoints      map[string][]ProgramCounter
+	instructionBreakpoints []ProgramCounter
+	Breakpoints            map[ProgramCounter]bool
+	Symbols                *asm.Symbols
+	in                     io.Reader
+	out                    io.Writer
+	seq                    int
+	requests               chan *dapRequest
+	launch                 *dapLaunchArguments
+	paused                 bool
+	// Checked after every cycle while running
+	stopCondition  func() bool
+	stopReason     string
+	skipBreakpoint bool
+}
+
+// Serve on stdin and stdout if the address is empty, otherwise wait for a client on
+// a tcp address or a unix socket with the unix: prefix
+func ServeDAP(address string, displayScale int32, speed uint8) {
+	// Stdout may carry the protocol
+	log.SetOutput(io.Discard)
+	var in io.Reader = os.Stdin
+	var out io.Writer = os.Stdout
+	if address != "" {
+		conn, err := acceptOne(address)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		in, out = conn, conn
+	}
+	s := NewDAPServer(in, out)
+	// The machine is set up by the launch request
+	for s.launch == nil {
+		request, isOpen := <-s.requests
+		if !isOpen {
+			return
+		}
+		s.handle(request)
+	}
+	setup(s.launch.Program, displayScale, speed)
+	present(true)
+	s.event("initialized", nil)
+	control = s
+	loop()
+}
+
+func acceptOne(address string) (net.Conn, error) {
+	network := "tcp"
+	if strings.HasPrefix(address, "unix:") {
+		network, address = "unix", strings.TrimPrefix(address, "unix:")
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	fmt.Fprintln(os.Stderr, "Waiting for the editor on", listener.Addr())
+	return listener.Accept()
+}
+
+func NewDAPServer(in io.Reader, out io.Writer) *DAPServer {
+	s := &DAPServer{
+		sourceBreakpoints: map[string][]ProgramCounter{},
+		Breakpoints:       map[ProgramCounter]bool{},
+		in:                in,
+		out:               out,
+		requests:          make(chan *dapRequest),
+		paused:            true,
+	}
+	go s.read()
+	return s
+}
+
+// Messages have a Content-Length header followed by the json body
+func (s *DAPServer) read() {
+	r := textproto.NewReader(bufio.NewReader(s.in))
+	for {
+		header, err := r.ReadMIMEHeader()
+		if err != nil {
+			close(s.requests)
+			return
+		}
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			continue
+		}
+		body := make([]byte, length)
+		if _, err = io.ReadFull(r.R, body); err != nil {
+			close(s.requests)
+			return
+		}
+		request := &dapRequest{}
+		if json.Unmarshal(body, request) == nil {
+			s.requests <- request
+		}
+	}
+}
+
+func (s *DAPServer) send(message map[string]interface{}) {
+	s.seq++
+	message["seq"] = s.seq
+	body, _ := json.Marshal(message)
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *DAPServer) respond(request *dapRequest, body interface{}, err error) {
+	response := map[string]interface{}{
+		"type":        "response",
+		"request_seq": request.Seq,
+		"command":     request.Command,
+		"success":     err == nil,
+	}
+	if err != nil {
+		response["message"] = err.Error()
+	} else if body != nil {
+		response["body"] = body
+	}
+	s.send(response)
+}
+
+func (s *DAPServer) event(name string, body interface{}) {
+	event := map[string]interface{}{"type": "event", "event": name}
+	if body != nil {
+		event["body"] = body
+	}
+	s.send(event)
+}
+
+func (s *DAPServer) beforeCycle() bool {
+	for isPending := true; isPending; {
+		select {
+		case request, isOpen := <-s.requests:
+			if !isOpen {
+				Shutdown(0)
+			}
+			s.handle(request)
+		default:
+			isPending = false
+		}
+	}
+	if s.paused {
+		return false
+	}
+	if !s.skipBreakpoint && s.Breakpoints[chip8.Cpu.ProgramCounter] {
+		s.stop("breakpoint")
+		return false
+	}
+	s.skipBreakpoint = false
+	return true
+}
+
+func (s *DAPServer) afterCycle() {
+	if s.stopCondition != nil && s.stopCondition() {
+		s.stop(s.stopReason)
+	}
+}
+
+func (s *DAPServer) resume(condition func() bool, reason string) {
+	s.stopCondition = condition
+	s.stopReason = reason
+	s.skipBreakpoint = true
+	s.paused = false
+}
+
+func (s *DAPServer) stop(reason string) {
+	s.paused = true
+	s.stopCondition = nil
+	s.event("stopped", map[string]interface{}{"reason": reason, "threadId": DAP_THREAD_ID, "allThreadsStopped": true})
+}
+
+func (s *DAPServer) handle(request *dapRequest) {
+	var body interface{}
+	var err error
+	switch request.Command {
+	case "initialize":
+		body = map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsSetVariable":              true,
+			"supportsReadMemoryRequest":        true,
+			"supportsWriteMemoryRequest":       true,
+			"supportsDisassembleRequest":       true,
+			"supportsInstructionBreakpoints":   true,
+			"supportsSteppingGranularity":      true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		}
+	case "launch":
+		err = s.launchRequest(request.Arguments)
+	case "setBreakpoints":
+		body, err = s.setBreakpoints(request.Arguments)
+	case "setInstructionBreakpoints":
+		body, err = s.setInstructionBreakpoints(request.Arguments)
+	case "configurationDone":
+		s.respond(request, nil, nil)
+		if s.launch != nil && s.launch.StopOnEntry != nil && !*s.launch.StopOnEntry {
+			s.resume(nil, "")
+		} else {
+			s.stop("entry")
+		}
+		return
+	case "threads":
+		body = map[string]interface{}{"threads": []interface{}{map[string]interface{}{"id": DAP_THREAD_ID, "name": "CHIP-8"}}}
+	case "stackTrace":
+		body = s.stackTrace()
+	case "scopes":
+		body = map[string]interface{}{"scopes": []interface{}{
+			map[string]interface{}{"name": "Registers", "variablesReference": DAP_REGISTERS, "presentationHint": "registers"},
+			map[string]interface{}{"name": "Stack", "variablesReference": DAP_STACK},
+			map[string]interface{}{"name": "Display", "variablesReference": DAP_DISPLAY, "expensive": true},
+		}}
+	case "variables":
+		body, err = s.variables(request.Arguments)
+	case "setVariable":
+		body, err = s.setVariable(request.Arguments)
+	case "evaluate":
+		body, err = s.evaluate(request.Arguments)
+	case "readMemory":
+		body, err = readDAPMemory(request.Arguments)
+	case "writeMemory":
+		body, err = writeDAPMemory(request.Arguments)
+	case "disassemble":
+		body, err = s.disassemble(request.Arguments)
+	case "continue":
+		body = map[string]interface{}{"allThreadsContinued": true}
+		s.respond(request, body, nil)
+		s.resume(nil, "")
+		return
+	case "next", "stepIn", "stepOut":
+		err = s.step(request.Command, request.Arguments)
+	case "pause":
+		s.respond(request, nil, nil)
+		if !s.paused {
+			s.stop("pause")
+		}
+		return
+	case "disconnect", "terminate":
+		s.respond(request, nil, nil)
+		s.event("terminated", nil)
+		Shutdown(0)
+	default:
+		err = fmt.Errorf("Unsupported request %s", request.Command)
+	}
+	s.respond(request, body, err)
+}
+
+func (s *DAPServer) launchRequest(arguments json.RawMessage) error {
+	launch := &dapLaunchArguments{}
+	if err := json.Unmarshal(arguments, launch); err != nil {
+		return err
+	}
+	if launch.Program == "" {
+		return fmt.Errorf("Launch needs the program path of the rom")
+	}
+	// Read and compile errors are answered here, setup would panic on them
+	if _, err := ReadRom(launch.Program); err != nil {
+		return err
+	}
+	if launch.Quirks != "" {
+		if err := SetQuirkProfile(launch.Quirks); err != nil {
+			return err
+		}
+	}
+	symbolsPath := launch.Symbols
+	if symbolsPath == "" {
+		symbolsPath = strings.TrimSuffix(launch.Program, filepath.Ext(launch.Program)) + ".sym"
+		if _, err := os.Stat(symbolsPath); err != nil {
+			symbolsPath = ""
+		}
+	}
+	if symbolsPath != "" {
+		symbols, err := asm.ReadSymbolsFile(symbolsPath)
+		if err != nil {
+			return err
+		}
+		s.Symbols = symbols
+	}
+	s.launch = launch
+	return nil
+}
+
+// Return the file name of the symbols which is the same file with the path
+func (s *DAPServer) symbolsFile(path string) string {
+	if s.Symbols == nil {
+		return ""
+	}
+	absolutePath, _ := filepath.Abs(path)
+	found := ""
+	for _, source := range s.Symbols.Lines {
+		if absoluteSource, _ := filepath.Abs(source.File); absoluteSource == absolutePath {
+			return source.File
+		}
+		if filepath.Base(source.File) == filepath.Base(path) {
+			found = source.File
+		}
+	}
+	return found
+}
+
+func (s *DAPServer) updateBreakpoints() {
+	s.Breakpoints = map[ProgramCounter]bool{}
+	for _, addresses := range s.sourceBreakpoints {
+		for _, address := range addresses {
+			s.Breakpoints[address] = true
+		}
+	}
+	for _, address := range s.instructionBreakpoints {
+		s.Breakpoints[address] = true
+	}
+}
+
+// Source lines are mapped to the first address they emitted by the assembler symbols
+func (s *DAPServer) setBreakpoints(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Source      dapSource `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	file := s.symbolsFile(args.Source.Path)
+	var addresses []ProgramCounter
+	breakpoints := []interface{}{}
+	for _, requested := range args.Breakpoints {
+		breakpoint := map[string]interface{}{"verified": false, "line": requested.Line}
+		if file == "" {
+			breakpoint["message"] = "No symbols for this file, assemble it with chip8 asm"
+		} else if lineAddresses := s.Symbols.Addresses(file, requested.Line); len(lineAddresses) == 0 {
+			breakpoint["message"] = "No code at this line"
+		} else {
+			address := ProgramCounter(lineAddresses[0])
+			addresses = append(addresses, address)
+			breakpoint["verified"] = true
+			breakpoint["instructionReference"] = fmt.Sprintf("0x%03X", address)
+		}
+		breakpoints = append(breakpoints, breakpoint)
+	}
+	s.sourceBreakpoints[args.Source.Path] = addresses
+	s.updateBreakpoints()
+	return map[string]interface{}{"breakpoints": breakpoints}, nil
+}
+
+func (s *DAPServer) setInstructionBreakpoints(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Breakpoints []struct {
+			InstructionReference string `json:"instructionReference"`
+			Offset               int    `json:"offset"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	s.instructionBreakpoints = nil
+	breakpoints := []interface{}{}
+	for _, requested := range args.Breakpoints {
+		address, err := parseAddress(requested.InstructionReference, s.Symbols)
+		address += ProgramCounter(requested.Offset)
+		if err != nil || int(address) >= len(chip8.Cpu.Memory) {
+			breakpoints = append(breakpoints, map[string]interface{}{"verified": false, "message": "Invalid address"})
+			continue
+		}
+		s.instructionBreakpoints = append(s.instructionBreakpoints, address)
+		breakpoints = append(breakpoints, map[string]interface{}{"verified": true, "instructionReference": fmt.Sprintf("0x%03X", address)})
+	}
+	s.updateBreakpoints()
+	return map[string]interface{}{"breakpoints": breakpoints}, nil
+}
+
+// Name of the subroutine containing the address, the closest global label before it
+func (s *DAPServer) subroutineName(address ProgramCounter) string {
+	name, start := "", -1
+	if s.Symbols != nil {
+		for label, labelAddress := range s.Symbols.Labels {
+			if !strings.Contains(label, ".") && labelAddress <= uint16(address) && int(labelAddress) >= start &&
+				(int(labelAddress) > start || label < name) {
+				name, start = label, int(labelAddress)
+			}
+		}
+	}
+	if name == "" {
+		return fmt.Sprintf("0x%03X", address)
+	}
+	return name
+}
+
+func (s *DAPServer) frame(id int, address ProgramCounter) map[string]interface{} {
+	frame := map[string]interface{}{
+		"id":                          id,
+		"name":                        s.subroutineName(address),
+		"line":                        0,
+		"column":                      0,
+		"instructionPointerReference": fmt.Sprintf("0x%03X", address),
+	}
+	if s.Symbols != nil {
+		if source, isExists := s.Symbols.Lines[uint16(address)]; isExists {
+			path, _ := filepath.Abs(source.File)
+			frame["source"] = dapSource{Name: filepath.Base(source.File), Path: path}
+			frame["line"] = source.Line
+			frame["column"] = 1
+		}
+	}
+	return frame
+}
+
+// The current instruction and the calls of the program stack, innermost first
+func (s *DAPServer) stackTrace() interface{} {
+	frames := []interface{}{s.frame(0, chip8.Cpu.ProgramCounter)}
+	for i := int(chip8.Cpu.StackPointer) - 1; i >= 0 && i < len(chip8.Cpu.ProgramStack); i-- {
+		// The stack holds return addresses, the call is the instruction before
+		frames = append(frames, s.frame(len(frames), chip8.Cpu.ProgramStack[i]-2))
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+func dapVariable(name string, value int, digits int) map[string]interface{} {
+	return map[string]interface{}{
+		"name":               name,
+		"value":              fmt.Sprintf("0x%0*X (%d)", digits, value, value),
+		"variablesReference": 0,
+	}
+}
+
+func (s *DAPServer) variables(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	variables := []interface{}{}
+	switch args.VariablesReference {
+	case DAP_REGISTERS:
+		for i, register := range chip8.Cpu.Registers {
+			variables = append(variables, dapVariable(fmt.Sprintf("V%X", i), int(register), 2))
+		}
+		index := dapVariable("I", int(chip8.Cpu.IndexRegister), 3)
+		index["memoryReference"] = fmt.Sprintf("0x%03X", chip8.Cpu.IndexRegister)
+		pc := dapVariable("PC", int(chip8.Cpu.ProgramCounter), 3)
+		pc["memoryReference"] = fmt.Sprintf("0x%03X", chip8.Cpu.ProgramCounter)
+		variables = append(variables, index, pc,
+			dapVariable("SP", int(chip8.Cpu.StackPointer), 2),
+			dapVariable("DT", int(chip8.DelayTimer), 2),
+			dapVariable("ST", int(chip8.SoundTimer), 2))
+	case DAP_STACK:
+		for i := 0; i < int(chip8.Cpu.StackPointer) && i < len(chip8.Cpu.ProgramStack); i++ {
+			variables = append(variables, dapVariable(fmt.Sprintf("S%X", i), int(chip8.Cpu.ProgramStack[i]), 3))
+		}
+	case DAP_DISPLAY:
+		// One string per row, lit pixels are #
+		for y, row := range DisplayRows(&chip8.Display) {
+			variables = append(variables, map[string]interface{}{
+				"name":               fmt.Sprintf("%02d", y),
+				"value":              row,
+				"variablesReference": 0,
+			})
+		}
+	default:
+		return nil, fmt.Errorf("Unknown variables reference %d", args.VariablesReference)
+	}
+	return map[string]interface{}{"variables": variables}, nil
+}
+
+func (s *DAPServer) setVariable(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	err := setTarget(args.Name, strings.TrimSpace(args.Value), func(text string) (ProgramCounter, error) {
+		return parseAddress(text, s.Symbols)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"value": strings.TrimSpace(args.Value)}, nil
+}
+
+func (s *DAPServer) symbolAddress(name string) (uint16, bool) {
+	if s.Symbols == nil {
+		return 0, false
+	}
+	address, isExists := s.Symbols.Labels[name]
+	return address, isExists
+}
+
+// Expressions of the conditional breakpoints, labels evaluate to their address
+func (s *DAPServer) evaluate(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	if address, isLabel := s.symbolAddress(args.Expression); isLabel {
+		return map[string]interface{}{
+			"result":             fmt.Sprintf("0x%03X", address),
+			"memoryReference":    fmt.Sprintf("0x%03X", address),
+			"variablesReference": 0,
+		}, nil
+	}
+	expression, err := ParseExpression(args.Expression)
+	if err != nil {
+		return nil, err
+	}
+	value := expression.Eval()
+	return map[string]interface{}{"result": fmt.Sprintf("0x%X (%d)", value, value), "variablesReference": 0}, nil
+}
+
+// next steps over calls, stepOut runs until the subroutine returns
+// Without instruction granularity steps run until the source line changes
+func (s *DAPServer) step(command string, arguments json.RawMessage) error {
+	var args struct {
+		Granularity string `json:"granularity"`
+	}
+	json.Unmarshal(arguments, &args)
+	startLine, hasSource := asm.SourceLine{}, false
+	if s.Symbols != nil && args.Granularity != "instruction" {
+		startLine, hasSource = s.Symbols.Lines[uint16(chip8.Cpu.ProgramCounter)]
+	}
+	// Stop on another source line, or on any instruction without symbols
+	isNewLine := func() bool {
+		if !hasSource {
+			return true
+		}
+		line, isExists := s.Symbols.Lines[uint16(chip8.Cpu.ProgramCounter)]
+		return isExists && line != startLine
+	}
+	depth := chip8.Cpu.StackPointer
+	switch command {
+	case "stepIn":
+		s.resume(isNewLine, "step")
+	case "next":
+		s.resume(func() bool {
+			return chip8.Cpu.StackPointer <= depth && isNewLine()
+		}, "step")
+	case "stepOut":
+		if depth == 0 {
+			return fmt.Errorf("Not inside a subroutine")
+		}
+		s.resume(func() bool {
+			return chip8.Cpu.StackPointer < depth
+		}, "step")
+	}
+	return nil
+}
+
+// Memory references are addresses, e.g. 0x200
+func parseDAPMemoryRange(reference string, offset int, count int) (int, int, error) {
+	address, err := parseNumber(reference, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	start := int(address) + offset
+	if start < 0 || start > len(chip8.Cpu.Memory) {
+		return 0, 0, fmt.Errorf("Address 0x%X is out of memory", start)
+	}
+	if count < 0 || start+count > len(chip8.Cpu.Memory) {
+		count = len(chip8.Cpu.Memory) - start
+	}
+	return start, count, nil
+}
+
+func readDAPMemory(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int    `json:"offset"`
+		Count           int    `json:"count"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	start, count, err := parseDAPMemoryRange(args.MemoryReference, args.Offset, args.Count)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"address":         fmt.Sprintf("0x%03X", start),
+		"data":            base64.StdEncoding.EncodeToString(chip8.Cpu.Memory[start : start+count]),
+		"unreadableBytes": args.Count - count,
+	}, nil
+}
+
+func writeDAPMemory(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int    `json:"offset"`
+		Data            string `json:"data"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(args.Data)
+	if err != nil {
+		return nil, err
+	}
+	start, count, err := parseDAPMemoryRange(args.MemoryReference, args.Offset, len(data))
+	if err != nil {
+		return nil, err
+	}
+	copy(chip8.Cpu.Memory[start:start+count], data)
+	return map[string]interface{}{"offset": start - args.Offset, "bytesWritten": count}, nil
+}
+
+// Instructions are 2 bytes, addresses out of memory are shown as invalid
+func (s *DAPServer) disassemble(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		MemoryReference   string `json:"memoryReference"`
+		Offset            int    `json:"offset"`
+		InstructionOffset int    `json:"instructionOffset"`
+		InstructionCount  int    `json:"instructionCount"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	reference, err := parseNumber(args.MemoryReference, 16)
+	if err != nil {
+		return nil, err
+	}
+	start := int(reference) + args.Offset + 2*args.InstructionOffset
+	// The count comes from the client, more instructions than fit into the memory are not listed
+	count := args.InstructionCount
+	if count > len(chip8.Cpu.Memory)/2 {
+		count = len(chip8.Cpu.Memory) / 2
+	}
+	instructions := []interface{}{}
+	for i := 0; i < count; i++ {
+		address := start + 2*i
+		if address < 0 || address+1 >= len(chip8.Cpu.Memory) {
+			instructions = append(instructions, map[string]interface{}{
+				"address":          fmt.Sprintf("0x%03X", address&0xFFFF),
+				"instruction":      "",
+				"presentationHint": "invalid",
+			})
+			continue
+		}
+		pc := ProgramCounter(address)
+		instruction := map[string]interface{}{
+			"address":          fmt.Sprintf("0x%03X", address),
+			"instructionBytes": fmt.Sprintf("%04X", uint16(opcodeAt(pc))),
+			"instruction":      Mnemonic(pc),
+		}
+		if s.Symbols != nil {
+			if label := s.Symbols.Label(uint16(pc)); label != "" {
+				instruction["symbol"] = label
+			}
+			if source, isExists := s.Symbols.Lines[uint16(pc)]; isExists {
+				path, _ := filepath.Abs(source.File)
+				instruction["location"] = dapSource{Name: filepath.Base(source.File), Path: path}
+				instruction["line"] = source.Line
+			}
+		}
+		instructions = append(instructions, instruction)
+	}
+	return map[string]interface{}{"instructions": instructions}, nil
+}
@@ -0,0 +1,360 @@
+package chip8
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mehmetumit/CHIP-8/chip8/asm"
+)
+
+// A rom which can't be read or compiled fails the launch instead of the adapter
+func TestDAPLaunchError(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "broken.8o")
+	if err := os.WriteFile(source, []byte(": main\n  jump missing\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, program := range []string{source, filepath.Join(t.TempDir(), "missing.ch8")} {
+		var out bytes.Buffer
+		s := NewDAPServer(strings.NewReader(""), &out)
+		arguments, _ := json.Marshal(map[string]string{"program": program})
+		s.handle(&dapRequest{Seq: 1, Command: "launch", Arguments: arguments})
+		if s.launch != nil {
+			t.Errorf("%s was launched", program)
+		}
+		_, body, _ := strings.Cut(out.String(), "\r\n\r\n")
+		var response struct {
+			Success bool
+			Message string
+		}
+		if err := json.Unmarshal([]byte(body), &response); err != nil || response.Success || response.Message == "" {
+			t.Errorf("Launch of %s answered %q", program, body)
+		}
+	}
+}
+
+// Lines of the program in the source file, main calls sub and loops
+const dapProgram = `main:
+    LD VA, 5
+    CALL sub
+    ADD VA, 1
+loop:
+    JP loop
+sub:
+    LD VB, 7
+    LD VC, 9
+    RET
+`
+
+type dapMessage struct {
+	Type    string
+	Command string
+	Event   string
+	Success bool
+	Message string
+	Body    json.RawMessage
+}
+
+// Server of the assembled program which reads no requests, they are handled by the test
+type dapClient struct {
+	t      *testing.T
+	server *DAPServer
+	out    bytes.Buffer
+	seq    int
+	source string
+}
+
+func newDAPClient(t *testing.T) *dapClient {
+	frontend = headlessFrontend{}
+	reset()
+	c := &dapClient{t: t, source: filepath.Join(t.TempDir(), "main.s")}
+	if err := os.WriteFile(c.source, []byte(dapProgram), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rom, symbols, err := asm.AssembleFile(c.source, START_ADDRESS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(chip8.Cpu.Memory[START_ADDRESS:], rom)
+	// The pipe is never written, so the server goroutine waits without closing the requests
+	in, writer := io.Pipe()
+	t.Cleanup(func() {
+		writer.Close()
+		reset()
+	})
+	c.server = NewDAPServer(in, &c.out)
+	c.server.Symbols = symbols
+	return c
+}
+
+// Messages written since the last call
+func (c *dapClient) messages() []dapMessage {
+	var messages []dapMessage
+	for c.out.Len() > 0 {
+		header, err := textproto.NewReader(bufio.NewReader(strings.NewReader(c.out.String()))).ReadMIMEHeader()
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		length, _ := strconv.Atoi(header.Get("Content-Length"))
+		_, rest, _ := strings.Cut(c.out.String(), "\r\n\r\n")
+		var message dapMessage
+		if err = json.Unmarshal([]byte(rest[:length]), &message); err != nil {
+			c.t.Fatal(err)
+		}
+		messages = append(messages, message)
+		c.out.Reset()
+		c.out.WriteString(rest[length:])
+	}
+	return messages
+}
+
+// Handle the request and decode the body of its response into body
+func (c *dapClient) request(command string, arguments interface{}, body interface{}) dapMessage {
+	c.t.Helper()
+	c.seq++
+	raw, _ := json.Marshal(arguments)
+	c.server.handle(&dapRequest{Seq: c.seq, Command: command, Arguments: raw})
+	for _, message := range c.messages() {
+		if message.Type == "response" && message.Command == command {
+			if body != nil && message.Success {
+				if err := json.Unmarshal(message.Body, body); err != nil {
+					c.t.Fatal(err)
+				}
+			}
+			return message
+		}
+	}
+	c.t.Fatalf("No response to %s", command)
+	return dapMessage{}
+}
+
+// Run the machine until the server stops it and return the reason
+func (c *dapClient) run() string {
+	c.t.Helper()
+	for i := 0; i < 1000 && c.server.beforeCycle(); i++ {
+		cycle()
+		c.server.afterCycle()
+	}
+	for _, message := range c.messages() {
+		if message.Event == "stopped" {
+			var body struct{ Reason string }
+			json.Unmarshal(message.Body, &body)
+			return body.Reason
+		}
+	}
+	c.t.Fatalf("The machine did not stop at 0x%03X", chip8.Cpu.ProgramCounter)
+	return ""
+}
+
+type dapFrame struct {
+	Name                        string
+	Line                        int
+	Source                      dapSource
+	InstructionPointerReference string
+}
+
+func TestDAPSetBreakpoints(t *testing.T) {
+	c := newDAPClient(t)
+	var body struct {
+		Breakpoints []struct {
+			Verified             bool
+			Line                 int
+			Message              string
+			InstructionReference string
+		}
+	}
+	lines := []map[string]int{{"line": 3}, {"line": 5}, {"line": 8}, {"line": 99}}
+	c.request("setBreakpoints", map[string]interface{}{"source": dapSource{Path: c.source}, "breakpoints": lines}, &body)
+	want := []struct {
+		verified  bool
+		reference string
+		message   string
+	}{
+		{true, "0x202", ""},
+		// A label emits nothing
+		{false, "", "No code at this line"},
+		{true, "0x208", ""},
+		{false, "", "No code at this line"},
+	}
+	if len(body.Breakpoints) != len(want) {
+		t.Fatalf("%d breakpoints for %d lines", len(body.Breakpoints), len(want))
+	}
+	for i, breakpoint := range body.Breakpoints {
+		if breakpoint.Verified != want[i].verified || breakpoint.InstructionReference != want[i].reference ||
+			breakpoint.Message != want[i].message || breakpoint.Line != lines[i]["line"] {
+			t.Errorf("Line %d: %+v", lines[i]["line"], breakpoint)
+		}
+	}
+	if len(c.server.Breakpoints) != 2 || !c.server.Breakpoints[0x202] || !c.server.Breakpoints[0x208] {
+		t.Errorf("Breakpoints are %v", c.server.Breakpoints)
+	}
+	// Files without symbols get no breakpoints, the ones of main.s are kept
+	other := filepath.Join(filepath.Dir(c.source), "other.s")
+	c.request("setBreakpoints", map[string]interface{}{"source": dapSource{Path: other}, "breakpoints": lines[:1]}, &body)
+	if body.Breakpoints[0].Verified || !strings.Contains(body.Breakpoints[0].Message, "No symbols") || len(c.server.Breakpoints) != 2 {
+		t.Errorf("other.s: %+v with %v", body.Breakpoints[0], c.server.Breakpoints)
+	}
+	// Clearing the breakpoints of the file removes them
+	c.request("setBreakpoints", map[string]interface{}{"source": dapSource{Path: c.source}}, &body)
+	if len(c.server.Breakpoints) != 0 {
+		t.Errorf("Breakpoints left %v", c.server.Breakpoints)
+	}
+}
+
+func TestDAPStepping(t *testing.T) {
+	c := newDAPClient(t)
+	c.request("setBreakpoints", map[string]interface{}{"source": dapSource{Path: c.source}, "breakpoints": []map[string]int{{"line": 3}}}, nil)
+	c.request("continue", nil, nil)
+	if reason := c.run(); reason != "breakpoint" || chip8.Cpu.ProgramCounter != 0x202 {
+		t.Fatalf("Stopped for %s at 0x%03X", reason, chip8.Cpu.ProgramCounter)
+	}
+	// stepIn follows the call to the next source line
+	c.request("stepIn", nil, nil)
+	if reason := c.run(); reason != "step" || chip8.Cpu.ProgramCounter != 0x208 {
+		t.Fatalf("stepIn stopped for %s at 0x%03X", reason, chip8.Cpu.ProgramCounter)
+	}
+	var trace struct {
+		StackFrames []dapFrame
+		TotalFrames int
+	}
+	c.request("stackTrace", map[string]int{"threadId": DAP_THREAD_ID}, &trace)
+	if trace.TotalFrames != 2 || len(trace.StackFrames) != 2 {
+		t.Fatalf("Stack trace %+v", trace)
+	}
+	frames := []dapFrame{
+		{"sub", 8, dapSource{Name: "main.s", Path: c.source}, "0x208"},
+		// The caller is at the call, not at the return address
+		{"main", 3, dapSource{Name: "main.s", Path: c.source}, "0x202"},
+	}
+	for i, frame := range frames {
+		if trace.StackFrames[i] != frame {
+			t.Errorf("Frame %d is %+v, want %+v", i, trace.StackFrames[i], frame)
+		}
+	}
+	// next stays in the subroutine, stepOut returns to the caller
+	c.request("next", nil, nil)
+	if reason := c.run(); reason != "step" || chip8.Cpu.ProgramCounter != 0x20A {
+		t.Errorf("next stopped for %s at 0x%03X", reason, chip8.Cpu.ProgramCounter)
+	}
+	c.request("stepOut", nil, nil)
+	if reason := c.run(); reason != "step" || chip8.Cpu.ProgramCounter != 0x204 || chip8.Cpu.Registers[0xC] != 9 {
+		t.Errorf("stepOut stopped for %s at 0x%03X with VC=%d", reason, chip8.Cpu.ProgramCounter, chip8.Cpu.Registers[0xC])
+	}
+	if response := c.request("stepOut", nil, nil); response.Success || response.Message != "Not inside a subroutine" {
+		t.Errorf("stepOut of main answered %+v", response)
+	}
+	// next over a call runs the whole subroutine
+	c.request("setVariable", map[string]string{"name": "PC", "value": "0x200"}, nil)
+	c.request("next", map[string]string{"granularity": "instruction"}, nil)
+	c.run()
+	c.request("next", map[string]string{"granularity": "instruction"}, nil)
+	if reason := c.run(); reason != "step" || chip8.Cpu.ProgramCounter != 0x204 || chip8.Cpu.StackPointer != 0 {
+		t.Errorf("next over the call stopped for %s at 0x%03X with SP=%d", reason, chip8.Cpu.ProgramCounter, chip8.Cpu.StackPointer)
+	}
+}
+
+func TestDAPVariables(t *testing.T) {
+	c := newDAPClient(t)
+	chip8.Cpu.Registers[0xA] = 5
+	chip8.Cpu.ProgramCounter = 0x20A
+	chip8.Cpu.ProgramStack[0] = 0x204
+	chip8.Cpu.StackPointer = 1
+	chip8.Display[0][0] = 1
+	var scopes struct {
+		Scopes []struct {
+			Name               string
+			VariablesReference int
+		}
+	}
+	c.request("scopes", map[string]int{"frameId": 0}, &scopes)
+	if len(scopes.Scopes) != 3 || scopes.Scopes[0].Name != "Registers" {
+		t.Fatalf("Scopes %+v", scopes)
+	}
+	type variables struct {
+		Variables []struct {
+			Name            string
+			Value           string
+			MemoryReference string
+		}
+	}
+	values := func(reference int) map[string]string {
+		var body variables
+		c.request("variables", map[string]int{"variablesReference": reference}, &body)
+		values := map[string]string{}
+		for _, variable := range body.Variables {
+			values[variable.Name] = variable.Value + variable.MemoryReference
+		}
+		return values
+	}
+	registers := values(DAP_REGISTERS)
+	for name, value := range map[string]string{"VA": "0x05 (5)", "V0": "0x00 (0)", "PC": "0x20A (522)0x20A", "SP": "0x01 (1)"} {
+		if registers[name] != value {
+			t.Errorf("%s is %q, want %q", name, registers[name], value)
+		}
+	}
+	if len(registers) != 21 {
+		t.Errorf("%d registers", len(registers))
+	}
+	if stack := values(DAP_STACK); len(stack) != 1 || stack["S0"] != "0x204 (516)" {
+		t.Errorf("Stack %v", stack)
+	}
+	if display := values(DAP_DISPLAY); len(display) != HEIGHT || !strings.HasPrefix(display["00"], "#.") {
+		t.Errorf("Display starts with %q", display["00"])
+	}
+	if response := c.request("variables", map[string]int{"variablesReference": 9}, nil); response.Success {
+		t.Error("Unknown variables reference 9 answered")
+	}
+	c.request("setVariable", map[string]string{"name": "VB", "value": "0x2A"}, nil)
+	if response := c.request("setVariable", map[string]string{"name": "SP", "value": "17"}, nil); response.Success || chip8.Cpu.Registers[0xB] != 0x2A {
+		t.Errorf("SP 17 answered %+v, VB is %X", response, chip8.Cpu.Registers[0xB])
+	}
+}
+
+func TestDAPDisassemble(t *testing.T) {
+	c := newDAPClient(t)
+	type instruction struct {
+		Address          string
+		InstructionBytes string
+		Instruction      string
+		Symbol           string
+		Line             int
+		PresentationHint string
+	}
+	var body struct {
+		Instructions []instruction
+	}
+	c.request("disassemble", map[string]interface{}{"memoryReference": "0x200", "instructionOffset": -1, "instructionCount": 3}, &body)
+	want := []instruction{
+		{Address: "0x1FE", InstructionBytes: "0000", Instruction: "SYS 0x000"},
+		{Address: "0x200", InstructionBytes: "6A05", Instruction: "LD VA, 0x05", Symbol: "main", Line: 2},
+		{Address: "0x202", InstructionBytes: "2208", Instruction: "CALL 0x208", Line: 3},
+	}
+	if len(body.Instructions) != len(want) {
+		t.Fatalf("%d instructions, want %d", len(body.Instructions), len(want))
+	}
+	for i, in := range want {
+		if body.Instructions[i] != in {
+			t.Errorf("Instruction %d is %+v, want %+v", i, body.Instructions[i], in)
+		}
+	}
+	// Addresses outside of the memory are invalid
+	c.request("disassemble", map[string]interface{}{"memoryReference": "0xFFC", "instructionCount": 3}, &body)
+	if hint := body.Instructions[2].PresentationHint; body.Instructions[1].PresentationHint != "" || hint != "invalid" {
+		t.Errorf("Instructions at the end of the memory are %+v", body.Instructions)
+	}
+	// The count of the client is capped to the memory
+	c.request("disassemble", map[string]interface{}{"memoryReference": "0x0", "instructionCount": 1 << 40}, &body)
+	if len(body.Instructions) != len(chip8.Cpu.Memory)/2 {
+		t.Errorf("%d instructions for a huge count", len(body.Instructions))
+	}
+	if response := c.request("disassemble", map[string]interface{}{"memoryReference": "main"}, nil); response.Success {
+		t.Error("A memory reference which is no address answered")
+	}
+}
@@ -0,0 +1,744 @@
+package chip8
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/mehmetumit/CHIP-8/chip8/asm"
+)
+
+const DEBUG_PROMPT = "(chip8) "
+
+const DEBUG_HELP = `Commands:
+  break [addr] [after n] [if expr]
+                        Set a breakpoint on PC, list breakpoints without address
+                        Skip the first n hits, stop only when expr holds
+                        e.g. break 0x2A0 if V3 == 0x10 && I > 0x300
+  delete <addr>         Remove the breakpoint
+  watch [start[-end]] [r|w|x]
+                        Stop when an instruction reads, writes or executes
+                        the memory range (default w), list watchpoints without range
+  unwatch <n>           Remove the watchpoint with the given number
+  step [n]              Execute n instructions (default 1)
+  next                  Step over subroutine calls (2NNN)
+  out                   Run until the current subroutine returns (00EE)
+  continue              Run until a breakpoint is hit (Ctrl-C pauses)
+  until <addr>          Run until PC reaches the address
+  regs                  Print V0-VF, I, PC, SP, the stack and timers
+  print <target>        Print V0-VF, I, PC, SP, DT, ST, S0-SF or stack
+  set <target> <value>  Edit V0-VF, I, PC, SP, DT, ST or stack slot S0-SF
+  mem <addr> [length]   Hex dump memory
+  disasm [addr] [count] Disassemble around PC or from address
+  symbols <file>        Load a symbol file written by the assembler
+  screenshot [raw] [file]
+                        Write the display as png, raw for one pixel per display
+                        pixel (default timestamped file in the screenshot directory)
+  quit                  Exit the emulator
+Numbers use go syntax, e.g. 0x200, 512, 0b1010, addresses can be labels`
+
+type Breakpoint struct {
+	// Nil for unconditional breakpoints
+	Condition *Expression
+	// Hits to skip before stopping
+	IgnoreCount uint64
+	Hits        uint64
+}
+
+// Inclusive memory range watched for the given accesses
+type Watchpoint struct {
+	Start  uint16
+	End    uint16
+	Access MemoryAccess
+}
+
+type watchHit struct {
+	index   int
+	address uint16
+	access  MemoryAccess
+}
+
+// The REPL runs on its own goroutine and sends the commands to the emulation
+// loop, so the machine state is only touched between cycles
+type Debugger struct {
+	Breakpoints map[ProgramCounter]*Breakpoint
+	Watchpoints []Watchpoint
+	// Labels and source lines of the rom, nil if not loaded
+	Symbols *asm.Symbols
+	paused  bool
+	// Set by the memory hook during a cycle
+	watchHit *watchHit
+	cyclePC  ProgramCounter
+	// Checked after every cycle while running
+	stopCondition  func() bool
+	stopReason     string
+	skipBreakpoint bool
+	commands       chan string
+	done           chan bool
+	interrupt      chan os.Signal
+}
+
+var debugger *Debugger
+
+func NewDebugger() *Debugger {
+	d := &Debugger{
+		Breakpoints: map[ProgramCounter]*Breakpoint{},
+		paused:      true,
+		commands:    make(chan string),
+		done:        make(chan bool),
+		interrupt:   make(chan os.Signal, 1),
+	}
+	signal.Notify(d.interrupt, os.Interrupt)
+	memoryHook = d.memoryAccessed
+	return d
+}
+
+// Read commands until the input is closed
+func (d *Debugger) repl(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	fmt.Print(DEBUG_PROMPT)
+	for scanner.Scan() {
+		d.commands <- scanner.Text()
+		<-d.done
+		fmt.Print(DEBUG_PROMPT)
+	}
+	d.commands <- "quit"
+}
+
+// Handle pending commands, return true if the next cycle can run
+func (d *Debugger) beforeCycle() bool {
+	select {
+	case line := <-d.commands:
+		d.execute(line)
+	case <-d.interrupt:
+		if !d.paused {
+			d.stop("Interrupted")
+		}
+	default:
+	}
+	if d.paused {
+		return false
+	}
+	pc := chip8.Cpu.ProgramCounter
+	if !d.skipBreakpoint {
+		if breakpoint := d.Breakpoints[pc]; breakpoint != nil && breakpoint.hit() {
+			d.stop(fmt.Sprintf("Breakpoint at 0x%03X, hit %d times", pc, breakpoint.Hits))
+			return false
+		}
+		for i, watchpoint := range d.Watchpoints {
+			if watchpoint.Access&MEMORY_EXECUTE != 0 && (watchpoint.contains(uint16(pc)) || watchpoint.contains(uint16(pc)+1)) {
+				d.stop(fmt.Sprintf("Watchpoint %d: execute 0x%03X", i, pc))
+				return false
+			}
+		}
+	}
+	d.skipBreakpoint = false
+	d.cyclePC = pc
+	return true
+}
+
+func (d *Debugger) afterCycle() {
+	if hit := d.watchHit; hit != nil {
+		d.watchHit = nil
+		d.stop(fmt.Sprintf("Watchpoint %d: %s 0x%03X by 0x%03X: %04X  %s", hit.index, accessName(hit.access),
+			hit.address, d.cyclePC, uint16(chip8.Cpu.Opcode), Mnemonic(d.cyclePC)))
+		return
+	}
+	if d.stopCondition != nil && d.stopCondition() {
+		d.stop(d.stopReason)
+	}
+}
+
+// Count the hit if the condition holds, return true if execution should stop
+func (b *Breakpoint) hit() bool {
+	if b.Condition != nil && !b.Condition.IsTrue() {
+		return false
+	}
+	b.Hits++
+	return b.Hits > b.IgnoreCount
+}
+
+func (b *Breakpoint) String() string {
+	s := fmt.Sprintf("hits=%d", b.Hits)
+	if b.IgnoreCount > 0 {
+		s += fmt.Sprintf(" after %d", b.IgnoreCount)
+	}
+	if b.Condition != nil {
+		s += " if " + b.Condition.Source
+	}
+	return s
+}
+
+func (w Watchpoint) contains(address uint16) bool {
+	return w.Start <= address && address <= w.End
+}
+
+func (w Watchpoint) String() string {
+	access := ""
+	for _, flag := range []MemoryAccess{MEMORY_READ, MEMORY_WRITE, MEMORY_EXECUTE} {
+		if w.Access&flag != 0 {
+			access += accessName(flag)[:1]
+		}
+	}
+	return fmt.Sprintf("0x%03X-0x%03X %s", w.Start, w.End, access)
+}
+
+func accessName(access MemoryAccess) string {
+	switch access {
+	case MEMORY_READ:
+		return "read"
+	case MEMORY_WRITE:
+		return "write"
+	}
+	return "execute"
+}
+
+// Remember the first watched access of the cycle, reported after the instruction
+func (d *Debugger) memoryAccessed(address uint16, access MemoryAccess) {
+	if d.watchHit != nil {
+		return
+	}
+	for i, watchpoint := range d.Watchpoints {
+		if watchpoint.Access&access != 0 && watchpoint.contains(address) {
+			d.watchHit = &watchHit{index: i, address: address, access: access}
+			return
+		}
+	}
+}
+
+// Continue execution until the condition holds or a breakpoint is hit
+func (d *Debugger) resume(condition func() bool, reason string) {
+	d.stopCondition = condition
+	d.stopReason = reason
+	d.skipBreakpoint = true
+	d.paused = false
+}
+
+func (d *Debugger) stop(reason string) {
+	d.paused = true
+	d.stopCondition = nil
+	fmt.Println(reason)
+	d.printInstruction(chip8.Cpu.ProgramCounter)
+	d.done <- true
+}
+
+func (d *Debugger) execute(line string) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		d.done <- true
+		return
+	}
+	var err error
+	args := fields[1:]
+	switch fields[0] {
+	case "help", "h":
+		fmt.Println(DEBUG_HELP)
+	case "break", "b":
+		err = d.setBreakpoint(args)
+	case "delete", "d":
+		err = d.deleteBreakpoint(args)
+	case "watch", "w":
+		err = d.setWatchpoint(args)
+	case "unwatch":
+		err = d.deleteWatchpoint(args)
+	case "step", "s":
+		err = d.step(args)
+		if err == nil {
+			return
+		}
+	case "next", "n":
+		d.stepOver()
+		return
+	case "out", "finish":
+		err = d.stepOut()
+		if err == nil {
+			return
+		}
+	case "continue", "c":
+		d.resume(nil, "")
+		return
+	case "until", "u":
+		err = d.runUntil(args)
+		if err == nil {
+			return
+		}
+	case "regs", "r":
+		d.printRegisters()
+	case "print", "p":
+		err = d.print(args)
+	case "set":
+		err = d.set(args)
+	case "mem", "x":
+		err = d.dumpMemory(args)
+	case "disasm", "l":
+		err = d.disassemble(args)
+	case "symbols":
+		if len(args) == 0 {
+			err = errors.New("Usage: symbols <file>")
+		} else {
+			err = d.LoadSymbols(args[0])
+		}
+	case "screenshot":
+		err = d.screenshot(args)
+	case "quit", "q":
+		halt()
+	default:
+		err = fmt.Errorf("Unknown command %q, type help for the list", fields[0])
+	}
+	if err != nil {
+		fmt.Println(err)
+	}
+	d.done <- true
+}
+
+func parseNumber(s string, bitSize int) (uint64, error) {
+	num, err := strconv.ParseUint(s, 0, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid number %q", s)
+	}
+	return num, nil
+}
+
+func (d *Debugger) parseAddress(s string) (ProgramCounter, error) {
+	return parseAddress(s, d.Symbols)
+}
+
+// Addresses can also be labels of the symbols if they are loaded
+func parseAddress(s string, symbols *asm.Symbols) (ProgramCounter, error) {
+	if symbols != nil {
+		if address, isExists := symbols.Labels[s]; isExists {
+			return ProgramCounter(address), nil
+		}
+	}
+	address, err := parseNumber(s, 16)
+	if err != nil {
+		return 0, err
+	}
+	if address >= uint64(len(chip8.Cpu.Memory)) {
+		return 0, fmt.Errorf("Address 0x%X is out of memory", address)
+	}
+	return ProgramCounter(address), nil
+}
+
+func opcodeAt(address ProgramCounter) Opcode {
+	if int(address) >= len(chip8.Cpu.Memory) {
+		return 0
+	}
+	if int(address)+1 >= len(chip8.Cpu.Memory) {
+		return Opcode(chip8.Cpu.Memory[address]) << 8
+	}
+	return Opcode(uint16(chip8.Cpu.Memory[address])<<8 | uint16(chip8.Cpu.Memory[address+1]))
+}
+
+func (d *Debugger) setBreakpoint(args []string) error {
+	if len(args) == 0 {
+		for address := range chip8.Cpu.Memory {
+			if breakpoint := d.Breakpoints[ProgramCounter(address)]; breakpoint != nil {
+				fmt.Printf("0x%03X %v\n", address, breakpoint)
+			}
+		}
+		return nil
+	}
+	address, err := d.parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	breakpoint := &Breakpoint{}
+	args = args[1:]
+	if len(args) > 1 && args[0] == "after" {
+		if breakpoint.IgnoreCount, err = parseNumber(args[1], 64); err != nil {
+			return err
+		}
+		args = args[2:]
+	}
+	if len(args) > 0 {
+		if args[0] != "if" || len(args) == 1 {
+			return errors.New("Usage: break <addr> [after n] [if expr]")
+		}
+		if breakpoint.Condition, err = ParseExpression(strings.Join(args[1:], " ")); err != nil {
+			return err
+		}
+	}
+	d.Breakpoints[address] = breakpoint
+	fmt.Printf("Breakpoint set at 0x%03X %v\n", address, breakpoint)
+	return nil
+}
+
+func (d *Debugger) setWatchpoint(args []string) error {
+	if len(args) == 0 {
+		for i, watchpoint := range d.Watchpoints {
+			fmt.Printf("%d: %v\n", i, watchpoint)
+		}
+		return nil
+	}
+	bounds := strings.SplitN(args[0], "-", 2)
+	start, err := d.parseAddress(bounds[0])
+	if err != nil {
+		return err
+	}
+	end := start
+	if len(bounds) == 2 {
+		if end, err = d.parseAddress(bounds[1]); err != nil {
+			return err
+		}
+		if end < start {
+			return errors.New("Watchpoint range ends before it starts")
+		}
+	}
+	watchpoint := Watchpoint{Start: uint16(start), End: uint16(end), Access: MEMORY_WRITE}
+	if len(args) > 1 {
+		watchpoint.Access = 0
+		for _, c := range args[1] {
+			switch c {
+			case 'r':
+				watchpoint.Access |= MEMORY_READ
+			case 'w':
+				watchpoint.Access |= MEMORY_WRITE
+			case 'x':
+				watchpoint.Access |= MEMORY_EXECUTE
+			default:
+				return fmt.Errorf("Unknown access %q, use a combination of r, w and x", c)
+			}
+		}
+	}
+	d.Watchpoints = append(d.Watchpoints, watchpoint)
+	fmt.Printf("Watchpoint %d: %v\n", len(d.Watchpoints)-1, watchpoint)
+	return nil
+}
+
+func (d *Debugger) deleteWatchpoint(args []string) error {
+	if len(args) == 0 {
+		return errors.New("Usage: unwatch <n>")
+	}
+	index, err := parseNumber(args[0], 16)
+	if err != nil {
+		return err
+	}
+	if index >= uint64(len(d.Watchpoints)) {
+		return fmt.Errorf("No watchpoint %d", index)
+	}
+	d.Watchpoints = append(d.Watchpoints[:index], d.Watchpoints[index+1:]...)
+	return nil
+}
+
+func (d *Debugger) deleteBreakpoint(args []string) error {
+	if len(args) == 0 {
+		return errors.New("Usage: delete <addr>")
+	}
+	address, err := d.parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	if d.Breakpoints[address] == nil {
+		return fmt.Errorf("No breakpoint at 0x%03X", address)
+	}
+	delete(d.Breakpoints, address)
+	return nil
+}
+
+func (d *Debugger) step(args []string) error {
+	count := uint64(1)
+	if len(args) > 0 {
+		var err error
+		if count, err = parseNumber(args[0], 32); err != nil {
+			return err
+		}
+		if count == 0 {
+			return errors.New("Step count must be positive")
+		}
+	}
+	d.resume(func() bool {
+		count--
+		return count == 0
+	}, "Stepped")
+	return nil
+}
+
+// Subroutine calls (2NNN) run until they return to the next instruction
+func (d *Debugger) stepOver() {
+	pc := chip8.Cpu.ProgramCounter
+	if opcodeAt(pc)&0xF000 != 0x2000 {
+		d.step(nil)
+		return
+	}
+	returnAddress := pc + 2
+	depth := chip8.Cpu.StackPointer
+	d.resume(func() bool {
+		return chip8.Cpu.ProgramCounter == returnAddress && chip8.Cpu.StackPointer == depth
+	}, "Stepped over subroutine")
+}
+
+// Run until the current subroutine returns (00EE)
+func (d *Debugger) stepOut() error {
+	depth := chip8.Cpu.StackPointer
+	if depth == 0 {
+		return errors.New("Not inside a subroutine")
+	}
+	d.resume(func() bool {
+		return chip8.Cpu.StackPointer < depth
+	}, "Returned from subroutine")
+	return nil
+}
+
+func (d *Debugger) runUntil(args []string) error {
+	if len(args) == 0 {
+		return errors.New("Usage: until <addr>")
+	}
+	address, err := d.parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	d.resume(func() bool {
+		return chip8.Cpu.ProgramCounter == address
+	}, fmt.Sprintf("Reached 0x%03X", address))
+	return nil
+}
+
+func (d *Debugger) printRegisters() {
+	for i, register := range chip8.Cpu.Registers {
+		fmt.Printf("V%X=%02X ", i, register)
+		if i%8 == 7 {
+			fmt.Println()
+		}
+	}
+	fmt.Printf("I=%03X PC=%03X SP=%X DT=%02X ST=%02X\n", chip8.Cpu.IndexRegister,
+		chip8.Cpu.ProgramCounter, chip8.Cpu.StackPointer, chip8.DelayTimer, chip8.SoundTimer)
+	d.printStack()
+}
+
+func (d *Debugger) printStack() {
+	fmt.Print("Stack:")
+	for i := StackPointer(0); i < chip8.Cpu.StackPointer && int(i) < len(chip8.Cpu.ProgramStack); i++ {
+		fmt.Printf(" %03X", chip8.Cpu.ProgramStack[i])
+	}
+	fmt.Println()
+}
+
+func (d *Debugger) print(args []string) error {
+	if len(args) == 0 {
+		return errors.New("Usage: print <target>")
+	}
+	target := strings.ToUpper(args[0])
+	switch target {
+	case "I":
+		fmt.Printf("I=%03X\n", chip8.Cpu.IndexRegister)
+	case "PC":
+		fmt.Printf("PC=%03X\n", chip8.Cpu.ProgramCounter)
+	case "SP":
+		fmt.Printf("SP=%X\n", chip8.Cpu.StackPointer)
+	case "DT":
+		fmt.Printf("DT=%02X\n", chip8.DelayTimer)
+	case "ST":
+		fmt.Printf("ST=%02X\n", chip8.SoundTimer)
+	case "STACK":
+		d.printStack()
+	default:
+		index, isRegister, err := parseIndexedTarget(target)
+		if err != nil {
+			return err
+		}
+		if isRegister {
+			fmt.Printf("V%X=%02X\n", index, chip8.Cpu.Registers[index])
+		} else {
+			fmt.Printf("S%X=%03X\n", index, chip8.Cpu.ProgramStack[index])
+		}
+	}
+	return nil
+}
+
+// Parse V0-VF and S0-SF targets
+func parseIndexedTarget(target string) (uint8, bool, error) {
+	if len(target) == 2 && (target[0] == 'V' || target[0] == 'S') {
+		index, err := strconv.ParseUint(target[1:], 16, 4)
+		if err == nil {
+			return uint8(index), target[0] == 'V', nil
+		}
+	}
+	return 0, false, fmt.Errorf("Unknown target %q", target)
+}
+
+func (d *Debugger) set(args []string) error {
+	if len(args) < 2 {
+		return errors.New("Usage: set <target> <value>")
+	}
+	if err := setTarget(args[0], args[1], d.parseAddress); err != nil {
+		return err
+	}
+	return d.print(args[:1])
+}
+
+// Set V0-VF, I, PC, SP, DT, ST or stack slot S0-SF, PC and the stack take addresses
+func setTarget(target string, text string, parseAddress func(string) (ProgramCounter, error)) error {
+	switch strings.ToUpper(target) {
+	case "I":
+		value, err := parseNumber(text, 16)
+		if err != nil {
+			return err
+		}
+		chip8.Cpu.IndexRegister = IndexRegister(value)
+	case "PC":
+		address, err := parseAddress(text)
+		if err != nil {
+			return err
+		}
+		chip8.Cpu.ProgramCounter = address
+	case "SP":
+		value, err := parseNumber(text, 8)
+		if err != nil {
+			return err
+		}
+		if value > uint64(len(chip8.Cpu.ProgramStack)) {
+			return fmt.Errorf("Stack pointer can be at most %d", len(chip8.Cpu.ProgramStack))
+		}
+		chip8.Cpu.StackPointer = StackPointer(value)
+	case "DT":
+		value, err := parseNumber(text, 8)
+		if err != nil {
+			return err
+		}
+		chip8.DelayTimer = DelayTimer(value)
+	case "ST":
+		value, err := parseNumber(text, 8)
+		if err != nil {
+			return err
+		}
+		chip8.SoundTimer = SoundTimer(value)
+	default:
+		index, isRegister, err := parseIndexedTarget(strings.ToUpper(target))
+		if err != nil {
+			return err
+		}
+		if isRegister {
+			value, err := parseNumber(text, 8)
+			if err != nil {
+				return err
+			}
+			chip8.Cpu.Registers[index] = Register(value)
+		} else {
+			address, err := parseAddress(text)
+			if err != nil {
+				return err
+			}
+			chip8.Cpu.ProgramStack[index] = address
+		}
+	}
+	return nil
+}
+
+func (d *Debugger) dumpMemory(args []string) error {
+	if len(args) == 0 {
+		return errors.New("Usage: mem <addr> [length]")
+	}
+	start, err := d.parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	length := uint64(64)
+	if len(args) > 1 {
+		if length, err = parseNumber(args[1], 16); err != nil {
+			return err
+		}
+	}
+	end := uint64(start) + length
+	if end > uint64(len(chip8.Cpu.Memory)) {
+		end = uint64(len(chip8.Cpu.Memory))
+	}
+	for address := uint64(start); address < end; address += 16 {
+		fmt.Printf("0x%03X:", address)
+		for i := address; i < address+16 && i < end; i++ {
+			fmt.Printf(" %02X", chip8.Cpu.Memory[i])
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func (d *Debugger) disassemble(args []string) error {
+	// Show a few instructions before PC by default
+	start := chip8.Cpu.ProgramCounter
+	if start >= ProgramCounter(START_ADDRESS)+4 {
+		start -= 4
+	}
+	count := uint64(8)
+	var err error
+	if len(args) > 0 {
+		if start, err = d.parseAddress(args[0]); err != nil {
+			return err
+		}
+	}
+	if len(args) > 1 {
+		if count, err = parseNumber(args[1], 16); err != nil {
+			return err
+		}
+	}
+	for i := uint64(0); i < count; i++ {
+		address := uint64(start) + 2*i
+		if address >= uint64(len(chip8.Cpu.Memory)) {
+			break
+		}
+		d.printInstruction(ProgramCounter(address))
+	}
+	return nil
+}
+
+func (d *Debugger) LoadSymbols(path string) error {
+	symbols, err := asm.ReadSymbolsFile(path)
+	if err != nil {
+		return err
+	}
+	d.Symbols = symbols
+	fmt.Printf("%d labels loaded from %s\n", len(symbols.Labels), path)
+	return nil
+}
+
+// Print an instruction, the current one is marked with => and breakpoints with *
+// Labels and source lines are shown when symbols are loaded
+func (d *Debugger) printInstruction(address ProgramCounter) {
+	source := ""
+	if d.Symbols != nil {
+		if label := d.Symbols.Label(uint16(address)); label != "" {
+			fmt.Printf("%s:\n", label)
+		}
+		if line, isExists := d.Symbols.Lines[uint16(address)]; isExists {
+			source = "  ; " + line.String()
+		}
+	}
+	marker := "  "
+	if address == chip8.Cpu.ProgramCounter {
+		marker = "=>"
+	}
+	breakpoint := " "
+	if d.Breakpoints[address] != nil {
+		breakpoint = "*"
+	}
+	fmt.Printf("%s%s0x%03X: %04X  %-20s%s\n", marker, breakpoint, address, uint16(opcodeAt(address)), Mnemonic(address), source)
+}
+
+func (d *Debugger) screenshot(args []string) error {
+	isScaled := true
+	if len(args) > 0 && args[0] == "raw" {
+		isScaled = false
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		path, err := Screenshot(isScaled)
+		if err == nil {
+			fmt.Println(path)
+		}
+		return err
+	}
+	file, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	if err := WriteScreenshot(file, isScaled); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,91 @@
+package chip8
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// Main calls a subroutine which calls another one, then loops
+var debugProgram = []byte{
+	0x6A, 0x05, // 0x200 LD VA, 0x05
+	0x22, 0x08, // 0x202 CALL 0x208
+	0x7A, 0x01, // 0x204 ADD VA, 0x01
+	0x12, 0x06, // 0x206 JP 0x206
+	0x6B, 0x07, // 0x208 LD VB, 0x07
+	0x22, 0x10, // 0x20A CALL 0x210
+	0xA3, 0x00, // 0x20C LD I, 0x300
+	0x00, 0xEE, // 0x20E RET
+	0x6C, 0x09, // 0x210 LD VC, 0x09
+	0x00, 0xEE, // 0x212 RET
+}
+
+// Commands of the REPL and the output they give, in order
+var debugSession = []struct {
+	command string
+	output  []string
+}{
+	{"step", []string{"Stepped", "=> 0x202: 2208  CALL 0x208"}},
+	{"print VA", []string{"VA=05"}},
+	{"step", []string{"=> 0x208: 6B07"}},
+	{"next", []string{"Stepped", "=> 0x20A: 2210"}},
+	{"next", []string{"Stepped over subroutine", "=> 0x20C: A300"}},
+	{"print VC", []string{"VC=09"}},
+	{"out", []string{"Returned from subroutine", "=> 0x204: 7A01"}},
+	{"print SP", []string{"SP=0"}},
+	{"until 0x206", []string{"Reached 0x206", "=> 0x206: 1206"}},
+	{"print VA", []string{"VA=06"}},
+	{"print I", []string{"I=300"}},
+	{"set VA 0x42", []string{"VA=42"}},
+	{"set S3 0x20C", []string{"S3=20C"}},
+	{"set SP 17", []string{"Stack pointer can be at most 16"}},
+	{"mem 0x200 4", []string{"0x200: 6A 05 22 08"}},
+	{"regs", []string{"V8=00 V9=00 VA=42 VB=07 VC=09", "I=300 PC=206 SP=0 DT=00 ST=00"}},
+	{"frobnicate", []string{`Unknown command "frobnicate"`}},
+}
+
+// The REPL quits the emulator at the end of its input, so the session runs in a child
+// of the test binary and its output is checked
+func TestDebuggerREPL(t *testing.T) {
+	if os.Getenv("CHIP8_DEBUG_SESSION") != "" {
+		log.SetOutput(io.Discard)
+		frontend = headlessFrontend{}
+		reset()
+		chip8.Speed = 0
+		copy(chip8.Cpu.Memory[START_ADDRESS:], debugProgram)
+		var commands []string
+		for _, entry := range debugSession {
+			commands = append(commands, entry.command)
+		}
+		debugger = NewDebugger()
+		go debugger.repl(strings.NewReader(strings.Join(commands, "\n")))
+		control = debugger
+		loop()
+	}
+	child := exec.Command(os.Args[0], "-test.run=^TestDebuggerREPL$")
+	child.Env = append(os.Environ(), "CHIP8_DEBUG_SESSION=1")
+	output, err := child.Output()
+	// quit halts the machine
+	if exitErr, isExit := err.(*exec.ExitError); !isExit || exitErr.ExitCode() != 1 {
+		t.Errorf("The session ended with %v, want exit status 1", err)
+	}
+	// The output of every command follows its prompt, the last prompt gets the end of the input
+	sessions := strings.Split(string(output), DEBUG_PROMPT)
+	if len(sessions) != len(debugSession)+2 {
+		t.Fatalf("%d prompts for %d commands in\n%s", len(sessions)-1, len(debugSession), output)
+	}
+	for i, entry := range debugSession {
+		rest := sessions[i+1]
+		for _, line := range entry.output {
+			index := strings.Index(rest, line)
+			if index < 0 {
+				t.Errorf("%s: no %q in\n%s", entry.command, line, sessions[i+1])
+				break
+			}
+			rest = rest[index+len(line):]
+		}
+	}
+}
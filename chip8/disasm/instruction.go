@@ -0,0 +1,231 @@
+// Package disasm decodes CHIP-8, SCHIP and XO-CHIP instructions and turns
+// roms into annotated assembly in plain or Octo syntax
+package disasm
+
+import "fmt"
+
+type Syntax uint8
+
+const (
+	PLAIN Syntax = iota
+	OCTO
+)
+
+// Decoded instruction, Long is the second word of XO-CHIP F000 NNNN
+type Instruction struct {
+	Address uint16
+	Opcode  uint16
+	Long    uint16
+	Size    uint16
+	Valid   bool
+}
+
+func (in Instruction) X() uint8 {
+	return uint8((in.Opcode & 0x0F00) >> 8)
+}
+func (in Instruction) Y() uint8 {
+	return uint8((in.Opcode & 0x00F0) >> 4)
+}
+func (in Instruction) N() uint8 {
+	return uint8(in.Opcode & 0x000F)
+}
+func (in Instruction) NN() uint8 {
+	return uint8(in.Opcode & 0x00FF)
+}
+func (in Instruction) NNN() uint16 {
+	return in.Opcode & 0x0FFF
+}
+
+// Decode the instruction at address, next is the following word for 4 byte instructions
+func Decode(address uint16, opcode uint16, next uint16) Instruction {
+	in := Instruction{Address: address, Opcode: opcode, Size: 2, Valid: true}
+	if opcode == 0xF000 {
+		in.Long = next
+		in.Size = 4
+	}
+	in.Valid = in.format(PLAIN, nil) != ""
+	return in
+}
+
+// Return true if the instruction may skip the next one (3XNN 4XNN 5XY0 9XY0 EX9E EXA1)
+func (in Instruction) IsSkip() bool {
+	switch in.Opcode >> 12 {
+	case 0x3, 0x4:
+		return true
+	case 0x5, 0x9:
+		return in.N() == 0x0
+	case 0xE:
+		return in.NN() == 0x9E || in.NN() == 0xA1
+	}
+	return false
+}
+
+// Return true if execution does not continue with the next instruction
+func (in Instruction) IsTerminal() bool {
+	switch in.Opcode >> 12 {
+	case 0x0:
+		return in.Opcode == 0x00EE || in.Opcode == 0x00FD
+	case 0x1, 0xB:
+		return true
+	}
+	return false
+}
+
+// Format the instruction, label returns the label of an address or empty string
+// Invalid instructions return the raw opcode as data
+func (in Instruction) Format(syntax Syntax, label func(address uint16) string) string {
+	if text := in.format(syntax, label); text != "" {
+		return text
+	}
+	if syntax == OCTO {
+		return fmt.Sprintf("0x%02X 0x%02X", in.Opcode>>8, in.Opcode&0xFF)
+	}
+	return fmt.Sprintf("DW 0x%04X", in.Opcode)
+}
+
+func (in Instruction) format(syntax Syntax, label func(address uint16) string) string {
+	x, y, n, nn, nnn := in.X(), in.Y(), in.N(), in.NN(), in.NNN()
+	// Operands
+	target := func(address uint16) string {
+		if label != nil {
+			if name := label(address); name != "" {
+				return name
+			}
+		}
+		return fmt.Sprintf("0x%03X", address)
+	}
+	plain := func(format string, args ...interface{}) string {
+		if syntax == PLAIN {
+			return fmt.Sprintf(format, args...)
+		}
+		return ""
+	}
+	either := func(plainText, octoText string) string {
+		if syntax == OCTO {
+			return octoText
+		}
+		return plainText
+	}
+	switch in.Opcode >> 12 {
+	case 0x0:
+		switch {
+		case in.Opcode == 0x00E0:
+			return either("CLS", "clear")
+		case in.Opcode == 0x00EE:
+			return either("RET", "return")
+		case in.Opcode&0xFFF0 == 0x00C0: // SCHIP
+			return either(fmt.Sprintf("SCD %d", n), fmt.Sprintf("scroll-down %d", n))
+		case in.Opcode&0xFFF0 == 0x00D0: // XO-CHIP
+			return either(fmt.Sprintf("SCU %d", n), fmt.Sprintf("scroll-up %d", n))
+		case in.Opcode == 0x00FB:
+			return either("SCR", "scroll-right")
+		case in.Opcode == 0x00FC:
+			return either("SCL", "scroll-left")
+		case in.Opcode == 0x00FD:
+			return either("EXIT", "exit")
+		case in.Opcode == 0x00FE:
+			return either("LOW", "lores")
+		case in.Opcode == 0x00FF:
+			return either("HIGH", "hires")
+		}
+		// Machine code routines have no Octo form
+		return plain("SYS 0x%03X", nnn)
+	case 0x1:
+		return either("JP "+target(nnn), "jump "+target(nnn))
+	case 0x2:
+		if label != nil && label(nnn) != "" {
+			return either("CALL "+target(nnn), target(nnn))
+		}
+		return either("CALL "+target(nnn), ":call "+target(nnn))
+	case 0x3:
+		return either(fmt.Sprintf("SE V%X, 0x%02X", x, nn), fmt.Sprintf("if v%x != 0x%02X then", x, nn))
+	case 0x4:
+		return either(fmt.Sprintf("SNE V%X, 0x%02X", x, nn), fmt.Sprintf("if v%x == 0x%02X then", x, nn))
+	case 0x5:
+		switch n {
+		case 0x0:
+			return either(fmt.Sprintf("SE V%X, V%X", x, y), fmt.Sprintf("if v%x != v%x then", x, y))
+		case 0x2: // XO-CHIP
+			return either(fmt.Sprintf("SAVE V%X - V%X", x, y), fmt.Sprintf("save v%x - v%x", x, y))
+		case 0x3:
+			return either(fmt.Sprintf("LOAD V%X - V%X", x, y), fmt.Sprintf("load v%x - v%x", x, y))
+		}
+	case 0x6:
+		return either(fmt.Sprintf("LD V%X, 0x%02X", x, nn), fmt.Sprintf("v%x := 0x%02X", x, nn))
+	case 0x7:
+		return either(fmt.Sprintf("ADD V%X, 0x%02X", x, nn), fmt.Sprintf("v%x += 0x%02X", x, nn))
+	case 0x8:
+		operators := map[uint8][2]string{
+			0x0: {"LD", ":="},
+			0x1: {"OR", "|="},
+			0x2: {"AND", "&="},
+			0x3: {"XOR", "^="},
+			0x4: {"ADD", "+="},
+			0x5: {"SUB", "-="},
+			0x6: {"SHR", ">>="},
+			0x7: {"SUBN", "=-"},
+			0xE: {"SHL", "<<="},
+		}
+		if operator, isExists := operators[n]; isExists {
+			return either(fmt.Sprintf("%s V%X, V%X", operator[0], x, y), fmt.Sprintf("v%x %s v%x", x, operator[1], y))
+		}
+	case 0x9:
+		if n == 0x0 {
+			return either(fmt.Sprintf("SNE V%X, V%X", x, y), fmt.Sprintf("if v%x == v%x then", x, y))
+		}
+	case 0xA:
+		return either("LD I, "+target(nnn), "i := "+target(nnn))
+	case 0xB:
+		return either("JP V0, "+target(nnn), "jump0 "+target(nnn))
+	case 0xC:
+		return either(fmt.Sprintf("RND V%X, 0x%02X", x, nn), fmt.Sprintf("v%x := random 0x%02X", x, nn))
+	case 0xD:
+		return either(fmt.Sprintf("DRW V%X, V%X, %d", x, y, n), fmt.Sprintf("sprite v%x v%x %d", x, y, n))
+	case 0xE:
+		switch nn {
+		case 0x9E:
+			return either(fmt.Sprintf("SKP V%X", x), fmt.Sprintf("if v%x -key then", x))
+		case 0xA1:
+			return either(fmt.Sprintf("SKNP V%X", x), fmt.Sprintf("if v%x key then", x))
+		}
+	case 0xF:
+		if in.Opcode == 0xF000 { // XO-CHIP
+			return either(fmt.Sprintf("LD I, LONG %s", target(in.Long)), fmt.Sprintf("i := long %s", target(in.Long)))
+		}
+		switch nn {
+		case 0x01:
+			return either(fmt.Sprintf("PLANE %d", x), fmt.Sprintf("plane %d", x))
+		case 0x02:
+			if x == 0 {
+				return either("AUDIO", "audio")
+			}
+		case 0x07:
+			return either(fmt.Sprintf("LD V%X, DT", x), fmt.Sprintf("v%x := delay", x))
+		case 0x0A:
+			return either(fmt.Sprintf("LD V%X, K", x), fmt.Sprintf("v%x := key", x))
+		case 0x15:
+			return either(fmt.Sprintf("LD DT, V%X", x), fmt.Sprintf("delay := v%x", x))
+		case 0x18:
+			return either(fmt.Sprintf("LD ST, V%X", x), fmt.Sprintf("buzzer := v%x", x))
+		case 0x1E:
+			return either(fmt.Sprintf("ADD I, V%X", x), fmt.Sprintf("i += v%x", x))
+		case 0x29:
+			return either(fmt.Sprintf("LD F, V%X", x), fmt.Sprintf("i := hex v%x", x))
+		case 0x30: // SCHIP
+			return either(fmt.Sprintf("LD HF, V%X", x), fmt.Sprintf("i := bighex v%x", x))
+		case 0x33:
+			return either(fmt.Sprintf("LD B, V%X", x), fmt.Sprintf("bcd v%x", x))
+		case 0x3A: // XO-CHIP
+			return either(fmt.Sprintf("PITCH V%X", x), fmt.Sprintf("pitch := v%x", x))
+		case 0x55:
+			return either(fmt.Sprintf("LD [I], V%X", x), fmt.Sprintf("save v%x", x))
+		case 0x65:
+			return either(fmt.Sprintf("LD V%X, [I]", x), fmt.Sprintf("load v%x", x))
+		case 0x75: // SCHIP
+			return either(fmt.Sprintf("LD R, V%X", x), fmt.Sprintf("saveflags v%x", x))
+		case 0x85:
+			return either(fmt.Sprintf("LD V%X, R", x), fmt.Sprintf("loadflags v%x", x))
+		}
+	}
+	return ""
+}
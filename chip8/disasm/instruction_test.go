@@ -0,0 +1,99 @@
+package disasm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	labels := map[uint16]string{0x300: "loop", 0x400: "draw"}
+	label := func(address uint16) string {
+		return labels[address]
+	}
+	tests := []struct {
+		opcode uint16
+		plain  string
+		octo   string
+	}{
+		{0x00E0, "CLS", "clear"},
+		{0x00EE, "RET", "return"},
+		{0x00C4, "SCD 4", "scroll-down 4"},
+		{0x00D2, "SCU 2", "scroll-up 2"},
+		{0x00FB, "SCR", "scroll-right"},
+		{0x00FC, "SCL", "scroll-left"},
+		{0x00FD, "EXIT", "exit"},
+		{0x00FE, "LOW", "lores"},
+		{0x00FF, "HIGH", "hires"},
+		{0x0123, "SYS 0x123", "0x01 0x23"},
+		{0x1300, "JP loop", "jump loop"},
+		{0x1234, "JP 0x234", "jump 0x234"},
+		{0x2400, "CALL draw", "draw"},
+		{0x2456, "CALL 0x456", ":call 0x456"},
+		{0x3A12, "SE VA, 0x12", "if va != 0x12 then"},
+		{0x4B34, "SNE VB, 0x34", "if vb == 0x34 then"},
+		{0x5120, "SE V1, V2", "if v1 != v2 then"},
+		{0x5122, "SAVE V1 - V2", "save v1 - v2"},
+		{0x5123, "LOAD V1 - V2", "load v1 - v2"},
+		{0x5121, "DW 0x5121", "0x51 0x21"},
+		{0x63FF, "LD V3, 0xFF", "v3 := 0xFF"},
+		{0x7401, "ADD V4, 0x01", "v4 += 0x01"},
+		{0x8560, "LD V5, V6", "v5 := v6"},
+		{0x8561, "OR V5, V6", "v5 |= v6"},
+		{0x8562, "AND V5, V6", "v5 &= v6"},
+		{0x8563, "XOR V5, V6", "v5 ^= v6"},
+		{0x8564, "ADD V5, V6", "v5 += v6"},
+		{0x8565, "SUB V5, V6", "v5 -= v6"},
+		{0x8566, "SHR V5, V6", "v5 >>= v6"},
+		{0x8567, "SUBN V5, V6", "v5 =- v6"},
+		{0x856E, "SHL V5, V6", "v5 <<= v6"},
+		{0x8568, "DW 0x8568", "0x85 0x68"},
+		{0x9780, "SNE V7, V8", "if v7 == v8 then"},
+		{0x9781, "DW 0x9781", "0x97 0x81"},
+		{0xA300, "LD I, loop", "i := loop"},
+		{0xB300, "JP V0, loop", "jump0 loop"},
+		{0xC90F, "RND V9, 0x0F", "v9 := random 0x0F"},
+		{0xD125, "DRW V1, V2, 5", "sprite v1 v2 5"},
+		{0xEB9E, "SKP VB", "if vb -key then"},
+		{0xECA1, "SKNP VC", "if vc key then"},
+		{0xEC00, "DW 0xEC00", "0xEC 0x00"},
+		{0xF201, "PLANE 2", "plane 2"},
+		{0xF002, "AUDIO", "audio"},
+		{0xF102, "DW 0xF102", "0xF1 0x02"},
+		{0xFD07, "LD VD, DT", "vd := delay"},
+		{0xFE0A, "LD VE, K", "ve := key"},
+		{0xF115, "LD DT, V1", "delay := v1"},
+		{0xF218, "LD ST, V2", "buzzer := v2"},
+		{0xF31E, "ADD I, V3", "i += v3"},
+		{0xF429, "LD F, V4", "i := hex v4"},
+		{0xF430, "LD HF, V4", "i := bighex v4"},
+		{0xF533, "LD B, V5", "bcd v5"},
+		{0xF63A, "PITCH V6", "pitch := v6"},
+		{0xF655, "LD [I], V6", "save v6"},
+		{0xF765, "LD V7, [I]", "load v7"},
+		{0xF875, "LD R, V8", "saveflags v8"},
+		{0xF885, "LD V8, R", "loadflags v8"},
+		{0xF0FF, "DW 0xF0FF", "0xF0 0xFF"},
+	}
+	for _, test := range tests {
+		in := Decode(0x200, test.opcode, 0)
+		if plain := in.Format(PLAIN, label); plain != test.plain {
+			t.Errorf("%04X is %q in plain syntax, want %q", test.opcode, plain, test.plain)
+		}
+		if octo := in.Format(OCTO, label); octo != test.octo {
+			t.Errorf("%04X is %q in Octo syntax, want %q", test.opcode, octo, test.octo)
+		}
+		if isValid := !strings.HasPrefix(test.plain, "DW"); in.Valid != isValid {
+			t.Errorf("%04X is valid: %v", test.opcode, in.Valid)
+		}
+	}
+}
+
+func TestDecodeLong(t *testing.T) {
+	in := Decode(0x200, 0xF000, 0x1234)
+	if in.Size != 4 || in.Long != 0x1234 {
+		t.Fatalf("F000 1234 is %d bytes long with 0x%04X", in.Size, in.Long)
+	}
+	if plain, octo := in.Format(PLAIN, nil), in.Format(OCTO, nil); plain != "LD I, LONG 0x1234" || octo != "i := long 0x1234" {
+		t.Errorf("F000 1234 is %q and %q", plain, octo)
+	}
+}
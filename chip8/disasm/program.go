@@ -0,0 +1,291 @@
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Address classification of rom bytes
+const (
+	BYTE_DATA uint8 = iota
+	BYTE_CODE
+	BYTE_SPRITE
+)
+
+// Sprite drawn by DXYN from the address loaded with ANNN
+type Sprite struct {
+	Rows uint8
+	// 1 byte for 8 pixel wide sprites, 2 for SCHIP 16x16 sprites
+	Width uint8
+}
+
+type Program struct {
+	Origin       uint16
+	Rom          []byte
+	Instructions map[uint16]Instruction
+	Labels       map[uint16]string
+	Sprites      map[uint16]Sprite
+	// Classification of every rom byte
+	Kinds []uint8
+	// Addresses which start a printed line, labels outside them are written as numbers
+	printed map[uint16]bool
+}
+
+// Path of the recursive descent, I is -1 while unknown
+type tracePath struct {
+	address uint16
+	i       int
+}
+
+// Trace the rom from the origin following jumps, calls and skips to separate code from data
+func Trace(rom []byte, origin uint16) *Program {
+	p := &Program{
+		Origin:       origin,
+		Rom:          rom,
+		Instructions: map[uint16]Instruction{},
+		Labels:       map[uint16]string{},
+		Sprites:      map[uint16]Sprite{},
+		Kinds:        make([]uint8, len(rom)),
+	}
+	paths := []tracePath{{address: origin, i: -1}}
+	for len(paths) > 0 {
+		path := paths[len(paths)-1]
+		paths = paths[:len(paths)-1]
+		p.tracePath(path, &paths)
+	}
+	// Sprites are data even if a jump table runs into them, code wins
+	for address, sprite := range p.Sprites {
+		for i := uint16(0); i < uint16(sprite.Rows)*uint16(sprite.Width); i++ {
+			if p.contains(address+i) && p.kind(address+i) == BYTE_DATA {
+				p.Kinds[address+i-origin] = BYTE_SPRITE
+			}
+		}
+	}
+	p.layout()
+	return p
+}
+
+func (p *Program) contains(address uint16) bool {
+	return address >= p.Origin && int(address-p.Origin) < len(p.Rom)
+}
+
+func (p *Program) kind(address uint16) uint8 {
+	return p.Kinds[address-p.Origin]
+}
+
+func (p *Program) word(address uint16) uint16 {
+	if !p.contains(address) {
+		return 0
+	}
+	high := uint16(p.Rom[address-p.Origin]) << 8
+	if !p.contains(address + 1) {
+		return high
+	}
+	return high | uint16(p.Rom[address+1-p.Origin])
+}
+
+func (p *Program) addLabel(address uint16, prefix string) {
+	if _, isExists := p.Labels[address]; !isExists || prefix == "sub" {
+		p.Labels[address] = fmt.Sprintf("%s_%03X", prefix, address)
+	}
+}
+
+func (p *Program) tracePath(path tracePath, paths *[]tracePath) {
+	address, i := path.address, path.i
+	for p.contains(address) && p.contains(address+1) {
+		if _, isVisited := p.Instructions[address]; isVisited {
+			return
+		}
+		in := Decode(address, p.word(address), p.word(address+2))
+		if !in.Valid || !p.contains(address+in.Size-1) {
+			return
+		}
+		p.Instructions[address] = in
+		for b := address; b < address+in.Size; b++ {
+			p.Kinds[b-p.Origin] = BYTE_CODE
+		}
+		next := address + in.Size
+		switch in.Opcode >> 12 {
+		case 0x1:
+			p.addLabel(in.NNN(), "loc")
+			*paths = append(*paths, tracePath{address: in.NNN(), i: i})
+		case 0x2:
+			p.addLabel(in.NNN(), "sub")
+			*paths = append(*paths, tracePath{address: in.NNN(), i: -1})
+			// I is unknown after the subroutine returns
+			i = -1
+		case 0xA:
+			i = int(in.NNN())
+			p.addLabel(in.NNN(), "data")
+		case 0xB:
+			// Jump table, only the base is known
+			p.addLabel(in.NNN(), "loc")
+			*paths = append(*paths, tracePath{address: in.NNN(), i: i})
+		case 0xD:
+			if i >= 0 {
+				sprite := Sprite{Rows: in.N(), Width: 1}
+				if in.N() == 0 {
+					sprite = Sprite{Rows: 16, Width: 2}
+				}
+				if sprite.Rows > p.Sprites[uint16(i)].Rows {
+					p.Sprites[uint16(i)] = sprite
+				}
+				p.Labels[uint16(i)] = fmt.Sprintf("sprite_%03X", i)
+			}
+		case 0xF:
+			if in.Opcode == 0xF000 {
+				i = int(in.Long)
+				p.addLabel(in.Long, "data")
+			} else if in.NN() == 0x1E || in.NN() == 0x29 || in.NN() == 0x30 {
+				i = -1
+			}
+		}
+		if in.IsSkip() {
+			skipped := Decode(next, p.word(next), p.word(next+2))
+			*paths = append(*paths, tracePath{address: next + skipped.Size, i: i})
+		}
+		if in.IsTerminal() {
+			return
+		}
+		address = next
+	}
+}
+
+// Decide which addresses start a line
+func (p *Program) layout() {
+	p.printed = map[uint16]bool{}
+	for address := p.Origin; p.contains(address); {
+		p.printed[address] = true
+		if p.isInstructionStart(address) {
+			address += p.Instructions[address].Size
+			continue
+		}
+		address++
+	}
+}
+
+// Overlapping instructions are only printed once, the rest of their bytes become data
+func (p *Program) isInstructionStart(address uint16) bool {
+	_, isExists := p.Instructions[address]
+	return isExists && p.kind(address) == BYTE_CODE
+}
+
+// Return the label of a printed address
+func (p *Program) Label(address uint16) string {
+	if p.printed[address] {
+		return p.Labels[address]
+	}
+	return ""
+}
+
+// Write the annotated assembly of the rom
+func (p *Program) Write(w io.Writer, syntax Syntax) error {
+	out := bufio.NewWriter(w)
+	comment := ";"
+	if syntax == OCTO {
+		comment = "#"
+	}
+	code := 0
+	for _, kind := range p.Kinds {
+		if kind == BYTE_CODE {
+			code++
+		}
+	}
+	fmt.Fprintf(out, "%s %d bytes, %d code, %d data, %d labels\n", comment, len(p.Rom), code, len(p.Rom)-code, len(p.Labels))
+	p.writeUnreachableLabels(out, comment)
+	if syntax == OCTO {
+		fmt.Fprintln(out, ": main")
+	}
+	for address := p.Origin; p.contains(address); {
+		if label := p.Labels[address]; label != "" {
+			if syntax == OCTO {
+				fmt.Fprintf(out, ": %s\n", label)
+			} else {
+				fmt.Fprintf(out, "%s:\n", label)
+			}
+		}
+		switch {
+		case p.isInstructionStart(address):
+			in := p.Instructions[address]
+			raw := fmt.Sprintf("%04X", in.Opcode)
+			if in.Size == 4 {
+				raw += fmt.Sprintf(" %04X", in.Long)
+			}
+			if syntax == OCTO {
+				fmt.Fprintf(out, "\t%-32s # 0x%03X: %s\n", in.Format(syntax, p.Label), address, raw)
+			} else {
+				fmt.Fprintf(out, "0x%03X: %-9s  %s\n", address, raw, in.Format(syntax, p.Label))
+			}
+			address += in.Size
+		case p.kind(address) == BYTE_SPRITE:
+			address = p.writeSprite(out, address, syntax)
+		default:
+			address = p.writeData(out, address, syntax)
+		}
+	}
+	return out.Flush()
+}
+
+// Labels which point outside of the rom or into the middle of an instruction
+func (p *Program) writeUnreachableLabels(out io.Writer, comment string) {
+	var addresses []int
+	for address := range p.Labels {
+		if !p.printed[address] {
+			addresses = append(addresses, int(address))
+		}
+	}
+	sort.Ints(addresses)
+	for _, address := range addresses {
+		fmt.Fprintf(out, "%s %s at 0x%03X is not a line of the rom\n", comment, p.Labels[uint16(address)], address)
+	}
+}
+
+func (p *Program) writeSprite(out io.Writer, address uint16, syntax Syntax) uint16 {
+	width := uint16(1)
+	if sprite, isExists := p.Sprites[address]; isExists {
+		width = uint16(sprite.Width)
+	}
+	for p.contains(address) && p.kind(address) == BYTE_SPRITE {
+		var bytes, art []string
+		for i := uint16(0); i < width && p.contains(address+i) && p.kind(address+i) == BYTE_SPRITE; i++ {
+			if i > 0 && p.Labels[address+i] != "" {
+				break
+			}
+			b := p.Rom[address+i-p.Origin]
+			bytes = append(bytes, fmt.Sprintf("0x%02X", b))
+			art = append(art, strings.NewReplacer("0", ".", "1", "#").Replace(fmt.Sprintf("%08b", b)))
+		}
+		if syntax == OCTO {
+			fmt.Fprintf(out, "\t%-32s # %s\n", strings.Join(bytes, " "), strings.Join(art, ""))
+		} else {
+			fmt.Fprintf(out, "0x%03X: DB %-12s ; %s\n", address, strings.Join(bytes, ", "), strings.Join(art, ""))
+		}
+		address += uint16(len(bytes))
+		// Next sprite starts a new line with its own width
+		if p.Labels[address] != "" {
+			break
+		}
+	}
+	return address
+}
+
+func (p *Program) writeData(out io.Writer, address uint16, syntax Syntax) uint16 {
+	start := address
+	var bytes []string
+	for p.contains(address) && !p.isInstructionStart(address) && p.kind(address) != BYTE_SPRITE && len(bytes) < 8 {
+		if address != start && p.Labels[address] != "" {
+			break
+		}
+		bytes = append(bytes, fmt.Sprintf("0x%02X", p.Rom[address-p.Origin]))
+		address++
+	}
+	if syntax == OCTO {
+		fmt.Fprintf(out, "\t%s\n", strings.Join(bytes, " "))
+	} else {
+		fmt.Fprintf(out, "0x%03X: DB %s\n", start, strings.Join(bytes, ", "))
+	}
+	return address
+}
@@ -0,0 +1,62 @@
+package disasm
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// The IBM logo is drawn by straight code ending in a jump to itself, then 6 sprites of 15 rows
+func TestTrace(t *testing.T) {
+	rom, err := os.ReadFile("../../roms/IBM-Logo.ch8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Trace(rom, 0x200)
+	for address := uint16(0x200); address < 0x22A; address++ {
+		if p.kind(address) != BYTE_CODE {
+			t.Fatalf("0x%03X is not code", address)
+		}
+	}
+	for address := uint16(0x22A); address < 0x200+uint16(len(rom)); address++ {
+		if p.kind(address) != BYTE_SPRITE {
+			t.Fatalf("0x%03X is not a sprite", address)
+		}
+	}
+	if len(p.Instructions) != 21 {
+		t.Errorf("Traced %d instructions, want 21", len(p.Instructions))
+	}
+	labels := map[uint16]string{0x228: "loc_228"}
+	for _, address := range []uint16{0x22A, 0x239, 0x248, 0x257, 0x266, 0x275} {
+		labels[address] = fmt.Sprintf("sprite_%03X", address)
+		if sprite := p.Sprites[address]; sprite.Rows != 15 || sprite.Width != 1 {
+			t.Errorf("Sprite at 0x%03X is %+v", address, sprite)
+		}
+	}
+	if len(p.Labels) != len(labels) {
+		t.Errorf("Labels are %v", p.Labels)
+	}
+	for address, name := range labels {
+		if p.Labels[address] != name {
+			t.Errorf("Label of 0x%03X is %q, want %q", address, p.Labels[address], name)
+		}
+	}
+	var out strings.Builder
+	if err = p.Write(&out, PLAIN); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"; 132 bytes, 42 code, 90 data, 7 labels",
+		"0x200: 00E0       CLS",
+		"0x202: A22A       LD I, sprite_22A",
+		"loc_228:",
+		"0x228: 1228       JP loc_228",
+		"sprite_22A:",
+		"0x22A: DB 0xFF         ; ########",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("No line %q in\n%s", line, out.String())
+		}
+	}
+}
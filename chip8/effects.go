@@ -0,0 +1,494 @@
+package chip8
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Post-processing of the display image in pure go, so the window, screenshots and
+// recordings look the same
+// At most one upscaler runs first, the overlays then run in the order of OVERLAY_ORDER
+const (
+	EFFECT_SCALE2X   = "scale2x"
+	EFFECT_SCALE3X   = "scale3x"
+	EFFECT_HQ2X      = "hq2x"
+	EFFECT_SCANLINES = "scanlines"
+	EFFECT_GRID      = "grid"
+	EFFECT_CRT       = "crt"
+)
+
+// Overlays are drawn on pixels of at least this size, enlarged with nearest neighbour after the upscaler
+const EFFECT_PIXEL_SIZE = 6
+
+type upscaler struct {
+	factor  int
+	upscale func(src *image.RGBA) *image.RGBA
+}
+
+var upscalers = map[string]upscaler{
+	EFFECT_SCALE2X: {2, scale2x},
+	EFFECT_SCALE3X: {3, scale3x},
+	EFFECT_HQ2X:    {2, hq2x},
+}
+
+// pixelSize is the size of a CHIP-8 pixel in the image
+var overlays = map[string]func(img *image.RGBA, pixelSize int){
+	EFFECT_GRID:      drawGrid,
+	EFFECT_SCANLINES: drawScanlines,
+	EFFECT_CRT:       drawCRT,
+}
+
+// The curvature moves the grid and the scanlines with the image
+var OVERLAY_ORDER = []string{EFFECT_GRID, EFFECT_SCANLINES, EFFECT_CRT}
+
+// Active effects, set by SetEffects
+var Effects []string
+
+// Effect combinations cycled by the hotkey
+var effectPresets = [][]string{
+	nil,
+	{EFFECT_SCANLINES},
+	{EFFECT_GRID},
+	{EFFECT_CRT},
+	{EFFECT_SCANLINES, EFFECT_CRT},
+	{EFFECT_SCALE2X},
+	{EFFECT_SCALE3X},
+	{EFFECT_HQ2X},
+}
+
+var (
+	// Combination from the command line which is not a preset, cycled before them
+	customEffects []string
+	effectPreset  int
+)
+
+func EffectNames() []string {
+	return []string{EFFECT_SCALE2X, EFFECT_SCALE3X, EFFECT_HQ2X, EFFECT_SCANLINES, EFFECT_GRID, EFFECT_CRT}
+}
+
+// Comma separated effect names, empty or none for the plain display
+func ParseEffects(text string) ([]string, error) {
+	if text == "" || text == "none" {
+		return nil, nil
+	}
+	var effects []string
+	isUpscaled := false
+	for _, name := range strings.Split(text, ",") {
+		name = strings.TrimSpace(name)
+		_, isUpscaler := upscalers[name]
+		_, isOverlay := overlays[name]
+		switch {
+		case isUpscaler && isUpscaled:
+			return nil, fmt.Errorf("Only one of %s, %s and %s can be used", EFFECT_SCALE2X, EFFECT_SCALE3X, EFFECT_HQ2X)
+		case isUpscaler:
+			isUpscaled = true
+		case !isOverlay:
+			return nil, fmt.Errorf("Unknown effect %q, use %s", name, strings.Join(EffectNames(), ", "))
+		}
+		effects = append(effects, name)
+	}
+	return effects, nil
+}
+
+func SetEffects(effects []string) {
+	Effects = effects
+	customEffects = effects
+	for i, preset := range effectPresets {
+		if strings.Join(preset, ",") == strings.Join(effects, ",") {
+			customEffects = nil
+			effectPreset = i
+			return
+		}
+	}
+	effectPreset = 0
+}
+
+// Switch to the next combination and return its names
+func NextEffects() string {
+	cycle := effectPresets
+	if customEffects != nil {
+		cycle = append([][]string{customEffects}, effectPresets...)
+	}
+	effectPreset = (effectPreset + 1) % len(cycle)
+	Effects = cycle[effectPreset]
+	if len(Effects) == 0 {
+		return "none"
+	}
+	return strings.Join(Effects, ",")
+}
+
+// The display in the colours of the active palette, one image pixel per display pixel
+func DisplayImage(display *Display) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, WIDTH, HEIGHT))
+	for y := 0; y < HEIGHT; y++ {
+		for x := 0; x < WIDTH; x++ {
+			img.SetRGBA(x, y, ActivePalette.Color(display[x][y]))
+		}
+	}
+	return img
+}
+
+// Image of the presented display with the pixels dimmed by the smoothing
+func presentedImage(display *Display) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, WIDTH, HEIGHT))
+	for y := 0; y < HEIGHT; y++ {
+		for x := 0; x < WIDTH; x++ {
+			img.SetRGBA(x, y, ActivePalette.Blend(display[x][y], DisplayBrightness[x][y]))
+		}
+	}
+	return img
+}
+
+// Run the active effects over the display image, the image is returned as is without them
+func PostProcess(img *image.RGBA) *image.RGBA {
+	if len(Effects) == 0 {
+		return img
+	}
+	factor := 1
+	for _, name := range Effects {
+		if scaler, isExists := upscalers[name]; isExists {
+			img = scaler.upscale(img)
+			factor = scaler.factor
+		}
+	}
+	pixelSize := factor
+	isOverlaid := false
+	for _, name := range OVERLAY_ORDER {
+		if !hasEffect(name) {
+			continue
+		}
+		if !isOverlaid {
+			enlarge := (EFFECT_PIXEL_SIZE + factor - 1) / factor
+			img = scaleNearest(img, enlarge)
+			pixelSize = factor * enlarge
+			isOverlaid = true
+		}
+		overlays[name](img, pixelSize)
+	}
+	return img
+}
+
+func hasEffect(name string) bool {
+	for _, effect := range Effects {
+		if effect == name {
+			return true
+		}
+	}
+	return false
+}
+
+func scaleNearest(src *image.RGBA, factor int) *image.RGBA {
+	if factor == 1 {
+		return src
+	}
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*factor, bounds.Dy()*factor))
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		for x := 0; x < dst.Rect.Dx(); x++ {
+			dst.SetRGBA(x, y, src.RGBAAt(x/factor, y/factor))
+		}
+	}
+	return dst
+}
+
+// Pixel of the image, coordinates outside are clamped to the edge
+func clampedAt(img *image.RGBA, x int, y int) color.RGBA {
+	bounds := img.Bounds()
+	if x < 0 {
+		x = 0
+	} else if x >= bounds.Dx() {
+		x = bounds.Dx() - 1
+	}
+	if y < 0 {
+		y = 0
+	} else if y >= bounds.Dy() {
+		y = bounds.Dy() - 1
+	}
+	return img.RGBAAt(x, y)
+}
+
+// Upscale by 2 with the EPX rules, corners are filled where the neighbours form an edge
+func scale2x(src *image.RGBA) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*2, bounds.Dy()*2))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			p := src.RGBAAt(x, y)
+			a, b := clampedAt(src, x, y-1), clampedAt(src, x+1, y)
+			c, d := clampedAt(src, x-1, y), clampedAt(src, x, y+1)
+			e0, e1, e2, e3 := p, p, p, p
+			if c == a && c != d && a != b {
+				e0 = a
+			}
+			if a == b && a != c && b != d {
+				e1 = b
+			}
+			if d == c && d != b && c != a {
+				e2 = c
+			}
+			if b == d && b != a && d != c {
+				e3 = d
+			}
+			dst.SetRGBA(2*x, 2*y, e0)
+			dst.SetRGBA(2*x+1, 2*y, e1)
+			dst.SetRGBA(2*x, 2*y+1, e2)
+			dst.SetRGBA(2*x+1, 2*y+1, e3)
+		}
+	}
+	return dst
+}
+
+// Upscale by 3 with the AdvMAME3x rules
+func scale3x(src *image.RGBA) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*3, bounds.Dy()*3))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			// A B C
+			// D E F
+			// G H I
+			a, b, c := clampedAt(src, x-1, y-1), clampedAt(src, x, y-1), clampedAt(src, x+1, y-1)
+			d, e, f := clampedAt(src, x-1, y), src.RGBAAt(x, y), clampedAt(src, x+1, y)
+			g, h, i := clampedAt(src, x-1, y+1), clampedAt(src, x, y+1), clampedAt(src, x+1, y+1)
+			out := [9]color.RGBA{e, e, e, e, e, e, e, e, e}
+			if b != h && d != f {
+				if d == b {
+					out[0] = d
+				}
+				if (d == b && e != c) || (b == f && e != a) {
+					out[1] = b
+				}
+				if b == f {
+					out[2] = f
+				}
+				if (d == b && e != g) || (d == h && e != a) {
+					out[3] = d
+				}
+				if (b == f && e != i) || (h == f && e != c) {
+					out[5] = f
+				}
+				if d == h {
+					out[6] = d
+				}
+				if (d == h && e != i) || (h == f && e != g) {
+					out[7] = h
+				}
+				if h == f {
+					out[8] = f
+				}
+			}
+			for j, pixel := range out {
+				dst.SetRGBA(3*x+j%3, 3*y+j/3, pixel)
+			}
+		}
+	}
+	return dst
+}
+
+// Blends of an hq2x output pixel, named after the interpolations of the original
+// The neighbours are those of the corner the output pixel is in
+const (
+	hqKeep               = iota // 0, the pixel
+	hqDiagonal                  // 10, 3:1 with the diagonal neighbour
+	hqHorizontal                // 11, 3:1 with the horizontal neighbour
+	hqVertical                  // 12, 3:1 with the vertical neighbour
+	hqEdge                      // 20, 2:1:1 with the horizontal and vertical neighbours
+	hqDiagonalVertical          // 21, 2:1:1 with the diagonal and vertical neighbours
+	hqDiagonalHorizontal        // 22, 2:1:1 with the diagonal and horizontal neighbours
+	hqSoftEdge                  // 70, 6:1:1 with the horizontal and vertical neighbours
+	hqCorner                    // 100, 14:1:1 with the horizontal and vertical neighbours
+)
+
+// Offsets of the 8 neighbours in the order of the pattern bits
+var hq2xNeighbours = [8][2]int{{-1, -1}, {0, -1}, {1, -1}, {-1, 0}, {1, 0}, {-1, 1}, {0, 1}, {1, 1}}
+
+// Directions of the output pixels top left, top right, bottom left and bottom right
+var hq2xCorners = [4][2]int{{-1, -1}, {1, -1}, {-1, 1}, {1, 1}}
+
+// Blend of every output pixel for every pattern of neighbours which differ from the pixel,
+// the last index is whether the horizontal and vertical neighbours of the corner are similar
+var hq2xTable = buildHQ2XTable()
+
+func hq2xBit(dx int, dy int) int {
+	for i, neighbour := range hq2xNeighbours {
+		if neighbour[0] == dx && neighbour[1] == dy {
+			return 1 << i
+		}
+	}
+	return 0
+}
+
+// The table is built from the rules of the top left output pixel, mirrored for the others
+func buildHQ2XTable() (table [256][4][2]uint8) {
+	for pattern := 0; pattern < 256; pattern++ {
+		for i, corner := range hq2xCorners {
+			sx, sy := corner[0], corner[1]
+			differs := func(dx int, dy int) bool {
+				return pattern&hq2xBit(dx, dy) != 0
+			}
+			diagonal, vertical, horizontal := differs(sx, sy), differs(0, sy), differs(sx, 0)
+			for edge := 0; edge < 2; edge++ {
+				var blend uint8
+				switch {
+				case !vertical && !horizontal:
+					blend = hqEdge
+				case vertical && !horizontal && diagonal:
+					blend = hqHorizontal
+				case vertical && !horizontal:
+					blend = hqDiagonalHorizontal
+				case !vertical && horizontal && diagonal:
+					blend = hqVertical
+				case !vertical && horizontal:
+					blend = hqDiagonalVertical
+				case edge == 0 && diagonal:
+					blend = hqKeep
+				case edge == 0:
+					blend = hqDiagonal
+				// The corner is cut by an edge, a line through the pixel is rounded less
+				case diagonal && !differs(-sx, sy) && !differs(sx, -sy):
+					blend = hqCorner
+				case !diagonal && differs(-sx, 0) && differs(0, -sy):
+					blend = hqSoftEdge
+				default:
+					blend = hqEdge
+				}
+				table[pattern][i][edge] = blend
+			}
+		}
+	}
+	return table
+}
+
+// Upscale by 2 with hq2x, the neighbours which differ from a pixel in YUV select the
+// blends of its 4 output pixels from the lookup table
+func hq2x(src *image.RGBA) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*2, bounds.Dy()*2))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			p := src.RGBAAt(x, y)
+			pattern := 0
+			for i, neighbour := range hq2xNeighbours {
+				if !isSimilar(p, clampedAt(src, x+neighbour[0], y+neighbour[1])) {
+					pattern |= 1 << i
+				}
+			}
+			for i, corner := range hq2xCorners {
+				diagonal := clampedAt(src, x+corner[0], y+corner[1])
+				vertical := clampedAt(src, x, y+corner[1])
+				horizontal := clampedAt(src, x+corner[0], y)
+				edge := 0
+				if isSimilar(horizontal, vertical) {
+					edge = 1
+				}
+				out := p
+				switch hq2xTable[pattern][i][edge] {
+				case hqDiagonal:
+					out = mixColors(p, 3, diagonal, 1, diagonal, 0)
+				case hqHorizontal:
+					out = mixColors(p, 3, horizontal, 1, horizontal, 0)
+				case hqVertical:
+					out = mixColors(p, 3, vertical, 1, vertical, 0)
+				case hqEdge:
+					out = mixColors(p, 2, horizontal, 1, vertical, 1)
+				case hqDiagonalVertical:
+					out = mixColors(p, 2, diagonal, 1, vertical, 1)
+				case hqDiagonalHorizontal:
+					out = mixColors(p, 2, diagonal, 1, horizontal, 1)
+				case hqSoftEdge:
+					out = mixColors(p, 6, horizontal, 1, vertical, 1)
+				case hqCorner:
+					out = mixColors(p, 14, horizontal, 1, vertical, 1)
+				}
+				dst.SetRGBA(2*x+i%2, 2*y+i/2, out)
+			}
+		}
+	}
+	return dst
+}
+
+// Colours are similar within the YUV thresholds of hq2x
+func isSimilar(a color.RGBA, b color.RGBA) bool {
+	y1, u1, v1 := yuv(a)
+	y2, u2, v2 := yuv(b)
+	return math.Abs(y1-y2) <= 48 && math.Abs(u1-u2) <= 7 && math.Abs(v1-v2) <= 6
+}
+
+func yuv(c color.RGBA) (float64, float64, float64) {
+	r, g, b := float64(c.R), float64(c.G), float64(c.B)
+	return (r + g + b) / 4, (r-b)/4 + 128, (2*g-r-b)/8 + 128
+}
+
+// Weighted average of the colours
+func mixColors(a color.RGBA, wa int, b color.RGBA, wb int, c color.RGBA, wc int) color.RGBA {
+	total := wa + wb + wc
+	mix := func(a uint8, b uint8, c uint8) uint8 {
+		return uint8((int(a)*wa + int(b)*wb + int(c)*wc) / total)
+	}
+	return color.RGBA{R: mix(a.R, b.R, c.R), G: mix(a.G, b.G, c.G), B: mix(a.B, b.B, c.B), A: 255}
+}
+
+func darken(img *image.RGBA, x int, y int, percent int) {
+	c := img.RGBAAt(x, y)
+	img.SetRGBA(x, y, color.RGBA{
+		R: uint8(int(c.R) * percent / 100),
+		G: uint8(int(c.G) * percent / 100),
+		B: uint8(int(c.B) * percent / 100),
+		A: c.A,
+	})
+}
+
+// Dark lines between the pixel rows
+func drawScanlines(img *image.RGBA, pixelSize int) {
+	bounds := img.Bounds()
+	for y := 0; y < bounds.Dy(); y++ {
+		if y%pixelSize < pixelSize*2/3 {
+			continue
+		}
+		for x := 0; x < bounds.Dx(); x++ {
+			darken(img, x, y, 50)
+		}
+	}
+}
+
+// Dark lines around every pixel
+func drawGrid(img *image.RGBA, pixelSize int) {
+	bounds := img.Bounds()
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			if x%pixelSize == pixelSize-1 || y%pixelSize == pixelSize-1 {
+				darken(img, x, y, 60)
+			}
+		}
+	}
+}
+
+// Bulge the image like a curved CRT and darken its corners, outside the tube is black
+func drawCRT(img *image.RGBA, pixelSize int) {
+	const CURVATURE = 0.08
+	const VIGNETTE = 0.45
+	bounds := img.Bounds()
+	src := image.NewRGBA(bounds)
+	copy(src.Pix, img.Pix)
+	width, height := float64(bounds.Dx()), float64(bounds.Dy())
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			// -1 to 1 from the centre
+			u := (float64(x)+0.5)/width*2 - 1
+			v := (float64(y)+0.5)/height*2 - 1
+			su := u * (1 + CURVATURE*v*v)
+			sv := v * (1 + CURVATURE*u*u)
+			if math.Abs(su) > 1 || math.Abs(sv) > 1 {
+				img.SetRGBA(x, y, color.RGBA{A: 255})
+				continue
+			}
+			sx := int((su + 1) / 2 * width)
+			sy := int((sv + 1) / 2 * height)
+			img.SetRGBA(x, y, clampedAt(src, sx, sy))
+			darken(img, x, y, int(100*(1-VIGNETTE*(su*su+sv*sv)/2)))
+		}
+	}
+}
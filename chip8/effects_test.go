@@ -0,0 +1,191 @@
+package chip8
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+var (
+	black = color.RGBA{A: 255}
+	white = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+)
+
+// Image from rows of # and .
+func imageOf(rows ...string) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x := range row {
+			img.SetRGBA(x, y, black)
+			if row[x] == '#' {
+				img.SetRGBA(x, y, white)
+			}
+		}
+	}
+	return img
+}
+
+func rowsOf(img *image.RGBA) string {
+	var rows []string
+	for y := 0; y < img.Rect.Dy(); y++ {
+		var row strings.Builder
+		for x := 0; x < img.Rect.Dx(); x++ {
+			if img.RGBAAt(x, y) == white {
+				row.WriteByte('#')
+			} else {
+				row.WriteByte('.')
+			}
+		}
+		rows = append(rows, row.String())
+	}
+	return strings.Join(rows, "\n")
+}
+
+func TestScaleEdges(t *testing.T) {
+	// A diagonal line away from the clamped edges is filled in instead of becoming
+	// a staircase of blocks
+	diagonal := imageOf(
+		".....",
+		".#...",
+		"..#..",
+		"...#.",
+		".....",
+	)
+	expected2x := strings.Join([]string{
+		"..........",
+		"..........",
+		"..##......",
+		"..###.....",
+		"...###....",
+		"....###...",
+		".....###..",
+		"......##..",
+		"..........",
+		"..........",
+	}, "\n")
+	if got := rowsOf(scale2x(diagonal)); got != expected2x {
+		t.Errorf("scale2x:\n%s\nexpected:\n%s", got, expected2x)
+	}
+	expected3x := strings.Join([]string{
+		"...............",
+		"...............",
+		"...............",
+		"...###.........",
+		"...###.........",
+		"...####........",
+		".....####......",
+		"......###......",
+		"......####.....",
+		"........####...",
+		".........###...",
+		".........###...",
+		"...............",
+		"...............",
+		"...............",
+	}, "\n")
+	if got := rowsOf(scale3x(diagonal)); got != expected3x {
+		t.Errorf("scale3x:\n%s\nexpected:\n%s", got, expected3x)
+	}
+	// Flat areas stay flat
+	square := imageOf("##", "##")
+	if got := rowsOf(hq2x(square)); got != "####\n####\n####\n####" {
+		t.Errorf("hq2x of a square:\n%s", got)
+	}
+}
+
+func TestHQ2X(t *testing.T) {
+	gray := func(value uint8) color.RGBA {
+		return color.RGBA{R: value, G: value, B: value, A: 255}
+	}
+	// Table entries of the original, a neighbour which differs is blended away from
+	above := hq2xTable[hq2xBit(0, -1)]
+	if above[0][0] != hqDiagonalHorizontal || above[1][0] != hqDiagonalHorizontal || above[2][0] != hqEdge || above[3][0] != hqEdge {
+		t.Errorf("Only the neighbour above differs: %v", above)
+	}
+	aboveLeft := hq2xTable[hq2xBit(0, -1)|hq2xBit(-1, 0)][0]
+	if aboveLeft[0] != hqDiagonal || aboveLeft[1] != hqEdge {
+		t.Errorf("The neighbours above and left differ: %v", aboveLeft)
+	}
+	// A lone pixel is rounded off, a diagonal line keeps more of its colour
+	lone := hq2x(imageOf("...", ".#.", "..."))
+	diagonal := hq2x(imageOf("#..", ".#.", "..#"))
+	tests := []struct {
+		name  string
+		img   *image.RGBA
+		x, y  int
+		color color.RGBA
+	}{
+		{"lone pixel", lone, 2, 2, gray(127)},
+		{"lone pixel", lone, 3, 3, gray(127)},
+		{"diagonal along the line", diagonal, 2, 2, gray(191)},
+		{"diagonal across the line", diagonal, 3, 2, gray(223)},
+		{"diagonal gap", diagonal, 1, 2, gray(127)},
+	}
+	for _, test := range tests {
+		if got := test.img.RGBAAt(test.x, test.y); got != test.color {
+			t.Errorf("%s: %d,%d is %v, want %v", test.name, test.x, test.y, got, test.color)
+		}
+	}
+}
+
+func TestParseEffects(t *testing.T) {
+	effects, err := ParseEffects("scale2x, scanlines,crt")
+	if err != nil || strings.Join(effects, ",") != "scale2x,scanlines,crt" {
+		t.Errorf("got %v %v", effects, err)
+	}
+	if effects, err := ParseEffects("none"); err != nil || effects != nil {
+		t.Errorf("none: got %v %v", effects, err)
+	}
+	for _, text := range []string{"blur", "scale2x,hq2x"} {
+		if _, err := ParseEffects(text); err == nil {
+			t.Errorf("%s: expected an error", text)
+		}
+	}
+}
+
+func TestPostProcess(t *testing.T) {
+	defer SetEffects(Effects)
+	cases := map[string]image.Point{
+		"none":              {WIDTH, HEIGHT},
+		"scale2x":           {2 * WIDTH, 2 * HEIGHT},
+		"scale3x":           {3 * WIDTH, 3 * HEIGHT},
+		"grid":              {EFFECT_PIXEL_SIZE * WIDTH, EFFECT_PIXEL_SIZE * HEIGHT},
+		"hq2x,crt":          {EFFECT_PIXEL_SIZE * WIDTH, EFFECT_PIXEL_SIZE * HEIGHT},
+		"scale3x,scanlines": {EFFECT_PIXEL_SIZE * WIDTH, EFFECT_PIXEL_SIZE * HEIGHT},
+	}
+	display := Display{}
+	display[0][0] = 1
+	for text, size := range cases {
+		effects, err := ParseEffects(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		SetEffects(effects)
+		if got := PostProcess(DisplayImage(&display)).Rect.Size(); got != size {
+			t.Errorf("%s: size %v, expected %v", text, got, size)
+		}
+	}
+	// The last row and column of the lit pixel are darkened by the grid
+	SetEffects([]string{EFFECT_GRID})
+	img := PostProcess(DisplayImage(&display))
+	if img.RGBAAt(0, 0) != ActivePalette.Colors[1] || img.RGBAAt(EFFECT_PIXEL_SIZE-1, 0) == ActivePalette.Colors[1] {
+		t.Error("grid line not drawn at the edge of the pixel")
+	}
+}
+
+func TestNextEffects(t *testing.T) {
+	defer SetEffects(Effects)
+	SetEffects([]string{EFFECT_HQ2X, EFFECT_GRID})
+	seen := []string{"hq2x,grid"}
+	for i := 0; i <= len(effectPresets); i++ {
+		seen = append(seen, NextEffects())
+	}
+	if seen[1] != "none" || seen[2] != EFFECT_SCANLINES || seen[len(seen)-1] != "hq2x,grid" {
+		t.Errorf("cycle %v", seen)
+	}
+	SetEffects([]string{EFFECT_CRT})
+	if NextEffects() != EFFECT_SCANLINES+","+EFFECT_CRT {
+		t.Error("preset from the command line does not continue the cycle")
+	}
+}
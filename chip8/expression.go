@@ -0,0 +1,224 @@
+package chip8
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expressions of conditional breakpoints, e.g. V3 == 0x10 && I > 0x300
+// Operands are numbers, V0-VF, I, PC, SP, DT, ST and memory bytes as [address]
+// Operators follow go precedence: || && (== != < <= > >=) (+ - | ^) (&) and unary ! -
+type Expression struct {
+	Source string
+	eval   func() int
+}
+
+var binaryOperators = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-", "|", "^"},
+	{"&"},
+}
+
+type expressionParser struct {
+	tokens []string
+	pos    int
+}
+
+func ParseExpression(source string) (*Expression, error) {
+	tokens, err := tokenizeExpression(source)
+	if err != nil {
+		return nil, err
+	}
+	parser := &expressionParser{tokens: tokens}
+	eval, err := parser.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(tokens) {
+		return nil, fmt.Errorf("Unexpected %q in expression", tokens[parser.pos])
+	}
+	return &Expression{Source: source, eval: eval}, nil
+}
+
+func (e *Expression) Eval() int {
+	return e.eval()
+}
+
+func (e *Expression) IsTrue() bool {
+	return e.eval() != 0
+}
+
+func tokenizeExpression(source string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsLetter(c) || unicode.IsDigit(c):
+			start := i
+			for i < len(source) && (unicode.IsLetter(rune(source[i])) || unicode.IsDigit(rune(source[i])) || source[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, source[start:i])
+		case i+1 < len(source) && isOperatorOf(source[i:i+2], []string{"==", "!=", "<=", ">=", "&&", "||"}):
+			tokens = append(tokens, source[i:i+2])
+			i += 2
+		case strings.ContainsRune("<>+-|^&!()[]", c):
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			return nil, fmt.Errorf("Unexpected character %q in expression", c)
+		}
+	}
+	return tokens, nil
+}
+
+func (p *expressionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *expressionParser) expect(token string) error {
+	if p.peek() != token {
+		return fmt.Errorf("Expected %q in expression", token)
+	}
+	p.pos++
+	return nil
+}
+
+// Precedence climbing over binaryOperators, lowest level first
+func (p *expressionParser) parseBinary(level int) (func() int, error) {
+	if level == len(binaryOperators) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for isOperatorOf(p.peek(), binaryOperators[level]) {
+		operator := p.peek()
+		p.pos++
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryOperation(operator, left, right)
+	}
+	return left, nil
+}
+
+func isOperatorOf(token string, operators []string) bool {
+	for _, operator := range operators {
+		if token == operator {
+			return true
+		}
+	}
+	return false
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func binaryOperation(operator string, left, right func() int) func() int {
+	switch operator {
+	case "||":
+		return func() int { return boolToInt(left() != 0 || right() != 0) }
+	case "&&":
+		return func() int { return boolToInt(left() != 0 && right() != 0) }
+	case "==":
+		return func() int { return boolToInt(left() == right()) }
+	case "!=":
+		return func() int { return boolToInt(left() != right()) }
+	case "<":
+		return func() int { return boolToInt(left() < right()) }
+	case "<=":
+		return func() int { return boolToInt(left() <= right()) }
+	case ">":
+		return func() int { return boolToInt(left() > right()) }
+	case ">=":
+		return func() int { return boolToInt(left() >= right()) }
+	case "+":
+		return func() int { return left() + right() }
+	case "-":
+		return func() int { return left() - right() }
+	case "|":
+		return func() int { return left() | right() }
+	case "^":
+		return func() int { return left() ^ right() }
+	}
+	return func() int { return left() & right() }
+}
+
+func (p *expressionParser) parseUnary() (func() int, error) {
+	switch p.peek() {
+	case "!":
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func() int { return boolToInt(operand() == 0) }, nil
+	case "-":
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func() int { return -operand() }, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *expressionParser) parsePrimary() (func() int, error) {
+	token := p.peek()
+	p.pos++
+	switch token {
+	case "":
+		return nil, fmt.Errorf("Unexpected end of expression")
+	case "(":
+		inner, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	case "[":
+		address, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		return func() int {
+			return int(chip8.Cpu.Memory[uint(address())%uint(len(chip8.Cpu.Memory))])
+		}, p.expect("]")
+	}
+	if num, err := strconv.ParseInt(token, 0, 64); err == nil {
+		return func() int { return int(num) }, nil
+	}
+	switch strings.ToUpper(token) {
+	case "I":
+		return func() int { return int(chip8.Cpu.IndexRegister) }, nil
+	case "PC":
+		return func() int { return int(chip8.Cpu.ProgramCounter) }, nil
+	case "SP":
+		return func() int { return int(chip8.Cpu.StackPointer) }, nil
+	case "DT":
+		return func() int { return int(chip8.DelayTimer) }, nil
+	case "ST":
+		return func() int { return int(chip8.SoundTimer) }, nil
+	}
+	index, isRegister, err := parseIndexedTarget(strings.ToUpper(token))
+	if err != nil || !isRegister {
+		return nil, fmt.Errorf("Unknown operand %q in expression", token)
+	}
+	return func() int { return int(chip8.Cpu.Registers[index]) }, nil
+}
@@ -0,0 +1,155 @@
+package chip8
+
+import (
+	"os/signal"
+	"testing"
+)
+
+func TestExpressionPrecedence(t *testing.T) {
+	reset()
+	defer reset()
+	chip8.Cpu.Registers[0x1] = 2
+	chip8.Cpu.Registers[0x3] = 0x10
+	chip8.Cpu.IndexRegister = 0x300
+	chip8.Cpu.Memory[0x300] = 0x20
+	chip8.DelayTimer = 7
+	tests := []struct {
+		source string
+		value  int
+	}{
+		{"1 + 2 == 3", 1},
+		{"1 | 2 == 3", 1},
+		{"5 ^ 1 | 2", 6},
+		{"6 & 3 + 1", 3},
+		{"1 + 6 & 3", 3},
+		{"10 - 3 - 2", 5},
+		{"(1 + 2) & 1", 1},
+		{"1 == 1 && 2 == 3 || 4 > 3", 1},
+		{"0 || 1 && 0", 0},
+		{"1 < 2 == 1", 1},
+		{"!0 + 1", 2},
+		{"!(0 + 1)", 0},
+		{"-V1 + 5", 3},
+		{"v3 == 0x10 && I > 0x2FF", 1},
+		{"PC == 0x200 && SP == 0 && DT >= 7 && ST <= 0", 1},
+		{"[I] + [0x301]", 0x20},
+		{"[I - 0x100 + 0x100] == 0b100000", 1},
+	}
+	for _, test := range tests {
+		expression, err := ParseExpression(test.source)
+		if err != nil {
+			t.Errorf("%s: %v", test.source, err)
+			continue
+		}
+		if value := expression.Eval(); value != test.value {
+			t.Errorf("%s is %d, want %d", test.source, value, test.value)
+		}
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	tests := map[string]string{
+		"1 +":        "Unexpected end of expression",
+		"(1 + 2":     `Expected ")" in expression`,
+		"[0x300":     `Expected "]" in expression`,
+		"V3 == == 2": `Unknown operand "==" in expression`,
+		"VG":         `Unknown operand "VG" in expression`,
+		"S1 == 0":    `Unknown operand "S1" in expression`,
+		"1 $ 2":      `Unexpected character '$' in expression`,
+		"1 2":        `Unexpected "2" in expression`,
+	}
+	for source, message := range tests {
+		if _, err := ParseExpression(source); err == nil || err.Error() != message {
+			t.Errorf("%s gives the error %v, want %s", source, err, message)
+		}
+	}
+}
+
+// Load the program into a cleared machine with a debugger whose stops are not waited for
+func startDebugger(t *testing.T, program []byte) *Debugger {
+	frontend = headlessFrontend{}
+	reset()
+	copy(chip8.Cpu.Memory[START_ADDRESS:], program)
+	d := NewDebugger()
+	go func() {
+		for range d.done {
+		}
+	}()
+	t.Cleanup(func() {
+		signal.Stop(d.interrupt)
+		close(d.done)
+		memoryHook = nil
+		reset()
+	})
+	return d
+}
+
+// Continue until the debugger stops, false if it is still running after the cycles
+func continueDebugger(d *Debugger, cycles int) bool {
+	d.resume(nil, "")
+	for i := 0; i < cycles; i++ {
+		if !d.beforeCycle() {
+			return true
+		}
+		cycle()
+		d.afterCycle()
+		if d.paused {
+			return true
+		}
+	}
+	return false
+}
+
+func TestConditionalBreakpoint(t *testing.T) {
+	// Count V0 up forever
+	d := startDebugger(t, []byte{0x70, 0x01, 0x12, 0x00})
+	if err := d.setBreakpoint([]string{"0x202", "if", "V0", ">=", "2", "&&", "V0", "!=", "4"}); err != nil {
+		t.Fatal(err)
+	}
+	if !continueDebugger(d, 100) {
+		t.Fatal("The breakpoint was not hit")
+	}
+	if chip8.Cpu.ProgramCounter != 0x202 || chip8.Cpu.Registers[0] != 2 {
+		t.Errorf("Stopped at 0x%03X with V0=%d, want 0x202 with V0=2", chip8.Cpu.ProgramCounter, chip8.Cpu.Registers[0])
+	}
+	// Hits where the condition is false are not counted, V0 4 is skipped
+	if !continueDebugger(d, 100) || chip8.Cpu.Registers[0] != 3 {
+		t.Errorf("Second stop has V0=%d, want 3", chip8.Cpu.Registers[0])
+	}
+	if !continueDebugger(d, 100) || chip8.Cpu.Registers[0] != 5 || d.Breakpoints[0x202].Hits != 3 {
+		t.Errorf("Third stop has V0=%d after %d hits, want 5 after 3", chip8.Cpu.Registers[0], d.Breakpoints[0x202].Hits)
+	}
+	if err := d.setBreakpoint([]string{"0x202", "after", "2", "if", "V0", "&", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	// Odd V0 7 and 9 are skipped
+	if !continueDebugger(d, 100) || chip8.Cpu.Registers[0] != 11 {
+		t.Errorf("Stop after the ignored hits has V0=%d, want 11", chip8.Cpu.Registers[0])
+	}
+}
+
+func TestWriteWatchpoint(t *testing.T) {
+	d := startDebugger(t, []byte{
+		0x6A, 0x7B, // LD VA, 123
+		0xA3, 0x00, // LD I, 0x300
+		0xF0, 0x65, // LD V0, [I] reads 0x300
+		0xFA, 0x33, // LD B, VA writes 0x300-0x302
+		0x12, 0x08, // JP 0x208
+	})
+	if err := d.setWatchpoint([]string{"0x301-0x302"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.setWatchpoint([]string{"0x310"}); err != nil {
+		t.Fatal(err)
+	}
+	if !continueDebugger(d, 100) {
+		t.Fatal("The watchpoint was not hit")
+	}
+	// The stop comes after the instruction which wrote
+	if chip8.Cpu.ProgramCounter != 0x208 || chip8.Cpu.Memory[0x301] != 2 || chip8.Cpu.Memory[0x302] != 3 {
+		t.Errorf("Stopped at 0x%03X with % X at 0x300", chip8.Cpu.ProgramCounter, chip8.Cpu.Memory[0x300:0x303])
+	}
+	if continueDebugger(d, 100) {
+		t.Errorf("Stopped again at 0x%03X without writes", chip8.Cpu.ProgramCounter)
+	}
+}
@@ -0,0 +1,156 @@
+package chip8
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Display, sound and input of the machine, the SDL window unless the machine runs headless
+type Frontend interface {
+	Start(display *Display)
+	Clear(display *Display)
+	Render(display *Display)
+	PlayAudio()
+	PauseAudio()
+	// Poll the input, quit is called when the user closes the frontend
+	HandleEvents(quit func(), keypad *Keypad)
+	// Release the window or the terminal before the emulator exits
+	Close()
+}
+
+var frontend Frontend = headlessFrontend{}
+
+// Frontends selectable by name, the SDL one is left out of the nosdl and js builds
+var frontends = map[string]Frontend{
+	"headless": headlessFrontend{},
+	"tty":      &ttyFrontend{},
+}
+
+// The SDL window when it is built in, the terminal otherwise
+func DefaultFrontend() string {
+	if _, isExists := frontends["sdl"]; isExists {
+		return "sdl"
+	}
+	return "tty"
+}
+
+func FrontendNames() []string {
+	names := make([]string, 0, len(frontends))
+	for name := range frontends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func SetFrontend(name string) error {
+	selected, isExists := frontends[name]
+	if !isExists {
+		return fmt.Errorf("Unknown frontend %q, use %s", name, strings.Join(FrontendNames(), ", "))
+	}
+	frontend = selected
+	return nil
+}
+
+// Chip-8 keys of the keyboard keys
+// 1 2 3 C    1 2 3 4
+// 4 5 6 D    q w e r
+// 7 8 9 E    a s d f
+// A 0 B F    z x c v
+var keyMap = map[uint8]uint8{
+	'1': 0x1,
+	'2': 0x2,
+	'3': 0x3,
+	'4': 0xC,
+	'q': 0x4,
+	'w': 0x5,
+	'e': 0x6,
+	'r': 0xD,
+	'a': 0x7,
+	's': 0x8,
+	'd': 0x9,
+	'f': 0xE,
+	'z': 0xA,
+	'x': 0x0,
+	'c': 0xB,
+	'v': 0xF,
+}
+
+var DisplayScale int32
+
+// Margin around the display in the window and the border drawn in it
+const DISPLAY_PADDING = 90
+const BORDER_PADDING = 10
+
+// Filters used when the display is scaled up to the window
+const (
+	TEXTURE_FILTER_NEAREST = "nearest"
+	TEXTURE_FILTER_LINEAR  = "linear"
+)
+
+var TextureFilter = TEXTURE_FILTER_NEAREST
+
+func SetTextureFilter(filter string) error {
+	if filter != TEXTURE_FILTER_NEAREST && filter != TEXTURE_FILTER_LINEAR {
+		return fmt.Errorf("Unknown texture filter %q, use %s or %s", filter, TEXTURE_FILTER_NEAREST, TEXTURE_FILTER_LINEAR)
+	}
+	TextureFilter = filter
+	return nil
+}
+
+// Integer scaling keeps every pixel the same size, fractional scaling fills the window
+const (
+	SCALING_INTEGER    = "integer"
+	SCALING_FRACTIONAL = "fractional"
+)
+
+var (
+	Scaling      = SCALING_INTEGER
+	IsFullscreen bool
+)
+
+func SetScaling(scaling string) error {
+	if scaling != SCALING_INTEGER && scaling != SCALING_FRACTIONAL {
+		return fmt.Errorf("Unknown scaling %q, use %s or %s", scaling, SCALING_INTEGER, SCALING_FRACTIONAL)
+	}
+	Scaling = scaling
+	return nil
+}
+
+// Position and size of the display letterboxed in the middle of the window, the margins
+// shrink to the border when the window is too small for them
+func displayLayout(windowWidth int32, windowHeight int32) (x int32, y int32, width int32, height int32) {
+	scale := func(padding int32) float64 {
+		horizontal := float64(windowWidth-2*padding) / WIDTH
+		vertical := float64(windowHeight-2*padding) / HEIGHT
+		if vertical < horizontal {
+			horizontal = vertical
+		}
+		if Scaling == SCALING_INTEGER {
+			return float64(int32(horizontal))
+		}
+		return horizontal
+	}
+	displayScale := scale(DISPLAY_PADDING)
+	if displayScale < 1 {
+		displayScale = scale(BORDER_PADDING)
+	}
+	if displayScale < 1 {
+		displayScale = 1
+	}
+	width = int32(WIDTH * displayScale)
+	height = int32(HEIGHT * displayScale)
+	return (windowWidth - width) / 2, (windowHeight - height) / 2, width, height
+}
+
+// Frontend without a window or sound, the keypad is set by the caller
+type headlessFrontend struct{}
+
+func (headlessFrontend) Start(display *Display)                   {}
+func (headlessFrontend) Clear(display *Display)                   {}
+func (headlessFrontend) Render(display *Display)                  {}
+func (headlessFrontend) PlayAudio()                               {}
+func (headlessFrontend) PauseAudio()                              {}
+func (headlessFrontend) HandleEvents(quit func(), keypad *Keypad) {}
+func (headlessFrontend) Close()                                   {}
@@ -0,0 +1,35 @@
+package chip8
+
+import "testing"
+
+func TestDisplayLayout(t *testing.T) {
+	defer SetScaling(Scaling)
+	cases := []struct {
+		scaling       string
+		width, height int32
+		x, y, w, h    int32
+	}{
+		// The window created for scale 12
+		{SCALING_INTEGER, 948, 564, 90, 90, 768, 384},
+		// Wider windows are letterboxed at the sides
+		{SCALING_INTEGER, 1920, 1080, 96, 108, 1728, 864},
+		{SCALING_FRACTIONAL, 1920, 1080, 90, 105, 1740, 870},
+		// Taller windows at the top and bottom
+		{SCALING_INTEGER, 500, 1000, 90, 420, 320, 160},
+		// The margins shrink to the border in small windows
+		{SCALING_INTEGER, 200, 100, 36, 18, 128, 64},
+		{SCALING_FRACTIONAL, 200, 100, 20, 10, 160, 80},
+		// Windows smaller than the display keep it at scale 1
+		{SCALING_INTEGER, 40, 20, -12, -6, 64, 32},
+	}
+	for _, c := range cases {
+		if err := SetScaling(c.scaling); err != nil {
+			t.Fatal(err)
+		}
+		x, y, w, h := displayLayout(c.width, c.height)
+		if x != c.x || y != c.y || w != c.w || h != c.h {
+			t.Errorf("%s %dx%d: got %d,%d %dx%d, expected %d,%d %dx%d",
+				c.scaling, c.width, c.height, x, y, w, h, c.x, c.y, c.w, c.h)
+		}
+	}
+}
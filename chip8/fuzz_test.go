@@ -0,0 +1,124 @@
+package chip8
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const FUZZ_CYCLES = 2000
+
+// Size of the machine state read by setMachineState
+const FUZZ_STATE_SIZE = 16 + 2 + 2 + 1 + 2*16 + 1 + 1 + 2
+
+func addRomSeeds(f *testing.F, add func(rom []byte)) {
+	roms, err := filepath.Glob("../roms/*.ch8")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range roms {
+		rom, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		add(rom)
+	}
+}
+
+// Load the rom into a cleared machine, logs are discarded since they would fill the
+// output of the fuzzing workers
+func loadFuzzRom(rom []byte) {
+	log.SetOutput(io.Discard)
+	frontend = headlessFrontend{}
+	reset()
+	loadFonts()
+	copy(chip8.Cpu.Memory[START_ADDRESS:], rom)
+}
+
+// Set the registers, I, PC, SP, the stack, the timers and the keypad from the bytes,
+// missing bytes are 0 and the state is kept within the bounds the core relies on
+func setMachineState(state []byte) {
+	padded := make([]byte, FUZZ_STATE_SIZE)
+	copy(padded, state)
+	for i := range chip8.Cpu.Registers {
+		chip8.Cpu.Registers[i] = Register(padded[i])
+	}
+	padded = padded[16:]
+	chip8.Cpu.IndexRegister = IndexRegister(binary.BigEndian.Uint16(padded))
+	chip8.Cpu.ProgramCounter = ProgramCounter(binary.BigEndian.Uint16(padded[2:]) % uint16(len(chip8.Cpu.Memory)))
+	chip8.Cpu.StackPointer = StackPointer(int(padded[4]) % (len(chip8.Cpu.ProgramStack) + 1))
+	padded = padded[5:]
+	for i := range chip8.Cpu.ProgramStack {
+		chip8.Cpu.ProgramStack[i] = ProgramCounter(binary.BigEndian.Uint16(padded[2*i:]) & 0x0FFF)
+	}
+	padded = padded[2*len(chip8.Cpu.ProgramStack):]
+	chip8.DelayTimer = DelayTimer(padded[0])
+	chip8.SoundTimer = SoundTimer(padded[1])
+	keys := binary.BigEndian.Uint16(padded[2:])
+	for i := range chip8.Keypad {
+		chip8.Keypad[i] = keys&(1<<i) != 0
+	}
+}
+
+func checkInvariants(t *testing.T, cycle int) {
+	t.Helper()
+	if int(chip8.Cpu.ProgramCounter) >= len(chip8.Cpu.Memory) {
+		t.Fatalf("cycle %d: PC 0x%X outside memory", cycle, chip8.Cpu.ProgramCounter)
+	}
+	if int(chip8.Cpu.StackPointer) > len(chip8.Cpu.ProgramStack) {
+		t.Fatalf("cycle %d: SP %d outside the stack", cycle, chip8.Cpu.StackPointer)
+	}
+	for x := range chip8.Display {
+		for y, pixel := range chip8.Display[x] {
+			if pixel > 1 {
+				t.Fatalf("cycle %d: pixel %d,%d is %d", cycle, x, y, pixel)
+			}
+		}
+	}
+}
+
+// Run the cycles until the machine faults, checking the invariants after each of them
+func runFuzzCycles(t *testing.T) {
+	for i := 0; i < FUZZ_CYCLES && chip8.Fault == nil; i++ {
+		cycle()
+		checkInvariants(t, i)
+	}
+}
+
+// Arbitrary rom bytes booted from the start address
+func FuzzRom(f *testing.F) {
+	addRomSeeds(f, func(rom []byte) {
+		f.Add(rom)
+	})
+	// Stack overflow, underflow, end of memory and out of range keys, fonts and I
+	f.Add([]byte{0x22, 0x00})
+	f.Add([]byte{0x00, 0xEE})
+	f.Add([]byte{0x1F, 0xFE})
+	f.Add([]byte{0x60, 0xFF, 0xE0, 0x9E, 0xE0, 0xA1, 0xF0, 0x29, 0xAF, 0xFF, 0xF0, 0x33, 0xFF, 0x65, 0xD0, 0x0F})
+	f.Fuzz(func(t *testing.T, rom []byte) {
+		loadFuzzRom(rom)
+		runFuzzCycles(t)
+	})
+}
+
+// Arbitrary machine states running arbitrary memory contents
+func FuzzMachineState(f *testing.F) {
+	addRomSeeds(f, func(rom []byte) {
+		f.Add(make([]byte, FUZZ_STATE_SIZE), rom)
+	})
+	full := make([]byte, FUZZ_STATE_SIZE)
+	for i := range full {
+		full[i] = 0xFF
+	}
+	f.Add(full, []byte{0xF0, 0x29, 0xE0, 0x9E, 0xF0, 0x55, 0x00, 0xEE, 0xBF, 0xFF})
+	f.Fuzz(func(t *testing.T, state []byte, memory []byte) {
+		loadFuzzRom(nil)
+		copy(chip8.Cpu.Memory[START_ADDRESS:], memory)
+		setMachineState(state)
+		checkInvariants(t, -1)
+		runFuzzCycles(t)
+	})
+}
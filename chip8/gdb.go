@@ -0,0 +1,509 @@
+package chip8
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Register numbers of the gdb stub, V0-VF are 0-15
+const (
+	GDB_REGISTER_I = 16 + iota
+	GDB_REGISTER_PC
+	GDB_REGISTER_SP
+	GDB_REGISTER_DT
+	GDB_REGISTER_ST
+	GDB_REGISTER_COUNT
+)
+
+const (
+	GDB_SIGINT  = 2
+	GDB_SIGTRAP = 5
+)
+
+type gdbPacket struct {
+	conn net.Conn
+	data string
+	// The connection is closed
+	closed bool
+}
+
+// GDB Remote Serial Protocol stub, packets are read on their own goroutine
+// and handled by the emulation loop between cycles like the debugger commands
+type GDBStub struct {
+	Breakpoints map[ProgramCounter]bool
+	listener    net.Listener
+	conn        net.Conn
+	connections chan net.Conn
+	packets     chan gdbPacket
+	paused      bool
+	stepping    bool
+	// Do not stop on the breakpoint at PC when resuming from it
+	skipBreakpoint bool
+	lastSignal     int
+}
+
+// Listen on a tcp address or on a unix socket with the unix: prefix
+func NewGDBStub(address string) (*GDBStub, error) {
+	network := "tcp"
+	if strings.HasPrefix(address, "unix:") {
+		network, address = "unix", strings.TrimPrefix(address, "unix:")
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	s := &GDBStub{
+		Breakpoints: map[ProgramCounter]bool{},
+		listener:    listener,
+		connections: make(chan net.Conn),
+		packets:     make(chan gdbPacket),
+		paused:      true,
+		lastSignal:  GDB_SIGTRAP,
+	}
+	go s.accept()
+	return s, nil
+}
+
+// Boot with the gdb stub listening on the address, the machine waits for a client to attach
+func ServeGDB(romPath string, address string, displayScale int32, speed uint8) {
+	setup(romPath, displayScale, speed)
+	log.SetOutput(io.Discard)
+	stub, err := NewGDBStub(address)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println("Waiting for gdb on", stub.listener.Addr())
+	control = stub
+	loop()
+}
+
+func (s *GDBStub) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.connections <- conn
+	}
+}
+
+// Read packets of the connection, acknowledge them until no ack mode is started
+func (s *GDBStub) read(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	noAck := false
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			s.packets <- gdbPacket{conn: conn, closed: true}
+			return
+		}
+		switch b {
+		case 0x03:
+			s.packets <- gdbPacket{conn: conn, data: "\x03"}
+		case '$':
+			data, err := readPacket(r)
+			if err != nil {
+				if !noAck {
+					conn.Write([]byte("-"))
+				}
+				continue
+			}
+			if !noAck {
+				conn.Write([]byte("+"))
+			}
+			noAck = noAck || data == "QStartNoAckMode"
+			s.packets <- gdbPacket{conn: conn, data: data}
+		}
+	}
+}
+
+// Read the packet data after $ and check the checksum
+func readPacket(r *bufio.Reader) (string, error) {
+	raw, err := r.ReadBytes('#')
+	if err != nil {
+		return "", err
+	}
+	raw = raw[:len(raw)-1]
+	checksum := make([]byte, 2)
+	if _, err = io.ReadFull(r, checksum); err != nil {
+		return "", err
+	}
+	sum, err := strconv.ParseUint(string(checksum), 16, 8)
+	if err != nil || uint8(sum) != packetChecksum(raw) {
+		return "", fmt.Errorf("Bad checksum")
+	}
+	// } escapes the next byte xor 0x20
+	var data []byte
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '}' && i+1 < len(raw) {
+			i++
+			data = append(data, raw[i]^0x20)
+		} else {
+			data = append(data, raw[i])
+		}
+	}
+	return string(data), nil
+}
+
+func packetChecksum(data []byte) uint8 {
+	sum := uint8(0)
+	for _, b := range data {
+		sum += b
+	}
+	return sum
+}
+
+func (s *GDBStub) send(data string) {
+	if s.conn == nil {
+		return
+	}
+	var escaped []byte
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case '$', '#', '}', '*':
+			escaped = append(escaped, '}', data[i]^0x20)
+		default:
+			escaped = append(escaped, data[i])
+		}
+	}
+	fmt.Fprintf(s.conn, "$%s#%02x", escaped, packetChecksum(escaped))
+}
+
+func (s *GDBStub) beforeCycle() bool {
+	// Handle every pending packet, gdb sends many small queries when stopped
+	for isPending := true; isPending; {
+		select {
+		case conn := <-s.connections:
+			s.attach(conn)
+		case packet := <-s.packets:
+			if packet.conn != s.conn {
+				continue
+			}
+			if packet.closed {
+				s.detach()
+			} else {
+				s.handle(packet.data)
+			}
+		default:
+			isPending = false
+		}
+	}
+	if s.paused {
+		return false
+	}
+	if !s.skipBreakpoint && s.Breakpoints[chip8.Cpu.ProgramCounter] {
+		s.stop(GDB_SIGTRAP)
+		return false
+	}
+	s.skipBreakpoint = false
+	return true
+}
+
+func (s *GDBStub) afterCycle() {
+	if s.stepping {
+		s.stop(GDB_SIGTRAP)
+	}
+}
+
+// Only one client at a time, the machine stops when it attaches
+func (s *GDBStub) attach(conn net.Conn) {
+	if s.conn != nil {
+		conn.Close()
+		return
+	}
+	s.conn = conn
+	s.paused = true
+	s.lastSignal = GDB_SIGTRAP
+	go s.read(conn)
+}
+
+// Remove the breakpoints and let the machine run
+func (s *GDBStub) detach() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn = nil
+	s.Breakpoints = map[ProgramCounter]bool{}
+	s.paused = false
+	s.stepping = false
+}
+
+func (s *GDBStub) stop(signal int) {
+	s.paused = true
+	s.stepping = false
+	s.lastSignal = signal
+	s.send(fmt.Sprintf("S%02x", signal))
+}
+
+// c[addr] and s[addr], a PC outside of the memory is refused and the machine stays stopped
+func (s *GDBStub) resume(args string, stepping bool) {
+	if args != "" {
+		address, err := strconv.ParseUint(args, 16, 16)
+		if err != nil || address >= uint64(len(chip8.Cpu.Memory)) {
+			s.send("E01")
+			return
+		}
+		chip8.Cpu.ProgramCounter = ProgramCounter(address)
+	}
+	s.paused = false
+	s.stepping = stepping
+	s.skipBreakpoint = true
+}
+
+func (s *GDBStub) handle(data string) {
+	if data == "\x03" {
+		if !s.paused {
+			s.stop(GDB_SIGINT)
+		}
+		return
+	}
+	if data == "" {
+		s.send("")
+		return
+	}
+	args := data[1:]
+	switch data[0] {
+	case '?':
+		s.send(fmt.Sprintf("S%02x", s.lastSignal))
+	case 'g':
+		var registers strings.Builder
+		for i := 0; i < GDB_REGISTER_COUNT; i++ {
+			registers.WriteString(readGDBRegister(i))
+		}
+		s.send(registers.String())
+	case 'G':
+		s.send(writeGDBRegisters(args))
+	case 'p':
+		number, err := strconv.ParseUint(args, 16, 8)
+		if err != nil || number >= GDB_REGISTER_COUNT {
+			s.send("E01")
+			return
+		}
+		s.send(readGDBRegister(int(number)))
+	case 'P':
+		s.send(writeGDBRegister(args))
+	case 'm':
+		s.send(readGDBMemory(args))
+	case 'M':
+		s.send(writeGDBMemory(args))
+	case 'c':
+		s.resume(args, false)
+	case 's':
+		s.resume(args, true)
+	case 'Z', 'z':
+		s.send(s.setBreakpoint(data[0] == 'Z', args))
+	case 'H', 'T':
+		s.send("OK")
+	case 'D':
+		s.send("OK")
+		s.detach()
+	case 'k':
+		s.detach()
+		Shutdown(0)
+	case 'q', 'Q':
+		s.send(s.query(data))
+	default:
+		s.send("")
+	}
+}
+
+// General queries, unsupported ones get the empty reply
+func (s *GDBStub) query(data string) string {
+	switch {
+	case strings.HasPrefix(data, "qSupported"):
+		return "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+"
+	case data == "QStartNoAckMode":
+		return "OK"
+	case data == "qAttached":
+		return "1"
+	case data == "qC":
+		return "QC1"
+	case data == "qfThreadInfo":
+		return "m1"
+	case data == "qsThreadInfo":
+		return "l"
+	case strings.HasPrefix(data, "qXfer:features:read:target.xml:"):
+		var offset, length int
+		if _, err := fmt.Sscanf(strings.TrimPrefix(data, "qXfer:features:read:target.xml:"), "%x,%x", &offset, &length); err != nil {
+			return "E01"
+		}
+		description := gdbTargetDescription()
+		if offset >= len(description) {
+			return "l"
+		}
+		if offset+length >= len(description) {
+			return "l" + description[offset:]
+		}
+		return "m" + description[offset:offset+length]
+	}
+	return ""
+}
+
+// Register layout for the client, there is no chip8 architecture in gdb
+func gdbTargetDescription() string {
+	var description strings.Builder
+	description.WriteString(`<?xml version="1.0"?><!DOCTYPE target SYSTEM "gdb-target.dtd"><target version="1.0"><feature name="org.chip8.core">`)
+	for i := 0; i < 16; i++ {
+		fmt.Fprintf(&description, `<reg name="v%x" bitsize="8" type="uint8"/>`, i)
+	}
+	description.WriteString(`<reg name="i" bitsize="16" type="data_ptr"/>`)
+	description.WriteString(`<reg name="pc" bitsize="16" type="code_ptr"/>`)
+	description.WriteString(`<reg name="sp" bitsize="8" type="uint8"/>`)
+	description.WriteString(`<reg name="dt" bitsize="8" type="uint8"/>`)
+	description.WriteString(`<reg name="st" bitsize="8" type="uint8"/>`)
+	description.WriteString(`</feature></target>`)
+	return description.String()
+}
+
+// Registers are hex encoded in target byte order, little endian
+func readGDBRegister(number int) string {
+	switch number {
+	case GDB_REGISTER_I:
+		i := uint16(chip8.Cpu.IndexRegister)
+		return hex.EncodeToString([]byte{byte(i), byte(i >> 8)})
+	case GDB_REGISTER_PC:
+		pc := uint16(chip8.Cpu.ProgramCounter)
+		return hex.EncodeToString([]byte{byte(pc), byte(pc >> 8)})
+	case GDB_REGISTER_SP:
+		return fmt.Sprintf("%02x", chip8.Cpu.StackPointer)
+	case GDB_REGISTER_DT:
+		return fmt.Sprintf("%02x", chip8.DelayTimer)
+	case GDB_REGISTER_ST:
+		return fmt.Sprintf("%02x", chip8.SoundTimer)
+	}
+	return fmt.Sprintf("%02x", chip8.Cpu.Registers[number])
+}
+
+func gdbRegisterSize(number int) int {
+	if number == GDB_REGISTER_I || number == GDB_REGISTER_PC {
+		return 2
+	}
+	return 1
+}
+
+// SP counts the return addresses on the stack, it is full at the size of the stack
+// PC has to be inside of the memory
+func isValidGDBRegister(number int, value []byte) bool {
+	switch number {
+	case GDB_REGISTER_SP:
+		return int(value[0]) <= len(chip8.Cpu.ProgramStack)
+	case GDB_REGISTER_PC:
+		return int(uint16(value[0])|uint16(value[1])<<8) < len(chip8.Cpu.Memory)
+	}
+	return true
+}
+
+func setGDBRegister(number int, value []byte) {
+	switch number {
+	case GDB_REGISTER_I:
+		chip8.Cpu.IndexRegister = IndexRegister(uint16(value[0]) | uint16(value[1])<<8)
+	case GDB_REGISTER_PC:
+		chip8.Cpu.ProgramCounter = ProgramCounter(uint16(value[0]) | uint16(value[1])<<8)
+	case GDB_REGISTER_SP:
+		chip8.Cpu.StackPointer = StackPointer(value[0])
+	case GDB_REGISTER_DT:
+		chip8.DelayTimer = DelayTimer(value[0])
+	case GDB_REGISTER_ST:
+		chip8.SoundTimer = SoundTimer(value[0])
+	default:
+		chip8.Cpu.Registers[number] = Register(value[0])
+	}
+}
+
+// G<hex of all registers>
+func writeGDBRegisters(args string) string {
+	data, err := hex.DecodeString(args)
+	if err != nil {
+		return "E01"
+	}
+	// Nothing is written when one of the values is invalid
+	for i, rest := 0, data; i < GDB_REGISTER_COUNT && len(rest) >= gdbRegisterSize(i); i++ {
+		if !isValidGDBRegister(i, rest) {
+			return "E01"
+		}
+		rest = rest[gdbRegisterSize(i):]
+	}
+	for i := 0; i < GDB_REGISTER_COUNT && len(data) >= gdbRegisterSize(i); i++ {
+		setGDBRegister(i, data)
+		data = data[gdbRegisterSize(i):]
+	}
+	return "OK"
+}
+
+// P<n>=<hex>
+func writeGDBRegister(args string) string {
+	numberText, valueText, isFound := strings.Cut(args, "=")
+	number, err := strconv.ParseUint(numberText, 16, 8)
+	if !isFound || err != nil || number >= GDB_REGISTER_COUNT {
+		return "E01"
+	}
+	value, err := hex.DecodeString(valueText)
+	if err != nil || len(value) < gdbRegisterSize(int(number)) || !isValidGDBRegister(int(number), value) {
+		return "E01"
+	}
+	setGDBRegister(int(number), value)
+	return "OK"
+}
+
+// Parse addr,length and check that the range is inside of the memory
+func parseGDBRange(args string) (int, int, error) {
+	var address, length int
+	if _, err := fmt.Sscanf(args, "%x,%x", &address, &length); err != nil {
+		return 0, 0, err
+	}
+	// length is compared to the rest of the memory, address+length can overflow
+	if address < 0 || length < 0 || address > len(chip8.Cpu.Memory) || length > len(chip8.Cpu.Memory)-address {
+		return 0, 0, fmt.Errorf("Out of memory")
+	}
+	return address, length, nil
+}
+
+// m<addr>,<length>
+func readGDBMemory(args string) string {
+	address, length, err := parseGDBRange(args)
+	if err != nil {
+		return "E01"
+	}
+	return hex.EncodeToString(chip8.Cpu.Memory[address : address+length])
+}
+
+// M<addr>,<length>:<hex>
+func writeGDBMemory(args string) string {
+	rangeText, dataText, _ := strings.Cut(args, ":")
+	address, length, err := parseGDBRange(rangeText)
+	if err != nil {
+		return "E01"
+	}
+	data, err := hex.DecodeString(dataText)
+	if err != nil || len(data) != length {
+		return "E01"
+	}
+	copy(chip8.Cpu.Memory[address:], data)
+	return "OK"
+}
+
+// Z0/z0 software and Z1/z1 hardware breakpoints, both stop before the instruction at the address
+func (s *GDBStub) setBreakpoint(isInsert bool, args string) string {
+	fields := strings.Split(args, ",")
+	if len(fields) < 2 || (fields[0] != "0" && fields[0] != "1") {
+		return ""
+	}
+	address, err := strconv.ParseUint(fields[1], 16, 16)
+	if err != nil {
+		return "E01"
+	}
+	if isInsert {
+		s.Breakpoints[ProgramCounter(address)] = true
+	} else {
+		delete(s.Breakpoints, ProgramCounter(address))
+	}
+	return "OK"
+}
@@ -0,0 +1,284 @@
+package chip8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// SP 16 is a full stack, larger values are refused without wrapping
+func TestWriteGDBStackPointer(t *testing.T) {
+	reset()
+	defer reset()
+	register := "12="
+	if reply := writeGDBRegister(register + "10"); reply != "OK" || chip8.Cpu.StackPointer != 16 {
+		t.Errorf("Writing SP 16 replied %s and set %d", reply, chip8.Cpu.StackPointer)
+	}
+	if reply := writeGDBRegister(register + "11"); reply != "E01" || chip8.Cpu.StackPointer != 16 {
+		t.Errorf("Writing SP 17 replied %s and set %d", reply, chip8.Cpu.StackPointer)
+	}
+}
+
+// Packet of the data as it is sent, without escapes
+func gdbFrame(data string) string {
+	return fmt.Sprintf("$%s#%02x", data, packetChecksum([]byte(data)))
+}
+
+func TestGDBReadPacket(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		data string
+		err  bool
+	}{
+		{"query", gdbFrame("?"), "?", false},
+		{"memory", gdbFrame("m200,4"), "m200,4", false},
+		{"literal", "$g#67", "g", false},
+		{"empty", gdbFrame(""), "", false},
+		// } escapes the next byte xor 0x20
+		{"escapes", gdbFrame("X1,3:}]}\x03}\x04"), "X1,3:}#$", false},
+		{"bad checksum", "$g#68", "", true},
+		{"checksum not hex", "$g#zz", "", true},
+		{"cut off", "$m200", "", true},
+	}
+	for _, test := range tests {
+		r := bufio.NewReader(strings.NewReader(strings.TrimPrefix(test.raw, "$")))
+		data, err := readPacket(r)
+		if (err != nil) != test.err || data != test.data {
+			t.Errorf("%s: read %q %v, want %q", test.name, data, err, test.data)
+		}
+	}
+}
+
+// Stub attached to one end of a pipe, the other end reads its packets
+func pipeGDBStub(t *testing.T) (*GDBStub, net.Conn, *bufio.Reader) {
+	frontend = headlessFrontend{}
+	reset()
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+		reset()
+	})
+	s := &GDBStub{
+		Breakpoints: map[ProgramCounter]bool{},
+		conn:        server,
+		packets:     make(chan gdbPacket),
+		paused:      true,
+		lastSignal:  GDB_SIGTRAP,
+	}
+	return s, client, bufio.NewReader(client)
+}
+
+// Run the action of the stub and return the packet it sends, the pipe blocks until it is read
+func gdbReply(t *testing.T, r *bufio.Reader, action func()) string {
+	t.Helper()
+	go action()
+	if b, err := r.ReadByte(); err != nil || b != '$' {
+		t.Fatalf("Reply starts with %q %v", b, err)
+	}
+	reply, err := readPacket(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+func TestGDBSendEscapes(t *testing.T) {
+	s, _, r := pipeGDBStub(t)
+	data := "a$b#c}d*e"
+	go s.send(data)
+	raw, err := r.ReadString('#')
+	if err != nil || raw != "$a}\x04b}\x03c}]d}\x0ae#" {
+		t.Errorf("Sent %q %v", raw, err)
+	}
+	checksum := make([]byte, 2)
+	io.ReadFull(r, checksum)
+	if string(checksum) != fmt.Sprintf("%02x", packetChecksum([]byte(raw[1:len(raw)-1]))) {
+		t.Errorf("Checksum %s is not of the escaped data", checksum)
+	}
+}
+
+// Packets are acknowledged with + and - until no ack mode
+func TestGDBAcknowledge(t *testing.T) {
+	s, client, r := pipeGDBStub(t)
+	go s.read(s.conn)
+	receive := func() string {
+		select {
+		case packet := <-s.packets:
+			return packet.data
+		case <-time.After(time.Second):
+			t.Fatal("No packet was received")
+		}
+		return ""
+	}
+	ack := func() byte {
+		b, err := r.ReadByte()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	go client.Write([]byte("$g#68"))
+	if b := ack(); b != '-' {
+		t.Errorf("A bad checksum is acknowledged with %q", b)
+	}
+	go client.Write([]byte(gdbFrame("QStartNoAckMode")))
+	if b := ack(); b != '+' {
+		t.Errorf("A packet is acknowledged with %q", b)
+	}
+	if data := receive(); data != "QStartNoAckMode" {
+		t.Errorf("Received %q", data)
+	}
+	// Without the ack the packet comes through while nothing reads the client
+	go client.Write([]byte(gdbFrame("g")))
+	if data := receive(); data != "g" {
+		t.Errorf("Received %q", data)
+	}
+	go client.Write([]byte{0x03})
+	if data := receive(); data != "\x03" {
+		t.Errorf("The interrupt is %q", data)
+	}
+}
+
+func TestGDBRegisters(t *testing.T) {
+	s, _, r := pipeGDBStub(t)
+	chip8.Cpu.Registers[0x0] = 0x12
+	chip8.Cpu.Registers[0xF] = 0x01
+	chip8.Cpu.IndexRegister = 0x345
+	chip8.Cpu.ProgramCounter = 0x200
+	chip8.Cpu.StackPointer = 2
+	chip8.DelayTimer = 0x3C
+	registers := "12" + strings.Repeat("00", 14) + "01" + "4503" + "0002" + "02" + "3c" + "00"
+	if reply := gdbReply(t, r, func() { s.handle("g") }); reply != registers {
+		t.Errorf("g replied %s, want %s", reply, registers)
+	}
+	written := "ff" + strings.Repeat("00", 15) + "0003" + "2002" + "10" + "00" + "05"
+	if reply := gdbReply(t, r, func() { s.handle("G" + written) }); reply != "OK" {
+		t.Errorf("G replied %s", reply)
+	}
+	if chip8.Cpu.Registers[0] != 0xFF || chip8.Cpu.IndexRegister != 0x300 || chip8.Cpu.ProgramCounter != 0x220 ||
+		chip8.Cpu.StackPointer != 16 || chip8.SoundTimer != 5 {
+		t.Errorf("G wrote V0=%X I=%X PC=%X SP=%d ST=%d", chip8.Cpu.Registers[0], chip8.Cpu.IndexRegister,
+			chip8.Cpu.ProgramCounter, chip8.Cpu.StackPointer, chip8.SoundTimer)
+	}
+	if reply := gdbReply(t, r, func() { s.handle("p11") }); reply != "2002" {
+		t.Errorf("p11 replied %s", reply)
+	}
+	if reply := gdbReply(t, r, func() { s.handle("P11=0003") }); reply != "OK" || chip8.Cpu.ProgramCounter != 0x300 {
+		t.Errorf("P11=0003 replied %s and set PC %X", reply, chip8.Cpu.ProgramCounter)
+	}
+	// PC outside of the memory would fault the machine on the next cycle
+	tests := []string{
+		"P11=0010",
+		"P11=ffff",
+		"G" + strings.Repeat("00", 16) + "0000" + "0010" + "00" + "00" + "00",
+		"Gzz",
+		"P15=00",
+		"p15",
+		"P1",
+	}
+	for _, packet := range tests {
+		if reply := gdbReply(t, r, func() { s.handle(packet) }); reply != "E01" {
+			t.Errorf("%s replied %s", packet, reply)
+		}
+	}
+	if chip8.Cpu.ProgramCounter != 0x300 || chip8.Cpu.Registers[0] != 0xFF {
+		t.Errorf("Refused packets set PC %X and V0 %X", chip8.Cpu.ProgramCounter, chip8.Cpu.Registers[0])
+	}
+}
+
+func TestGDBMemory(t *testing.T) {
+	s, _, r := pipeGDBStub(t)
+	copy(chip8.Cpu.Memory[0x200:], []byte{0x6A, 0x05, 0x22, 0x08})
+	chip8.Cpu.Memory[0xFFF] = 0xAB
+	tests := []struct {
+		packet string
+		reply  string
+	}{
+		{"m200,4", "6a052208"},
+		{"mfff,1", "ab"},
+		{"m1000,0", ""},
+		{"M300,3:0102ff", "OK"},
+		{"m300,3", "0102ff"},
+		{"mfff,2", "E01"},
+		{"m1001,0", "E01"},
+		// address+length overflows
+		{"m7fffffffffffffff,1", "E01"},
+		{"m1,7fffffffffffffff", "E01"},
+		{"mffffffffffffffffff,1", "E01"},
+		{"M7fffffffffffffff,1:00", "E01"},
+		{"M300,2:01", "E01"},
+		{"M300,1:zz", "E01"},
+		{"mzz", "E01"},
+	}
+	for _, test := range tests {
+		if reply := gdbReply(t, r, func() { s.handle(test.packet) }); reply != test.reply {
+			t.Errorf("%s replied %q, want %q", test.packet, reply, test.reply)
+		}
+	}
+}
+
+func TestGDBBreakpoints(t *testing.T) {
+	s, _, r := pipeGDBStub(t)
+	// LD V0, 1 then ADD V0, 1 forever
+	copy(chip8.Cpu.Memory[0x200:], []byte{0x60, 0x01, 0x70, 0x01, 0x12, 0x02})
+	chip8.Cpu.ProgramCounter = 0x200
+	for _, packet := range []string{"Z0,202,2", "Z1,204,2", "z1,204,2"} {
+		if reply := gdbReply(t, r, func() { s.handle(packet) }); reply != "OK" {
+			t.Errorf("%s replied %s", packet, reply)
+		}
+	}
+	if reply := gdbReply(t, r, func() { s.handle("Z2,300,1") }); reply != "" {
+		t.Errorf("Watchpoints are not supported, Z2 replied %s", reply)
+	}
+	if len(s.Breakpoints) != 1 || !s.Breakpoints[0x202] {
+		t.Fatalf("Breakpoints are %v", s.Breakpoints)
+	}
+	// Run until the breakpoint stops the machine and reports the trap
+	s.handle("c")
+	stop := gdbReply(t, r, func() {
+		for s.beforeCycle() {
+			cycle()
+			s.afterCycle()
+		}
+	})
+	if stop != "S05" || chip8.Cpu.ProgramCounter != 0x202 || chip8.Cpu.Registers[0] != 1 {
+		t.Errorf("Stopped with %s at %X with V0=%d", stop, chip8.Cpu.ProgramCounter, chip8.Cpu.Registers[0])
+	}
+	// Continuing leaves the breakpoint and the loop hits it again
+	s.handle("c")
+	gdbReply(t, r, func() {
+		for s.beforeCycle() {
+			cycle()
+			s.afterCycle()
+		}
+	})
+	if chip8.Cpu.ProgramCounter != 0x202 || chip8.Cpu.Registers[0] != 2 {
+		t.Errorf("Stopped again at %X with V0=%d", chip8.Cpu.ProgramCounter, chip8.Cpu.Registers[0])
+	}
+	// A step from an address stops after one instruction
+	s.handle("s200")
+	stop = gdbReply(t, r, func() {
+		for s.beforeCycle() {
+			cycle()
+			s.afterCycle()
+		}
+	})
+	if stop != "S05" || chip8.Cpu.ProgramCounter != 0x202 || chip8.Cpu.Registers[0] != 1 {
+		t.Errorf("Stepped to %X with V0=%d", chip8.Cpu.ProgramCounter, chip8.Cpu.Registers[0])
+	}
+	// An address outside of the memory is refused and the machine stays stopped
+	for _, packet := range []string{"c1000", "sffff", "c10000", "cxyz"} {
+		if reply := gdbReply(t, r, func() { s.handle(packet) }); reply != "E01" || !s.paused || chip8.Cpu.ProgramCounter != 0x202 {
+			t.Errorf("%s replied %s and left PC %X paused %v", packet, reply, chip8.Cpu.ProgramCounter, s.paused)
+		}
+	}
+	if reply := gdbReply(t, r, func() { s.handle("?") }); reply != "S05" {
+		t.Errorf("? replied %s", reply)
+	}
+}
@@ -0,0 +1,276 @@
+package chip8
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The HUD shows the machine state in the margins around the display, F7 toggles it
+var IsHUDVisible bool
+
+// The image is redrawn at most this often, the window keeps showing the last one between them
+const HUD_REFRESH_RATE = 30
+
+// Instructions listed from PC
+const HUD_INSTRUCTIONS = 5
+
+// Size of the glyphs of the font and their advance in font pixels
+const (
+	HUD_GLYPH_WIDTH  = 5
+	HUD_GLYPH_HEIGHT = 7
+	HUD_CHAR_WIDTH   = 6
+	HUD_LINE_HEIGHT  = 9
+)
+
+// Space between the text and the edges of its margin in font pixels
+const HUD_MARGIN = 4
+
+// Built-in 5x7 font, rows from the top with the leftmost pixel in bit 4
+// Lowercase letters are drawn in uppercase except x of the hex numbers
+var hudFont = map[rune][HUD_GLYPH_HEIGHT]uint8{
+	' ':  {},
+	'0':  {0b01110, 0b10001, 0b10011, 0b10101, 0b11001, 0b10001, 0b01110},
+	'1':  {0b00100, 0b01100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'2':  {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b01000, 0b11111},
+	'3':  {0b11111, 0b00010, 0b00100, 0b00010, 0b00001, 0b10001, 0b01110},
+	'4':  {0b00010, 0b00110, 0b01010, 0b10010, 0b11111, 0b00010, 0b00010},
+	'5':  {0b11111, 0b10000, 0b11110, 0b00001, 0b00001, 0b10001, 0b01110},
+	'6':  {0b00110, 0b01000, 0b10000, 0b11110, 0b10001, 0b10001, 0b01110},
+	'7':  {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b01000, 0b01000},
+	'8':  {0b01110, 0b10001, 0b10001, 0b01110, 0b10001, 0b10001, 0b01110},
+	'9':  {0b01110, 0b10001, 0b10001, 0b01111, 0b00001, 0b00010, 0b01100},
+	'A':  {0b01110, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001},
+	'B':  {0b11110, 0b10001, 0b10001, 0b11110, 0b10001, 0b10001, 0b11110},
+	'C':  {0b01110, 0b10001, 0b10000, 0b10000, 0b10000, 0b10001, 0b01110},
+	'D':  {0b11100, 0b10010, 0b10001, 0b10001, 0b10001, 0b10010, 0b11100},
+	'E':  {0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b11111},
+	'F':  {0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b10000},
+	'G':  {0b01110, 0b10001, 0b10000, 0b10111, 0b10001, 0b10001, 0b01111},
+	'H':  {0b10001, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001},
+	'I':  {0b01110, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'J':  {0b00111, 0b00010, 0b00010, 0b00010, 0b00010, 0b10010, 0b01100},
+	'K':  {0b10001, 0b10010, 0b10100, 0b11000, 0b10100, 0b10010, 0b10001},
+	'L':  {0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b11111},
+	'M':  {0b10001, 0b11011, 0b10101, 0b10101, 0b10001, 0b10001, 0b10001},
+	'N':  {0b10001, 0b10001, 0b11001, 0b10101, 0b10011, 0b10001, 0b10001},
+	'O':  {0b01110, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110},
+	'P':  {0b11110, 0b10001, 0b10001, 0b11110, 0b10000, 0b10000, 0b10000},
+	'Q':  {0b01110, 0b10001, 0b10001, 0b10001, 0b10101, 0b10010, 0b01101},
+	'R':  {0b11110, 0b10001, 0b10001, 0b11110, 0b10100, 0b10010, 0b10001},
+	'S':  {0b01111, 0b10000, 0b10000, 0b01110, 0b00001, 0b00001, 0b11110},
+	'T':  {0b11111, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100},
+	'U':  {0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110},
+	'V':  {0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01010, 0b00100},
+	'W':  {0b10001, 0b10001, 0b10001, 0b10101, 0b10101, 0b10101, 0b01010},
+	'X':  {0b10001, 0b10001, 0b01010, 0b00100, 0b01010, 0b10001, 0b10001},
+	'Y':  {0b10001, 0b10001, 0b10001, 0b01010, 0b00100, 0b00100, 0b00100},
+	'Z':  {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b10000, 0b11111},
+	'x':  {0b00000, 0b00000, 0b10001, 0b01010, 0b00100, 0b01010, 0b10001},
+	',':  {0b00000, 0b00000, 0b00000, 0b00000, 0b01100, 0b00100, 0b01000},
+	'.':  {0b00000, 0b00000, 0b00000, 0b00000, 0b00000, 0b01100, 0b01100},
+	':':  {0b00000, 0b01100, 0b01100, 0b00000, 0b01100, 0b01100, 0b00000},
+	';':  {0b00000, 0b01100, 0b01100, 0b00000, 0b01100, 0b00100, 0b01000},
+	'\'': {0b01100, 0b00100, 0b01000, 0b00000, 0b00000, 0b00000, 0b00000},
+	'-':  {0b00000, 0b00000, 0b00000, 0b11111, 0b00000, 0b00000, 0b00000},
+	'+':  {0b00000, 0b00100, 0b00100, 0b11111, 0b00100, 0b00100, 0b00000},
+	'*':  {0b00000, 0b00100, 0b10101, 0b01110, 0b10101, 0b00100, 0b00000},
+	'/':  {0b00000, 0b00001, 0b00010, 0b00100, 0b01000, 0b10000, 0b00000},
+	'=':  {0b00000, 0b00000, 0b11111, 0b00000, 0b11111, 0b00000, 0b00000},
+	'<':  {0b00010, 0b00100, 0b01000, 0b10000, 0b01000, 0b00100, 0b00010},
+	'>':  {0b01000, 0b00100, 0b00010, 0b00001, 0b00010, 0b00100, 0b01000},
+	'[':  {0b01110, 0b01000, 0b01000, 0b01000, 0b01000, 0b01000, 0b01110},
+	']':  {0b01110, 0b00010, 0b00010, 0b00010, 0b00010, 0b00010, 0b01110},
+	'(':  {0b00010, 0b00100, 0b01000, 0b01000, 0b01000, 0b00100, 0b00010},
+	')':  {0b01000, 0b00100, 0b00010, 0b00010, 0b00010, 0b00100, 0b01000},
+	'_':  {0b00000, 0b00000, 0b00000, 0b00000, 0b00000, 0b00000, 0b11111},
+	'#':  {0b01010, 0b01010, 0b11111, 0b01010, 0b11111, 0b01010, 0b01010},
+	'%':  {0b11000, 0b11001, 0b00010, 0b00100, 0b01000, 0b10011, 0b00011},
+	'!':  {0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00000, 0b00100},
+	'?':  {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b00000, 0b00100},
+}
+
+// Glyph of the character, characters missing from the font are drawn as ?
+func hudGlyph(char rune) [HUD_GLYPH_HEIGHT]uint8 {
+	if char != 'x' {
+		char = unicode.ToUpper(char)
+	}
+	if glyph, isExists := hudFont[char]; isExists {
+		return glyph
+	}
+	return hudFont['?']
+}
+
+func ToggleHUD() bool {
+	IsHUDVisible = !IsHUDVisible
+	return IsHUDVisible
+}
+
+// Renders and instructions per second, measured over about a second of real time
+type hudRates struct {
+	start      time.Time
+	renders    int
+	startCycle uint64
+	FPS        float64
+	IPS        float64
+}
+
+var hudStats hudRates
+
+// Count a render of the frontend, every cycle executes one instruction
+func countHUDRender(now time.Time) {
+	hudStats.renders++
+	elapsed := now.Sub(hudStats.start)
+	if elapsed < time.Second {
+		return
+	}
+	if !hudStats.start.IsZero() && chip8.Frame >= hudStats.startCycle {
+		hudStats.FPS = float64(hudStats.renders) / elapsed.Seconds()
+		hudStats.IPS = float64(chip8.Frame-hudStats.startCycle) / elapsed.Seconds()
+	}
+	hudStats = hudRates{start: now, startCycle: chip8.Frame, FPS: hudStats.FPS, IPS: hudStats.IPS}
+}
+
+// V0-VF in two columns, then I, PC, SP and the timers
+func hudRegisterLines() []string {
+	var lines []string
+	registers := &chip8.Cpu.Registers
+	for i := 0; i < len(registers)/2; i++ {
+		lines = append(lines, fmt.Sprintf("V%X %02X  V%X %02X", i, uint8(registers[i]), i+8, uint8(registers[i+8])))
+	}
+	return append(lines,
+		"",
+		fmt.Sprintf("I  0x%03X", uint16(chip8.Cpu.IndexRegister)),
+		fmt.Sprintf("PC 0x%03X", uint16(chip8.Cpu.ProgramCounter)),
+		fmt.Sprintf("SP %d", chip8.Cpu.StackPointer),
+		fmt.Sprintf("DT %02X  ST %02X", uint8(chip8.DelayTimer), uint8(chip8.SoundTimer)),
+	)
+}
+
+// The return addresses on the stack from the bottom, then the keypad with the held keys
+func hudStackLines() []string {
+	lines := []string{"STACK"}
+	for i := 0; i < int(chip8.Cpu.StackPointer) && i < len(chip8.Cpu.ProgramStack); i++ {
+		lines = append(lines, fmt.Sprintf("0x%03X", uint16(chip8.Cpu.ProgramStack[i])))
+	}
+	if len(lines) == 1 {
+		lines = append(lines, "-")
+	}
+	lines = append(lines, "", "KEYS")
+	for _, row := range [][4]uint8{{0x1, 0x2, 0x3, 0xC}, {0x4, 0x5, 0x6, 0xD}, {0x7, 0x8, 0x9, 0xE}, {0xA, 0x0, 0xB, 0xF}} {
+		keys := make([]string, len(row))
+		for i, key := range row {
+			keys[i] = "."
+			if chip8.Keypad[key] {
+				keys[i] = fmt.Sprintf("%X", key)
+			}
+		}
+		lines = append(lines, strings.Join(keys, " "))
+	}
+	return lines
+}
+
+// The instruction at PC marked with => and the ones after it
+func hudInstructionLines() []string {
+	var lines []string
+	for i := 0; i < HUD_INSTRUCTIONS; i++ {
+		address := chip8.Cpu.ProgramCounter + ProgramCounter(2*i)
+		marker := "  "
+		if i == 0 {
+			marker = "=>"
+		}
+		lines = append(lines, fmt.Sprintf("%s 0x%03X %04X %s", marker, uint16(address), uint16(opcodeAt(address)), Mnemonic(address)))
+	}
+	return lines
+}
+
+func hudStatusLines() []string {
+	return []string{fmt.Sprintf("FPS %.0f  IPS %.0f", hudStats.FPS, hudStats.IPS)}
+}
+
+// Text placed in a margin, it is left out when the margin is too small for it
+type hudPanel struct {
+	lines  []string
+	bounds image.Rectangle
+}
+
+// Transparent image of the window size with the panels in the margins of the display
+// The image is reused when the size stays the same
+var hudImage *image.RGBA
+
+func HUDImage(width int, height int) *image.RGBA {
+	if hudImage == nil || hudImage.Rect.Dx() != width || hudImage.Rect.Dy() != height {
+		hudImage = image.NewRGBA(image.Rect(0, 0, width, height))
+	} else {
+		for i := range hudImage.Pix {
+			hudImage.Pix[i] = 0
+		}
+	}
+	x, y, w, h := displayLayout(int32(width), int32(height))
+	display := image.Rect(int(x), int(y), int(x+w), int(y+h)).Inset(-BORDER_PADDING)
+	panels := []hudPanel{
+		{hudRegisterLines(), image.Rect(0, display.Min.Y, display.Min.X, height)},
+		{hudStackLines(), image.Rect(display.Max.X, display.Min.Y, width, height)},
+		{hudStatusLines(), image.Rect(display.Min.X, 0, width, display.Min.Y)},
+		{hudInstructionLines(), image.Rect(display.Min.X, display.Max.Y, width, height)},
+	}
+	scale := hudScale(panels)
+	textColor := ActivePalette.Colors[1]
+	for _, panel := range panels {
+		drawHUDPanel(hudImage, panel, scale, textColor)
+	}
+	return hudImage
+}
+
+// The font grows with the margins, up to the size where the left panel still fits
+func hudScale(panels []hudPanel) int {
+	left := panels[0]
+	width, height := hudTextSize(left.lines)
+	scale := 1
+	for (scale+1)*(width+2*HUD_MARGIN) <= left.bounds.Dx() && (scale+1)*(height+2*HUD_MARGIN) <= left.bounds.Dy() {
+		scale++
+	}
+	return scale
+}
+
+// Size of the lines in font pixels
+func hudTextSize(lines []string) (int, int) {
+	width := 0
+	for _, line := range lines {
+		if n := len([]rune(line)); n*HUD_CHAR_WIDTH > width {
+			width = n * HUD_CHAR_WIDTH
+		}
+	}
+	return width, len(lines) * HUD_LINE_HEIGHT
+}
+
+func drawHUDPanel(img *image.RGBA, panel hudPanel, scale int, c color.RGBA) {
+	width, height := hudTextSize(panel.lines)
+	margin := HUD_MARGIN * scale
+	if (width*scale+2*margin) > panel.bounds.Dx() || (height*scale+2*margin) > panel.bounds.Dy() {
+		return
+	}
+	for row, line := range panel.lines {
+		for column, char := range []rune(line) {
+			drawHUDGlyph(img, hudGlyph(char), panel.bounds.Min.X+margin+column*HUD_CHAR_WIDTH*scale, panel.bounds.Min.Y+margin+row*HUD_LINE_HEIGHT*scale, scale, c)
+		}
+	}
+}
+
+func drawHUDGlyph(img *image.RGBA, glyph [HUD_GLYPH_HEIGHT]uint8, x int, y int, scale int, c color.RGBA) {
+	for row, bits := range glyph {
+		for column := 0; column < HUD_GLYPH_WIDTH; column++ {
+			if bits&(0x10>>column) == 0 {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetRGBA(x+column*scale+dx, y+row*scale+dy, c)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package chip8
+
+import (
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/mehmetumit/CHIP-8/chip8/disasm"
+)
+
+// Every character of the instructions has a glyph of its own
+func TestHUDFont(t *testing.T) {
+	missing := ""
+	for opcode := 0; opcode <= 0xFFFF; opcode++ {
+		text := disasm.Decode(uint16(START_ADDRESS), uint16(opcode), 0).Format(disasm.PLAIN, nil)
+		for _, char := range text {
+			if hudGlyph(char) == hudFont['?'] && char != '?' && !strings.ContainsRune(missing, char) {
+				missing += string(char)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		t.Errorf("No glyphs for %q", missing)
+	}
+}
+
+func TestHUDLines(t *testing.T) {
+	reset()
+	defer reset()
+	chip8.Cpu.Registers[0x3] = 0x4A
+	chip8.Cpu.Registers[0xB] = 0x07
+	chip8.Cpu.ProgramStack[0] = 0x2A4
+	chip8.Cpu.StackPointer = 1
+	chip8.Keypad[0x5] = true
+	chip8.Cpu.Memory[START_ADDRESS] = 0x00
+	chip8.Cpu.Memory[START_ADDRESS+1] = 0xE0
+	if line := hudRegisterLines()[3]; line != "V3 4A  VB 07" {
+		t.Errorf("Register line is %q", line)
+	}
+	stack := hudStackLines()
+	if stack[1] != "0x2A4" || stack[5] != ". 5 . ." {
+		t.Errorf("Stack and keys are %q", stack)
+	}
+	if line := hudInstructionLines()[0]; line != "=> 0x200 00E0 CLS" {
+		t.Errorf("Instruction line is %q", line)
+	}
+}
+
+// The text stays in the margins at the default window size and is left out without them
+func TestHUDImage(t *testing.T) {
+	reset()
+	defer reset()
+	SetPalette(DEFAULT_PALETTE)
+	width, height := WIDTH*12+2*DISPLAY_PADDING, HEIGHT*12+2*DISPLAY_PADDING
+	img := HUDImage(width, height)
+	x, y, w, h := displayLayout(int32(width), int32(height))
+	display := image.Rect(int(x), int(y), int(x+w), int(y+h)).Inset(-BORDER_PADDING)
+	margins := map[string]image.Rectangle{
+		"left":   image.Rect(0, 0, display.Min.X, height),
+		"right":  image.Rect(display.Max.X, 0, width, height),
+		"top":    image.Rect(display.Min.X, 0, display.Max.X, display.Min.Y),
+		"bottom": image.Rect(display.Min.X, display.Max.Y, display.Max.X, height),
+	}
+	for name, margin := range margins {
+		if litPixels(img, margin) == 0 {
+			t.Errorf("Nothing drawn in the %s margin", name)
+		}
+	}
+	if n := litPixels(img, display); n != 0 {
+		t.Errorf("%d pixels drawn over the display", n)
+	}
+	small := HUDImage(WIDTH*4, HEIGHT*4)
+	if n := litPixels(small, small.Rect); n != 0 {
+		t.Errorf("%d pixels drawn without margins", n)
+	}
+}
+
+func litPixels(img *image.RGBA, rect image.Rectangle) int {
+	n := 0
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if img.RGBAAt(x, y).A != 0 {
+				n++
+			}
+		}
+	}
+	return n
+}
@@ -1,3 +1,5 @@
+//go:build !nosdl && !js
+
 package chip8
 
 import (
@@ -5,49 +7,74 @@ import (
 	"log"
 )
 
-// 0x0 to 0xF -> store pressed or not
-type Keypad [16]bool
-
-var keyMap = map[uint8]uint8{
-	'1': 0x1,
-	'2': 0x2,
-	'3': 0x3,
-	'4': 0xC,
-	'q': 0x4,
-	'w': 0x5,
-	'e': 0x6,
-	'r': 0xD,
-	'a': 0x7,
-	's': 0x8,
-	'd': 0x9,
-	'f': 0xE,
-	'z': 0xA,
-	'x': 0x0,
-	'c': 0xB,
-	'v': 0xF,
-}
-
 func EventHandler(quitEvent func(), keyPad *Keypad) {
 	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
-		switch t:= event.(type) {
+		switch t := event.(type) {
 		case *sdl.QuitEvent:
 			log.Print("Quit Event Handled")
-			Window.Destroy()
-			ClouseAudio()
-			sdl.Quit()
 			quitEvent()
 			break
+		case *sdl.WindowEvent:
+			//Redraw into the new size right away
+			if t.Event == sdl.WINDOWEVENT_SIZE_CHANGED {
+				present(true)
+			}
 		case *sdl.KeyboardEvent:
-			handleKeys(t.Keysym.Sym, keyPad, t.State)
+			switch t.Keysym.Sym {
+			case sdl.K_F7, sdl.K_F8, sdl.K_F9, sdl.K_F10, sdl.K_F11, sdl.K_F12:
+				if t.State == sdl.PRESSED && t.Repeat == 0 {
+					handleHotkey(t.Keysym)
+				}
+			default:
+				handleKeys(t.Keysym.Sym, keyPad, t.State)
+			}
+		}
+	}
+}
+
+// Hotkeys act on the press and are not keys of the machine
+func handleHotkey(keysym sdl.Keysym) {
+	switch keysym.Sym {
+	case sdl.K_F7:
+		ToggleHUD()
+		present(true)
+	case sdl.K_F8:
+		log.Printf("Effects %s", NextEffects())
+		present(true)
+	case sdl.K_F9:
+		log.Printf("Palette %s", NextPalette())
+		present(true)
+	case sdl.K_F10:
+		isRecording := IsRecording()
+		path, err := ToggleRecording()
+		switch {
+		case err != nil:
+			log.Print("Recording failed! ", err)
+		case isRecording:
+			log.Printf("Recorded %s", path)
+		default:
+			log.Printf("Recording %s", path)
+		}
+	case sdl.K_F11:
+		ToggleFullscreen()
+	case sdl.K_F12:
+		//Shift takes the raw display instead of the window contents
+		isScaled := keysym.Mod&sdl.KMOD_SHIFT == 0
+		if path, err := Screenshot(isScaled); err != nil {
+			log.Print("Screenshot failed! ", err)
+		} else {
+			log.Printf("Screenshot %s", path)
 		}
 	}
 }
-func handleKeys(keyCode sdl.Keycode, keyPad *Keypad, state uint8){
-	if keyIndex, isExists := keyMap[uint8(keyCode)]; isExists{
-	log.Println("Key:", keyCode, "Index:",keyIndex)
-		if state == sdl.PRESSED{
+
+func handleKeys(keyCode sdl.Keycode, keyPad *Keypad, state uint8) {
+	if keyIndex, isExists := keyMap[uint8(keyCode)]; isExists {
+		if state == sdl.PRESSED {
+			tracef(TRACE_INPUT, TRACE_INFO, "key %X down", keyIndex)
 			keyPad[keyIndex] = true
-		}else if state == sdl.RELEASED{
+		} else if state == sdl.RELEASED {
+			tracef(TRACE_INPUT, TRACE_INFO, "key %X up", keyIndex)
 			keyPad[keyIndex] = false
 		}
 	}
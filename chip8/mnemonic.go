@@ -0,0 +1,9 @@
+package chip8
+
+import "github.com/mehmetumit/CHIP-8/chip8/disasm"
+
+// Return the assembly form of the instruction at the address
+func Mnemonic(address ProgramCounter) string {
+	in := disasm.Decode(uint16(address), uint16(opcodeAt(address)), uint16(opcodeAt(address+2)))
+	return in.Format(disasm.PLAIN, nil)
+}
@@ -0,0 +1,46 @@
+package chip8
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// A movie is the key input of a run, a json array of KeyInput like the input of a
+// conformance test, e.g. [{"frame": 300, "keys": "5"}, {"frame": 320, "keys": ""}]
+func ReadMovie(path string) ([]KeyInput, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var input []KeyInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return input, nil
+}
+
+// Load the rom into the machine cleared by the caller and run it for the cycles, pressing
+// the keys of the input at their frames, a frame is one cycle
+// The run stops early when the machine faults
+func runMovie(romPath string, cycles uint64, input []KeyInput) error {
+	if err := loadRom(romPath); err != nil {
+		return err
+	}
+	loadFonts()
+	input = append([]KeyInput(nil), input...)
+	sort.SliceStable(input, func(i, j int) bool {
+		return input[i].Frame < input[j].Frame
+	})
+	for frame := uint64(0); frame < cycles && chip8.Fault == nil; frame++ {
+		for len(input) > 0 && input[0].Frame == frame {
+			if err := pressKeys(input[0].Keys); err != nil {
+				return err
+			}
+			input = input[1:]
+		}
+		cycle()
+	}
+	return nil
+}
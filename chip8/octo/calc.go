@@ -0,0 +1,124 @@
+package octo
+
+import (
+	"fmt"
+	"math"
+)
+
+var calcBinary = map[string]func(a, b float64) float64{
+	"+":   func(a, b float64) float64 { return a + b },
+	"-":   func(a, b float64) float64 { return a - b },
+	"*":   func(a, b float64) float64 { return a * b },
+	"/":   func(a, b float64) float64 { return a / b },
+	"%":   func(a, b float64) float64 { return float64(int(a) % int(b)) },
+	"&":   func(a, b float64) float64 { return float64(int(a) & int(b)) },
+	"|":   func(a, b float64) float64 { return float64(int(a) | int(b)) },
+	"^":   func(a, b float64) float64 { return float64(int(a) ^ int(b)) },
+	"<<":  func(a, b float64) float64 { return float64(int(a) << uint(b)) },
+	">>":  func(a, b float64) float64 { return float64(int(a) >> uint(b)) },
+	"pow": math.Pow,
+	"min": math.Min,
+	"max": math.Max,
+	"<":   func(a, b float64) float64 { return boolToFloat(a < b) },
+	">":   func(a, b float64) float64 { return boolToFloat(a > b) },
+	"<=":  func(a, b float64) float64 { return boolToFloat(a <= b) },
+	">=":  func(a, b float64) float64 { return boolToFloat(a >= b) },
+	"==":  func(a, b float64) float64 { return boolToFloat(a == b) },
+	"!=":  func(a, b float64) float64 { return boolToFloat(a != b) },
+}
+
+var calcUnary = map[string]func(a float64) float64{
+	"-":     func(a float64) float64 { return -a },
+	"~":     func(a float64) float64 { return float64(^int(a)) },
+	"!":     func(a float64) float64 { return boolToFloat(a == 0) },
+	"abs":   math.Abs,
+	"sqrt":  math.Sqrt,
+	"floor": math.Floor,
+	"ceil":  math.Ceil,
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"log":   math.Log,
+	"exp":   math.Exp,
+	"sign": func(a float64) float64 {
+		if a < 0 {
+			return -1
+		}
+		return boolToFloat(a > 0)
+	},
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Evaluate the tokens of a calc block, like Octo there is no precedence and
+// operators are evaluated from right to left, e.g. { 2 * 3 + 1 } is 8
+func (c *compiler) calc(tokens []token) (float64, error) {
+	value, rest, err := c.calcExpression(tokens)
+	if err == nil && len(rest) > 0 {
+		err = fmt.Errorf("line %d: unexpected %q in calc", rest[0].line, rest[0].text)
+	}
+	return value, err
+}
+
+func (c *compiler) calcExpression(tokens []token) (float64, []token, error) {
+	left, rest, err := c.calcTerm(tokens)
+	if err != nil || len(rest) == 0 || rest[0].text == ")" {
+		return left, rest, err
+	}
+	t := rest[0]
+	operator, isExists := calcBinary[t.text]
+	if !isExists {
+		return 0, nil, fmt.Errorf("line %d: unknown calc operator %q", t.line, t.text)
+	}
+	right, rest, err := c.calcExpression(rest[1:])
+	if err != nil {
+		return 0, nil, err
+	}
+	// % works on integers, so a fraction below 1 is a zero divisor too
+	if (t.text == "/" && right == 0) || (t.text == "%" && int(right) == 0) {
+		return 0, nil, fmt.Errorf("line %d: division by zero in calc", t.line)
+	}
+	return operator(left, right), rest, nil
+}
+
+func (c *compiler) calcTerm(tokens []token) (float64, []token, error) {
+	if len(tokens) == 0 {
+		return 0, nil, fmt.Errorf("unexpected end of calc")
+	}
+	t := tokens[0]
+	if operator, isExists := calcUnary[t.text]; isExists {
+		value, rest, err := c.calcTerm(tokens[1:])
+		return operator(value), rest, err
+	}
+	switch t.text {
+	case "(":
+		value, rest, err := c.calcExpression(tokens[1:])
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(rest) == 0 || rest[0].text != ")" {
+			return 0, nil, fmt.Errorf("line %d: missing ) in calc", t.line)
+		}
+		return value, rest[1:], nil
+	case "HERE":
+		return float64(c.here), tokens[1:], nil
+	case "PI":
+		return math.Pi, tokens[1:], nil
+	case "E":
+		return math.E, tokens[1:], nil
+	}
+	if num, isNumber := parseNumber(t.text); isNumber {
+		return float64(num), tokens[1:], nil
+	}
+	if value, isExists := c.constants[t.text]; isExists {
+		return value, tokens[1:], nil
+	}
+	if address, isExists := c.labels[t.text]; isExists {
+		return float64(address), tokens[1:], nil
+	}
+	return 0, nil, fmt.Errorf("line %d: undefined name %q in calc", t.line, t.text)
+}
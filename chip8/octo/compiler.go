@@ -0,0 +1,764 @@
+// Package octo compiles the Octo high level CHIP-8 language into rom images
+//
+// Supported: labels and :alias, :const, :calc, :macro, :next, :org, :byte,
+// :unpack, :call, if … then, if … begin … else … end, loop … while … again,
+// every CHIP-8 statement and the SCHIP/XO-CHIP ones including i := long
+package octo
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const MAX_MACRO_DEPTH = 64
+
+type token struct {
+	text string
+	line int
+}
+
+type fixupKind uint8
+
+const (
+	// Low 12 bits of an instruction
+	FIXUP_ADDRESS fixupKind = iota
+	// Low nibble of a byte with bits 8-11 of the address
+	FIXUP_HIGH_NIBBLE
+	// Byte with bits 8-15 of the address
+	FIXUP_HIGH_BYTE
+	// Byte with the low 8 bits of the address
+	FIXUP_LOW_BYTE
+)
+
+// Label reference resolved after the whole program is compiled
+type fixup struct {
+	address int
+	kind    fixupKind
+	name    token
+}
+
+type macro struct {
+	params []string
+	body   []token
+}
+
+type loopState struct {
+	start int
+	// Jumps of while statements to the end of the loop
+	exits []int
+}
+
+type compiler struct {
+	tokens    []token
+	pos       int
+	origin    int
+	here      int
+	rom       []byte
+	labels    map[string]int
+	constants map[string]float64
+	aliases   map[string]uint8
+	macros    map[string]*macro
+	fixups    []fixup
+	loops     []loopState
+	// Addresses of the jumps of begin and else blocks
+	branches   []int
+	nextLabel  string
+	expansions int
+}
+
+func CompileFile(path string, origin uint16) ([]byte, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rom, err := Compile(string(source), origin)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return rom, nil
+}
+
+// Compile the source into a rom image loaded at the origin
+// Execution starts at the main label, a jump is added at the origin if main is not there
+func Compile(source string, origin uint16) ([]byte, error) {
+	rom, c, err := compile(source, origin, false)
+	if err != nil {
+		return nil, err
+	}
+	if main, isExists := c.labels["main"]; isExists && main != int(origin) {
+		rom, _, err = compile(source, origin, true)
+	}
+	return rom, err
+}
+
+func compile(source string, origin uint16, jumpToMain bool) ([]byte, *compiler, error) {
+	c := &compiler{
+		tokens:    tokenize(source),
+		origin:    int(origin),
+		here:      int(origin),
+		labels:    map[string]int{},
+		constants: map[string]float64{},
+		aliases:   map[string]uint8{},
+		macros:    map[string]*macro{},
+	}
+	if jumpToMain {
+		c.fixups = append(c.fixups, fixup{address: c.here, kind: FIXUP_ADDRESS, name: token{text: "main"}})
+		c.inst(0x10, 0x00)
+	}
+	for c.pos < len(c.tokens) {
+		if err := c.statement(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(c.loops) > 0 {
+		return nil, nil, fmt.Errorf("loop without again")
+	}
+	if len(c.branches) > 0 {
+		return nil, nil, fmt.Errorf("begin without end")
+	}
+	if c.nextLabel != "" {
+		return nil, nil, fmt.Errorf(":next %s is not followed by an instruction", c.nextLabel)
+	}
+	if err := c.resolveFixups(); err != nil {
+		return nil, nil, err
+	}
+	return c.rom, c, nil
+}
+
+func tokenize(source string) []token {
+	var tokens []token
+	for i, line := range strings.Split(source, "\n") {
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		for _, text := range strings.Fields(line) {
+			tokens = append(tokens, token{text: text, line: i + 1})
+		}
+	}
+	return tokens
+}
+
+// Numbers can be decimal, 0x hex or 0b binary and negative
+func parseNumber(text string) (int, bool) {
+	num, err := strconv.ParseInt(text, 0, 32)
+	return int(num), err == nil
+}
+
+func (c *compiler) errorf(t token, format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", t.line, fmt.Sprintf(format, args...))
+}
+
+func (c *compiler) peek() token {
+	if c.pos < len(c.tokens) {
+		return c.tokens[c.pos]
+	}
+	return token{}
+}
+
+func (c *compiler) next() (token, error) {
+	if c.pos >= len(c.tokens) {
+		last := token{}
+		if len(c.tokens) > 0 {
+			last = c.tokens[len(c.tokens)-1]
+		}
+		return token{}, c.errorf(last, "unexpected end of program")
+	}
+	c.pos++
+	return c.tokens[c.pos-1], nil
+}
+
+func (c *compiler) expect(text string) error {
+	t, err := c.next()
+	if err == nil && t.text != text {
+		err = c.errorf(t, "expected %q, found %q", text, t.text)
+	}
+	return err
+}
+
+func (c *compiler) emit(b byte) {
+	for len(c.rom) <= c.here-c.origin {
+		c.rom = append(c.rom, 0)
+	}
+	c.rom[c.here-c.origin] = b
+	c.here++
+}
+
+func (c *compiler) inst(high byte, low byte) {
+	if c.nextLabel != "" {
+		c.labels[c.nextLabel] = c.here + 1
+		c.nextLabel = ""
+	}
+	c.emit(high)
+	c.emit(low)
+}
+
+func (c *compiler) defineLabel(name token) error {
+	if _, isExists := c.labels[name.text]; isExists {
+		return c.errorf(name, "label %s is already defined", name.text)
+	}
+	if c.here >= 0x10000 {
+		return c.errorf(name, "program does not fit into memory")
+	}
+	c.labels[name.text] = c.here
+	return nil
+}
+
+func (c *compiler) isRegister(t token) bool {
+	_, err := c.register(t)
+	return err == nil
+}
+
+func (c *compiler) register(t token) (uint8, error) {
+	if reg, isExists := c.aliases[t.text]; isExists {
+		return reg, nil
+	}
+	text := strings.ToLower(t.text)
+	if len(text) == 2 && text[0] == 'v' {
+		if reg, err := strconv.ParseUint(text[1:], 16, 4); err == nil {
+			return uint8(reg), nil
+		}
+	}
+	return 0, c.errorf(t, "expected a register, found %q", t.text)
+}
+
+func (c *compiler) nextRegister() (uint8, error) {
+	t, err := c.next()
+	if err != nil {
+		return 0, err
+	}
+	return c.register(t)
+}
+
+// Numbers, constants and calc blocks
+func (c *compiler) value(t token) (int, error) {
+	if num, isNumber := parseNumber(t.text); isNumber {
+		return num, nil
+	}
+	if value, isExists := c.constants[t.text]; isExists {
+		return int(value), nil
+	}
+	if t.text == "{" {
+		value, err := c.calcBlock()
+		return int(value), err
+	}
+	return 0, c.errorf(t, "undefined name %q", t.text)
+}
+
+func (c *compiler) nextValue(max int) (int, error) {
+	t, err := c.next()
+	if err != nil {
+		return 0, err
+	}
+	value, err := c.value(t)
+	if err != nil {
+		return 0, err
+	}
+	// Negative bytes are written in two's complement
+	if max == 0xFF && value < 0 && value >= -0x80 {
+		value &= 0xFF
+	}
+	if value < 0 || value > max {
+		return 0, c.errorf(t, "value %d is out of range 0-%d", value, max)
+	}
+	return value, nil
+}
+
+// Address operand, labels defined later are patched by the fixups whose
+// addresses are offsets from the current address
+func (c *compiler) nextAddress(fixups ...fixup) (int, error) {
+	t, err := c.next()
+	if err != nil {
+		return 0, err
+	}
+	if address, isExists := c.labels[t.text]; isExists {
+		return address, nil
+	}
+	if _, isExists := c.constants[t.text]; isExists || t.text == "{" {
+		return c.value(t)
+	}
+	if num, isNumber := parseNumber(t.text); isNumber {
+		return num, nil
+	}
+	for _, f := range fixups {
+		c.fixups = append(c.fixups, fixup{address: c.here + f.address, kind: f.kind, name: t})
+	}
+	return 0, nil
+}
+
+func (c *compiler) calcBlock() (float64, error) {
+	var tokens []token
+	for {
+		t, err := c.next()
+		if err != nil {
+			return 0, err
+		}
+		if t.text == "}" {
+			break
+		}
+		tokens = append(tokens, t)
+	}
+	return c.calc(tokens)
+}
+
+func (c *compiler) resolveFixups() error {
+	for _, f := range c.fixups {
+		address, isExists := c.labels[f.name.text]
+		if !isExists {
+			return c.errorf(f.name, "undefined name %q", f.name.text)
+		}
+		offset := f.address - c.origin
+		switch f.kind {
+		case FIXUP_ADDRESS:
+			if address > 0xFFF {
+				return c.errorf(f.name, "address 0x%X of %s needs i := long", address, f.name.text)
+			}
+			c.rom[offset] |= byte(address >> 8)
+			c.rom[offset+1] = byte(address)
+		case FIXUP_HIGH_NIBBLE:
+			c.rom[offset] |= byte(address>>8) & 0x0F
+		case FIXUP_HIGH_BYTE:
+			c.rom[offset] = byte(address >> 8)
+		case FIXUP_LOW_BYTE:
+			c.rom[offset] = byte(address)
+		}
+	}
+	return nil
+}
+
+func (c *compiler) statement() error {
+	t, err := c.next()
+	if err != nil {
+		return err
+	}
+	if t.text[0] == ':' && t.text != ":=" {
+		return c.directive(t)
+	}
+	if m, isExists := c.macros[t.text]; isExists {
+		return c.expand(t, m)
+	}
+	if c.isRegister(t) {
+		return c.registerStatement(t)
+	}
+	switch t.text {
+	case "return", ";":
+		c.inst(0x00, 0xEE)
+	case "clear":
+		c.inst(0x00, 0xE0)
+	case "hires":
+		c.inst(0x00, 0xFF)
+	case "lores":
+		c.inst(0x00, 0xFE)
+	case "exit":
+		c.inst(0x00, 0xFD)
+	case "scroll-left":
+		c.inst(0x00, 0xFC)
+	case "scroll-right":
+		c.inst(0x00, 0xFB)
+	case "audio":
+		c.inst(0xF0, 0x02)
+	case "scroll-down", "scroll-up", "plane":
+		n, err := c.nextValue(0xF)
+		if err != nil {
+			return err
+		}
+		switch t.text {
+		case "scroll-down":
+			c.inst(0x00, 0xC0|byte(n))
+		case "scroll-up":
+			c.inst(0x00, 0xD0|byte(n))
+		default:
+			c.inst(0xF0|byte(n), 0x01)
+		}
+	case "bcd", "saveflags", "loadflags":
+		x, err := c.nextRegister()
+		if err != nil {
+			return err
+		}
+		low := map[string]byte{"bcd": 0x33, "saveflags": 0x75, "loadflags": 0x85}[t.text]
+		c.inst(0xF0|x, low)
+	case "save", "load":
+		return c.saveLoad(t)
+	case "sprite":
+		x, err := c.nextRegister()
+		if err != nil {
+			return err
+		}
+		y, err := c.nextRegister()
+		if err != nil {
+			return err
+		}
+		n, err := c.nextValue(0xF)
+		if err != nil {
+			return err
+		}
+		c.inst(0xD0|x, y<<4|byte(n))
+	case "jump", "jump0", "native":
+		high := map[string]byte{"jump": 0x10, "jump0": 0xB0, "native": 0x00}[t.text]
+		return c.addressInstruction(high)
+	case "delay", "buzzer", "pitch":
+		if err := c.expect(":="); err != nil {
+			return err
+		}
+		x, err := c.nextRegister()
+		if err != nil {
+			return err
+		}
+		low := map[string]byte{"delay": 0x15, "buzzer": 0x18, "pitch": 0x3A}[t.text]
+		c.inst(0xF0|x, low)
+	case "i":
+		return c.indexStatement()
+	case "if":
+		return c.ifStatement()
+	case "else":
+		return c.elseStatement(t)
+	case "end":
+		if len(c.branches) == 0 {
+			return c.errorf(t, "end without begin")
+		}
+		c.patchJump(c.branches[len(c.branches)-1], c.here)
+		c.branches = c.branches[:len(c.branches)-1]
+	case "loop":
+		c.loops = append(c.loops, loopState{start: c.here})
+	case "while":
+		return c.whileStatement(t)
+	case "again":
+		return c.againStatement(t)
+	default:
+		// Numbers and constants are data, other names are subroutine calls
+		_, isNumber := parseNumber(t.text)
+		if _, isExists := c.constants[t.text]; isExists || isNumber || t.text == "{" {
+			c.pos--
+			value, err := c.nextValue(0xFF)
+			if err != nil {
+				return err
+			}
+			c.emit(byte(value))
+			return nil
+		}
+		c.pos--
+		return c.addressInstruction(0x20)
+	}
+	return nil
+}
+
+func (c *compiler) addressInstruction(high byte) error {
+	address, err := c.nextAddress(fixup{kind: FIXUP_ADDRESS})
+	if err != nil {
+		return err
+	}
+	if address > 0xFFF {
+		return c.errorf(c.tokens[c.pos-1], "address 0x%X is out of range", address)
+	}
+	c.inst(high|byte(address>>8), byte(address))
+	return nil
+}
+
+// Patch the jump at the address to the target
+func (c *compiler) patchJump(address int, target int) {
+	offset := address - c.origin
+	c.rom[offset] = 0x10 | byte(target>>8)&0x0F
+	c.rom[offset+1] = byte(target)
+}
+
+func (c *compiler) directive(t token) error {
+	switch t.text {
+	case ":":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		return c.defineLabel(name)
+	case ":alias":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		reg, err := c.nextRegister()
+		if err != nil {
+			return err
+		}
+		c.aliases[name.text] = reg
+	case ":const":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		valueToken, err := c.next()
+		if err != nil {
+			return err
+		}
+		if address, isExists := c.labels[valueToken.text]; isExists {
+			c.constants[name.text] = float64(address)
+			return nil
+		}
+		value, err := c.value(valueToken)
+		if err != nil {
+			return err
+		}
+		c.constants[name.text] = float64(value)
+	case ":calc":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		if err = c.expect("{"); err != nil {
+			return err
+		}
+		value, err := c.calcBlock()
+		if err != nil {
+			return err
+		}
+		c.constants[name.text] = value
+	case ":macro":
+		return c.macroDefinition()
+	case ":next":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		if _, isExists := c.labels[name.text]; isExists {
+			return c.errorf(name, "label %s is already defined", name.text)
+		}
+		c.nextLabel = name.text
+	case ":org":
+		address, err := c.nextValue(0xFFFF)
+		if err != nil {
+			return err
+		}
+		if address < c.origin {
+			return c.errorf(t, ":org 0x%X is below the origin 0x%X", address, c.origin)
+		}
+		c.here = address
+	case ":byte":
+		value, err := c.nextValue(0xFF)
+		if err != nil {
+			return err
+		}
+		c.emit(byte(value))
+	case ":unpack":
+		return c.unpack()
+	case ":call":
+		return c.addressInstruction(0x20)
+	case ":breakpoint":
+		_, err := c.next()
+		return err
+	case ":monitor":
+		if _, err := c.next(); err != nil {
+			return err
+		}
+		_, err := c.next()
+		return err
+	default:
+		return c.errorf(t, "unknown directive %s", t.text)
+	}
+	return nil
+}
+
+// :macro name params { body }
+func (c *compiler) macroDefinition() error {
+	name, err := c.next()
+	if err != nil {
+		return err
+	}
+	m := &macro{}
+	for {
+		t, err := c.next()
+		if err != nil {
+			return err
+		}
+		if t.text == "{" {
+			break
+		}
+		m.params = append(m.params, t.text)
+	}
+	for depth := 1; ; {
+		t, err := c.next()
+		if err != nil {
+			return err
+		}
+		if t.text == "{" {
+			depth++
+		} else if t.text == "}" {
+			depth--
+			if depth == 0 {
+				break
+			}
+		}
+		m.body = append(m.body, t)
+	}
+	c.macros[name.text] = m
+	return nil
+}
+
+// Replace the invocation with the body, parameters are substituted by token
+func (c *compiler) expand(name token, m *macro) error {
+	c.expansions++
+	if c.expansions > 0x10000 {
+		return c.errorf(name, "too many macro expansions, is %s recursive?", name.text)
+	}
+	args := map[string]token{}
+	for _, param := range m.params {
+		arg, err := c.next()
+		if err != nil {
+			return err
+		}
+		args[param] = arg
+	}
+	body := make([]token, len(m.body))
+	for i, t := range m.body {
+		if arg, isExists := args[t.text]; isExists {
+			body[i] = arg
+		} else {
+			body[i] = token{text: t.text, line: name.line}
+		}
+	}
+	// The expanded tokens are dropped, recursive expansions would copy them every time
+	c.tokens = append(body, c.tokens[c.pos:]...)
+	c.pos = 0
+	return nil
+}
+
+// :unpack nibble label loads v0 with the nibble and the high bits of the address
+// and v1 with the low byte, :unpack long label loads v0 with the high byte
+func (c *compiler) unpack() error {
+	t, err := c.next()
+	if err != nil {
+		return err
+	}
+	high, mask, kind := 0, 0x0F, FIXUP_HIGH_NIBBLE
+	if t.text == "long" {
+		mask, kind = 0xFF, FIXUP_HIGH_BYTE
+	} else if high, err = c.value(t); err != nil {
+		return err
+	}
+	address, err := c.nextAddress(fixup{address: 1, kind: kind}, fixup{address: 3, kind: FIXUP_LOW_BYTE})
+	if err != nil {
+		return err
+	}
+	c.inst(0x60, byte(high<<4)|byte(address>>8)&byte(mask))
+	c.inst(0x61, byte(address))
+	return nil
+}
+
+func (c *compiler) saveLoad(t token) error {
+	x, err := c.nextRegister()
+	if err != nil {
+		return err
+	}
+	low := byte(0x55)
+	if t.text == "load" {
+		low = 0x65
+	}
+	// XO-CHIP save vx - vy and load vx - vy
+	if c.peek().text == "-" {
+		c.pos++
+		y, err := c.nextRegister()
+		if err != nil {
+			return err
+		}
+		kind := byte(0x2)
+		if t.text == "load" {
+			kind = 0x3
+		}
+		c.inst(0x50|x, y<<4|kind)
+		return nil
+	}
+	c.inst(0xF0|x, low)
+	return nil
+}
+
+func (c *compiler) indexStatement() error {
+	operator, err := c.next()
+	if err != nil {
+		return err
+	}
+	switch operator.text {
+	case "+=":
+		x, err := c.nextRegister()
+		if err != nil {
+			return err
+		}
+		c.inst(0xF0|x, 0x1E)
+	case ":=":
+		switch c.peek().text {
+		case "hex", "bighex":
+			kind, _ := c.next()
+			x, err := c.nextRegister()
+			if err != nil {
+				return err
+			}
+			low := byte(0x29)
+			if kind.text == "bighex" {
+				low = 0x30
+			}
+			c.inst(0xF0|x, low)
+		case "long":
+			c.pos++
+			c.inst(0xF0, 0x00)
+			address, err := c.nextAddress(fixup{kind: FIXUP_HIGH_BYTE}, fixup{address: 1, kind: FIXUP_LOW_BYTE})
+			if err != nil {
+				return err
+			}
+			c.emit(byte(address >> 8))
+			c.emit(byte(address))
+		default:
+			return c.addressInstruction(0xA0)
+		}
+	default:
+		return c.errorf(operator, "unknown i operator %q", operator.text)
+	}
+	return nil
+}
+
+func (c *compiler) registerStatement(t token) error {
+	x, _ := c.register(t)
+	operator, err := c.next()
+	if err != nil {
+		return err
+	}
+	source, err := c.next()
+	if err != nil {
+		return err
+	}
+	if c.isRegister(source) {
+		y, _ := c.register(source)
+		low := map[string]byte{":=": 0x0, "|=": 0x1, "&=": 0x2, "^=": 0x3, "+=": 0x4, "-=": 0x5, ">>=": 0x6, "=-": 0x7, "<<=": 0xE}
+		n, isExists := low[operator.text]
+		if !isExists {
+			return c.errorf(operator, "unknown register operator %q", operator.text)
+		}
+		c.inst(0x80|x, y<<4|n)
+		return nil
+	}
+	switch {
+	case operator.text == ":=" && source.text == "random":
+		value, err := c.nextValue(0xFF)
+		if err != nil {
+			return err
+		}
+		c.inst(0xC0|x, byte(value))
+	case operator.text == ":=" && source.text == "key":
+		c.inst(0xF0|x, 0x0A)
+	case operator.text == ":=" && source.text == "delay":
+		c.inst(0xF0|x, 0x07)
+	case operator.text == ":=", operator.text == "+=", operator.text == "-=":
+		c.pos--
+		value, err := c.nextValue(0xFF)
+		if err != nil {
+			return err
+		}
+		switch operator.text {
+		case ":=":
+			c.inst(0x60|x, byte(value))
+		case "+=":
+			c.inst(0x70|x, byte(value))
+		default:
+			c.inst(0x70|x, byte(-value))
+		}
+	default:
+		return c.errorf(operator, "%s needs a register operand", operator.text)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package octo
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mehmetumit/CHIP-8/chip8/disasm"
+)
+
+// The Octo output of the disassembler compiles back to the same rom
+func TestDisassemblyRoundTrip(t *testing.T) {
+	paths, err := filepath.Glob("../../roms/*.ch8")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("No roms: %v", err)
+	}
+	for _, path := range paths {
+		rom, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var source strings.Builder
+		if err = disasm.Trace(rom, 0x200).Write(&source, disasm.OCTO); err != nil {
+			t.Fatal(err)
+		}
+		compiled, err := Compile(source.String(), 0x200)
+		if err != nil {
+			t.Errorf("%s: %v", filepath.Base(path), err)
+			continue
+		}
+		if !bytes.Equal(compiled, rom) {
+			t.Errorf("%s: compiled %d bytes differ from the %d of the rom", filepath.Base(path), len(compiled), len(rom))
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		rom    []byte
+	}{
+		{"statements", "clear v3 := 0x4A v3 += v5 i := 0x300 sprite v0 v1 5 return",
+			[]byte{0x00, 0xE0, 0x63, 0x4A, 0x83, 0x54, 0xA3, 0x00, 0xD0, 0x15, 0x00, 0xEE}},
+		{"negative bytes", "v0 -= 1 v1 := -2",
+			[]byte{0x70, 0xFF, 0x61, 0xFE}},
+		{"labels", ": main jump end 0xAA : end jump main",
+			[]byte{0x12, 0x03, 0xAA, 0x12, 0x00}},
+		{"jump to main", ": data 0x01 0x02 : main i := data",
+			[]byte{0x12, 0x04, 0x01, 0x02, 0xA2, 0x02}},
+		{"calls", ": main draw ; : draw clear ;",
+			[]byte{0x22, 0x04, 0x00, 0xEE, 0x00, 0xE0, 0x00, 0xEE}},
+		{"alias", ":alias x v7 x := 1 x += x",
+			[]byte{0x67, 0x01, 0x87, 0x74}},
+		{"const", ":const SPEED 3 :const HERE 0x345 v0 := SPEED i := HERE",
+			[]byte{0x60, 0x03, 0xA3, 0x45}},
+		{"const label", ": main :const START main jump START",
+			[]byte{0x12, 0x00}},
+		{"calc without precedence", ":calc X { 2 * 3 + 1 } v0 := X",
+			[]byte{0x60, 0x08}},
+		{"calc operators", ":const W 64 :calc X { W >> 1 } :calc Y { 3 max 7 } v0 := X v1 := Y v2 := { W - 1 }",
+			[]byte{0x60, 0x20, 0x61, 0x07, 0x62, 0x3F}},
+		{"macro", ":macro add3 reg { reg += 3 } add3 v1 add3 v2",
+			[]byte{0x71, 0x03, 0x72, 0x03}},
+		{"nested macro", ":macro twice op { op op } :macro clr { clear } twice clr",
+			[]byte{0x00, 0xE0, 0x00, 0xE0}},
+		{"if then", "if v0 == 5 then v1 := 1 if v0 != v2 then clear",
+			[]byte{0x40, 0x05, 0x61, 0x01, 0x50, 0x20, 0x00, 0xE0}},
+		{"loop", "loop v0 += 1 while v0 != 9 again",
+			[]byte{0x70, 0x01, 0x40, 0x09, 0x12, 0x08, 0x12, 0x00}},
+		{"next", ": main :next count v0 := 0 jump main",
+			[]byte{0x60, 0x00, 0x12, 0x00}},
+		{"org and byte", "clear :org 0x206 :byte 0xAB",
+			[]byte{0x00, 0xE0, 0x00, 0x00, 0x00, 0x00, 0xAB}},
+		{"unpack", ": main :unpack 0xA data : data",
+			[]byte{0x60, 0xA2, 0x61, 0x04}},
+		{"long", "i := long 0x1234",
+			[]byte{0xF0, 0x00, 0x12, 0x34}},
+		{"comments", "clear # v0 := 1\nreturn",
+			[]byte{0x00, 0xE0, 0x00, 0xEE}},
+	}
+	for _, test := range tests {
+		rom, err := Compile(test.source, 0x200)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(rom, test.rom) {
+			t.Errorf("%s: compiled % X, want % X", test.name, rom, test.rom)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		err    string
+	}{
+		{"undefined label", "clear\njump nowhere", "line 2: undefined name \"nowhere\""},
+		{"duplicate label", ": a\n: a", "line 2: label a is already defined"},
+		{"byte range", "v0 := 256", "line 1: value 256 is out of range 0-255"},
+		{"register", "sprite v0 vx 1", "line 1: expected a register, found \"vx\""},
+		{"operator", "v0 *= v1", "line 1: unknown register operator \"*=\""},
+		{"directive", ":frobnicate", "line 1: unknown directive :frobnicate"},
+		{"org below origin", ":org 0x100", "line 1: :org 0x100 is below the origin 0x200"},
+		{"end of program", "v0 :=", "line 1: unexpected end of program"},
+		{"open loop", "loop clear", "loop without again"},
+		{"open begin", "if v0 == 1 begin clear", "begin without end"},
+		{"end", "end", "line 1: end without begin"},
+		{"recursive macro", ":macro m { m } m", "too many macro expansions, is m recursive?"},
+		{"calc division by zero", ":calc X { 5 /\n ( 2 - 2 ) }", "line 1: division by zero in calc"},
+		{"calc modulo by zero", ":const Z 0\n:calc X { 5\n% Z }", "line 3: division by zero in calc"},
+		{"calc modulo by a fraction", ":calc X { 5 % ( 1 / 2 ) }", "line 1: division by zero in calc"},
+		{"long address", ": main jump far\n:org 0x1000 : far", "line 1: address 0x1000 of far needs i := long"},
+	}
+	for _, test := range tests {
+		_, err := Compile(test.source, 0x200)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error is %v, want %q", test.name, err, test.err)
+		}
+	}
+}
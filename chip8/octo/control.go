@@ -0,0 +1,179 @@
+package octo
+
+// Operand of a condition, a register or a constant
+type conditionOperand struct {
+	register   uint8
+	isRegister bool
+	value      int
+}
+
+type condition struct {
+	operator    token
+	left, right conditionOperand
+}
+
+var negatedOperators = map[string]string{
+	"==": "!=", "!=": "==", "key": "-key", "-key": "key",
+	"<": ">=", ">=": "<", ">": "<=", "<=": ">",
+}
+
+func (c *compiler) conditionOperand() (conditionOperand, error) {
+	t, err := c.next()
+	if err != nil {
+		return conditionOperand{}, err
+	}
+	if reg, err := c.register(t); err == nil {
+		return conditionOperand{register: reg, isRegister: true}, nil
+	}
+	c.pos--
+	value, err := c.nextValue(0xFF)
+	return conditionOperand{value: value}, err
+}
+
+// vx == vy, vx != n, vx key, vx -key, vx < vy, vx >= n …
+func (c *compiler) parseCondition() (condition, error) {
+	var cond condition
+	var err error
+	if cond.left, err = c.conditionOperand(); err != nil {
+		return cond, err
+	}
+	if !cond.left.isRegister {
+		return cond, c.errorf(c.tokens[c.pos-1], "condition must start with a register")
+	}
+	if cond.operator, err = c.next(); err != nil {
+		return cond, err
+	}
+	if _, isExists := negatedOperators[cond.operator.text]; !isExists {
+		return cond, c.errorf(cond.operator, "unknown condition operator %q", cond.operator.text)
+	}
+	if cond.operator.text != "key" && cond.operator.text != "-key" {
+		cond.right, err = c.conditionOperand()
+	}
+	return cond, err
+}
+
+// Emit the instructions which skip the next instruction when the condition is false
+func (c *compiler) skipUnless(cond condition, negate bool) error {
+	operator := cond.operator.text
+	if negate {
+		operator = negatedOperators[operator]
+	}
+	x, right := cond.left.register, cond.right
+	switch operator {
+	case "==", "!=":
+		switch {
+		case right.isRegister && operator == "==":
+			c.inst(0x90|x, right.register<<4)
+		case right.isRegister:
+			c.inst(0x50|x, right.register<<4)
+		case operator == "==":
+			c.inst(0x40|x, byte(right.value))
+		default:
+			c.inst(0x30|x, byte(right.value))
+		}
+		return nil
+	case "key":
+		c.inst(0xE0|x, 0xA1)
+		return nil
+	case "-key":
+		c.inst(0xE0|x, 0x9E)
+		return nil
+	}
+	// vf := a - b sets vf to 1 when a >= b, a > b is b < a and a <= b is b >= a
+	a, b := cond.left, right
+	if operator == ">" || operator == "<=" {
+		a, b = b, a
+	}
+	switch {
+	case a.isRegister && b.isRegister:
+		c.inst(0x8F, a.register<<4)
+		c.inst(0x8F, b.register<<4|0x5)
+	case a.isRegister:
+		c.inst(0x6F, byte(b.value))
+		c.inst(0x8F, a.register<<4|0x7)
+	default:
+		c.inst(0x6F, byte(a.value))
+		c.inst(0x8F, b.register<<4|0x5)
+	}
+	if operator == "<" || operator == ">" {
+		c.inst(0x3F, 0x01)
+	} else {
+		c.inst(0x3F, 0x00)
+	}
+	return nil
+}
+
+// Emit a jump which is taken when the condition is false and return its address
+func (c *compiler) jumpUnless(cond condition) (int, error) {
+	if err := c.skipUnless(cond, true); err != nil {
+		return 0, err
+	}
+	address := c.here
+	c.inst(0x10, 0x00)
+	return address, nil
+}
+
+// if cond then statement, if cond begin … else … end
+func (c *compiler) ifStatement() error {
+	cond, err := c.parseCondition()
+	if err != nil {
+		return err
+	}
+	t, err := c.next()
+	if err != nil {
+		return err
+	}
+	switch t.text {
+	case "then":
+		return c.skipUnless(cond, false)
+	case "begin":
+		address, err := c.jumpUnless(cond)
+		if err != nil {
+			return err
+		}
+		c.branches = append(c.branches, address)
+		return nil
+	}
+	return c.errorf(t, "expected then or begin, found %q", t.text)
+}
+
+func (c *compiler) elseStatement(t token) error {
+	if len(c.branches) == 0 {
+		return c.errorf(t, "else without begin")
+	}
+	address := c.here
+	c.inst(0x10, 0x00)
+	c.patchJump(c.branches[len(c.branches)-1], c.here)
+	c.branches[len(c.branches)-1] = address
+	return nil
+}
+
+func (c *compiler) whileStatement(t token) error {
+	if len(c.loops) == 0 {
+		return c.errorf(t, "while without loop")
+	}
+	cond, err := c.parseCondition()
+	if err != nil {
+		return err
+	}
+	address, err := c.jumpUnless(cond)
+	if err != nil {
+		return err
+	}
+	loop := &c.loops[len(c.loops)-1]
+	loop.exits = append(loop.exits, address)
+	return nil
+}
+
+func (c *compiler) againStatement(t token) error {
+	if len(c.loops) == 0 {
+		return c.errorf(t, "again without loop")
+	}
+	loop := c.loops[len(c.loops)-1]
+	c.loops = c.loops[:len(c.loops)-1]
+	c.inst(0x10|byte(loop.start>>8)&0x0F, byte(loop.start))
+	for _, exit := range loop.exits {
+		c.patchJump(exit, c.here)
+	}
+	return nil
+}
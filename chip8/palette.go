@@ -0,0 +1,146 @@
+package chip8
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Colours of the display, a pixel value selects one of them
+type Palette struct {
+	// Background, the first plane, the second plane and both planes of XO-CHIP
+	// CHIP-8 roms only draw with the first two
+	Colors [4]color.RGBA
+	Border color.RGBA
+}
+
+const DEFAULT_PALETTE = "classic"
+
+// Name of the palette built from the -colors flag
+const CUSTOM_PALETTE = "custom"
+
+var Palettes = map[string]Palette{
+	// White on black
+	"classic": {
+		Colors: [4]color.RGBA{hexColor(0x000000), hexColor(0xFFFFFF), hexColor(0xAAAAAA), hexColor(0x555555)},
+		Border: hexColor(0x006464),
+	},
+	// Amber phosphor monitor
+	"amber": {
+		Colors: [4]color.RGBA{hexColor(0x1A0F00), hexColor(0xFFB000), hexColor(0xB36B00), hexColor(0xFFD480)},
+		Border: hexColor(0x4D3000),
+	},
+	// Green phosphor monitor
+	"green": {
+		Colors: [4]color.RGBA{hexColor(0x001A00), hexColor(0x33FF33), hexColor(0x1F9F1F), hexColor(0x99FF99)},
+		Border: hexColor(0x004D00),
+	},
+	// Greenish LCD of the early handhelds
+	"lcd": {
+		Colors: [4]color.RGBA{hexColor(0x9BBC0F), hexColor(0x0F380F), hexColor(0x306230), hexColor(0x8BAC0F)},
+		Border: hexColor(0x306230),
+	},
+	// Default colours of the Octo IDE
+	"octo": {
+		Colors: [4]color.RGBA{hexColor(0x996600), hexColor(0xFFCC00), hexColor(0xFF6600), hexColor(0x662200)},
+		Border: hexColor(0x662200),
+	},
+	// Four distinct colours for the two planes of XO-CHIP roms
+	"xochip": {
+		Colors: [4]color.RGBA{hexColor(0x1A1C2C), hexColor(0xF4F4F4), hexColor(0x41A6F6), hexColor(0xB13E53)},
+		Border: hexColor(0x333C57),
+	},
+}
+
+var (
+	ActivePalette     = Palettes[DEFAULT_PALETTE]
+	activePaletteName = DEFAULT_PALETTE
+)
+
+func hexColor(rgb uint32) color.RGBA {
+	return color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 255}
+}
+
+// Colour of the pixel value
+func (p *Palette) Color(pixel uint8) color.RGBA {
+	return p.Colors[pixel&3]
+}
+
+// Names of the palettes in order
+func PaletteNames() []string {
+	names := make([]string, 0, len(Palettes))
+	for name := range Palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func SetPalette(name string) error {
+	palette, isExists := Palettes[name]
+	if !isExists {
+		return fmt.Errorf("Unknown palette %q, use %s", name, strings.Join(PaletteNames(), ", "))
+	}
+	ActivePalette = palette
+	activePaletteName = name
+	return nil
+}
+
+func ActivePaletteName() string {
+	return activePaletteName
+}
+
+// Switch to the palette after the active one and return its name
+func NextPalette() string {
+	names := PaletteNames()
+	next := names[0]
+	for i, name := range names {
+		if name == activePaletteName && i+1 < len(names) {
+			next = names[i+1]
+		}
+	}
+	SetPalette(next)
+	return next
+}
+
+// #RRGGBB or #RGB, the # is optional
+func ParseHexColor(text string) (color.RGBA, error) {
+	digits := strings.TrimPrefix(strings.TrimSpace(text), "#")
+	if len(digits) == 3 {
+		digits = string([]byte{digits[0], digits[0], digits[1], digits[1], digits[2], digits[2]})
+	}
+	rgb, err := strconv.ParseUint(digits, 16, 32)
+	if len(digits) != 6 || err != nil {
+		return color.RGBA{}, fmt.Errorf("Invalid colour %q, use #RRGGBB or #RGB", text)
+	}
+	return hexColor(uint32(rgb)), nil
+}
+
+// Register the custom palette from comma separated colours of the background, the first
+// plane, the second plane, both planes and the border and make it active
+// Empty and missing colours are taken from the active palette
+func SetCustomColors(colors string) error {
+	palette := ActivePalette
+	entries := strings.Split(colors, ",")
+	if len(entries) > len(palette.Colors)+1 {
+		return fmt.Errorf("%d colours, expected at most %d", len(entries), len(palette.Colors)+1)
+	}
+	for i, entry := range entries {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parsed, err := ParseHexColor(entry)
+		if err != nil {
+			return err
+		}
+		if i < len(palette.Colors) {
+			palette.Colors[i] = parsed
+		} else {
+			palette.Border = parsed
+		}
+	}
+	Palettes[CUSTOM_PALETTE] = palette
+	return SetPalette(CUSTOM_PALETTE)
+}
@@ -0,0 +1,60 @@
+package chip8
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestParseHexColor(t *testing.T) {
+	cases := map[string]color.RGBA{
+		"#FFB000": {R: 0xFF, G: 0xB0, B: 0x00, A: 255},
+		"33ff33":  {R: 0x33, G: 0xFF, B: 0x33, A: 255},
+		"#0F8":    {R: 0x00, G: 0xFF, B: 0x88, A: 255},
+	}
+	for text, expected := range cases {
+		parsed, err := ParseHexColor(text)
+		if err != nil || parsed != expected {
+			t.Errorf("%s: got %v %v, expected %v", text, parsed, err, expected)
+		}
+	}
+	for _, text := range []string{"", "#12345", "#GGGGGG", "#1234567"} {
+		if _, err := ParseHexColor(text); err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
+
+func TestCustomColors(t *testing.T) {
+	defer SetPalette(ActivePaletteName())
+	defer delete(Palettes, CUSTOM_PALETTE)
+	if err := SetPalette("amber"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetCustomColors("#000,,,,#123456"); err != nil {
+		t.Fatal(err)
+	}
+	amber := Palettes["amber"]
+	if ActivePaletteName() != CUSTOM_PALETTE {
+		t.Errorf("active palette %s", ActivePaletteName())
+	}
+	if ActivePalette.Colors[0] != hexColor(0x000000) || ActivePalette.Border != hexColor(0x123456) {
+		t.Errorf("custom colours not set: %v", ActivePalette)
+	}
+	if ActivePalette.Colors[1] != amber.Colors[1] || ActivePalette.Colors[3] != amber.Colors[3] {
+		t.Errorf("empty entries not taken from amber: %v", ActivePalette)
+	}
+	if err := SetCustomColors("#000,#111,#222,#333,#444,#555"); err == nil {
+		t.Error("expected an error for six colours")
+	}
+}
+
+func TestNextPalette(t *testing.T) {
+	defer SetPalette(ActivePaletteName())
+	names := PaletteNames()
+	SetPalette(names[0])
+	for i := 1; i <= len(names); i++ {
+		if name := NextPalette(); name != names[i%len(names)] {
+			t.Fatalf("step %d: got %s, expected %s", i, name, names[i%len(names)])
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package chip8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mehmetumit/CHIP-8/chip8/asm"
+	"github.com/mehmetumit/CHIP-8/chip8/disasm"
+)
+
+const PROFILE_HOT_SPOTS = 20
+
+type ProfileConfig struct {
+	// Text report, - is stdout
+	ReportPath string
+	// Folded stacks for flamegraph.pl and speedscope, empty to skip
+	FoldedPath string
+	// Disassembly annotated with the execution counts, empty to skip
+	AnnotatePath string
+	// Stop after the number of cycles, 0 runs until the emulator exits
+	MaxCycles uint64
+}
+
+type profileFrame struct {
+	address    ProgramCounter
+	entryCycle uint64
+}
+
+type subroutineCost struct {
+	calls     uint64
+	inclusive uint64
+	exclusive uint64
+}
+
+// Counts the executions of every address and opcode class and the cycles of the
+// subroutines, cycles are attributed to the subroutine on top of the call stack
+type Profiler struct {
+	ProfileConfig
+	Symbols     *asm.Symbols
+	rom         []byte
+	cycles      uint64
+	pcCounts    [len(Memory{})]uint64
+	classCounts map[string]uint64
+	drawCount   uint64
+	drawTime    time.Duration
+	keyWaits    uint64
+	keyWaitTime time.Duration
+	subroutines map[ProgramCounter]*subroutineCost
+	// Root frame is the program start
+	stack      []profileFrame
+	stackNames string
+	folded     map[string]uint64
+	// State before the cycle
+	pc           ProgramCounter
+	stackPointer StackPointer
+	cycleStart   time.Time
+	lastCycleEnd time.Time
+}
+
+// Nil when profiling is off
+var profiler *Profiler
+
+// Boot with the profiler, the reports are written when the emulator exits or
+// after the maximum cycles
+func Profile(romPath string, symbolsPath string, config ProfileConfig, displayScale int32, speed uint8) {
+	setup(romPath, displayScale, speed)
+	rom, err := ReadRom(romPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	profiler = NewProfiler(config, rom)
+	if symbolsPath == "" {
+		symbolsPath = strings.TrimSuffix(romPath, filepath.Ext(romPath)) + ".sym"
+		if _, err := os.Stat(symbolsPath); err != nil {
+			symbolsPath = ""
+		}
+	}
+	if symbolsPath != "" {
+		symbols, err := asm.ReadSymbolsFile(symbolsPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		profiler.Symbols = symbols
+	}
+	control = profiler
+	loop()
+}
+
+func NewProfiler(config ProfileConfig, rom []byte) *Profiler {
+	p := &Profiler{
+		ProfileConfig: config,
+		rom:           rom,
+		classCounts:   map[string]uint64{},
+		subroutines:   map[ProgramCounter]*subroutineCost{},
+		folded:        map[string]uint64{},
+		lastCycleEnd:  time.Now(),
+	}
+	p.push(ProgramCounter(START_ADDRESS))
+	return p
+}
+
+// Opcode classes of decodeAndExecute
+func OpcodeClass(opcode Opcode) string {
+	lastNum := opcode & 0x000F
+	lastTwoNum := opcode & 0x00FF
+	switch opcode >> 12 {
+	case 0x0:
+		switch opcode {
+		case 0x00E0:
+			return "00E0"
+		case 0x00EE:
+			return "00EE"
+		}
+	case 0x1:
+		return "1NNN"
+	case 0x2:
+		return "2NNN"
+	case 0x3:
+		return "3XNN"
+	case 0x4:
+		return "4XNN"
+	case 0x5:
+		return "5XY0"
+	case 0x6:
+		return "6XNN"
+	case 0x7:
+		return "7XNN"
+	case 0x8:
+		switch lastNum {
+		case 0x0, 0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7, 0xE:
+			return fmt.Sprintf("8XY%X", lastNum)
+		}
+	case 0x9:
+		return "9XY0"
+	case 0xA:
+		return "ANNN"
+	case 0xB:
+		return "BNNN"
+	case 0xC:
+		return "CXNN"
+	case 0xD:
+		return "DXYN"
+	case 0xE:
+		switch lastTwoNum {
+		case 0x9E, 0xA1:
+			return fmt.Sprintf("EX%02X", lastTwoNum)
+		}
+	case 0xF:
+		switch lastTwoNum {
+		case 0x07, 0x0A, 0x15, 0x18, 0x1E, 0x29, 0x33, 0x55, 0x65:
+			return fmt.Sprintf("FX%02X", lastTwoNum)
+		}
+	}
+	return "unknown"
+}
+
+// Name of a subroutine, the label of the symbols or sub_ like the disassembler
+func (p *Profiler) name(address ProgramCounter) string {
+	if p.Symbols != nil {
+		if label := p.Symbols.Label(uint16(address)); label != "" {
+			return label
+		}
+	}
+	if address == ProgramCounter(START_ADDRESS) {
+		return "main"
+	}
+	return fmt.Sprintf("sub_%03X", address)
+}
+
+func (p *Profiler) push(address ProgramCounter) {
+	p.stack = append(p.stack, profileFrame{address: address, entryCycle: p.cycles})
+	p.updateStackNames()
+	cost := p.subroutines[address]
+	if cost == nil {
+		cost = &subroutineCost{}
+		p.subroutines[address] = cost
+	}
+	cost.calls++
+}
+
+// Inclusive cycles are only counted by the outermost frame of recursive calls
+func (p *Profiler) pop() {
+	frame := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+	p.updateStackNames()
+	for _, caller := range p.stack {
+		if caller.address == frame.address {
+			return
+		}
+	}
+	p.subroutines[frame.address].inclusive += p.cycles - frame.entryCycle
+}
+
+func (p *Profiler) updateStackNames() {
+	names := make([]string, len(p.stack))
+	for i, frame := range p.stack {
+		names[i] = p.name(frame.address)
+	}
+	p.stackNames = strings.Join(names, ";")
+}
+
+func (p *Profiler) beforeCycle() bool {
+	p.pc = chip8.Cpu.ProgramCounter
+	p.stackPointer = chip8.Cpu.StackPointer
+	p.cycleStart = time.Now()
+	return true
+}
+
+func (p *Profiler) afterCycle() {
+	now := time.Now()
+	p.cycles++
+	if int(p.pc) < len(p.pcCounts) {
+		p.pcCounts[p.pc]++
+	}
+	class := OpcodeClass(chip8.Cpu.Opcode)
+	p.classCounts[class]++
+	switch class {
+	case "DXYN":
+		p.drawCount++
+		p.drawTime += now.Sub(p.cycleStart)
+	case "FX0A":
+		// The instruction repeats itself until a key is pressed
+		if chip8.Cpu.ProgramCounter == p.pc {
+			p.keyWaits++
+			p.keyWaitTime += now.Sub(p.lastCycleEnd)
+		}
+	}
+	p.lastCycleEnd = now
+	// The cycle belongs to the subroutine which executed it
+	p.subroutines[p.stack[len(p.stack)-1].address].exclusive++
+	p.folded[p.stackNames]++
+	switch {
+	case chip8.Cpu.StackPointer > p.stackPointer:
+		p.push(chip8.Cpu.ProgramCounter)
+	case chip8.Cpu.StackPointer < p.stackPointer && len(p.stack) > 1:
+		p.pop()
+	}
+	if p.MaxCycles > 0 && p.cycles >= p.MaxCycles {
+		Shutdown(0)
+	}
+}
+
+// Close the open frames and write the reports
+func StopProfile() {
+	if profiler == nil {
+		return
+	}
+	p := profiler
+	profiler = nil
+	for len(p.stack) > 0 {
+		p.pop()
+	}
+	writers := []struct {
+		path  string
+		write func(io.Writer)
+	}{{p.ReportPath, p.WriteReport}, {p.FoldedPath, p.WriteFolded}, {p.AnnotatePath, p.WriteAnnotated}}
+	for _, writer := range writers {
+		if writer.path == "" {
+			continue
+		}
+		if writer.path == "-" {
+			writer.write(os.Stdout)
+			continue
+		}
+		file, err := os.Create(writer.path)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		out := bufio.NewWriter(file)
+		writer.write(out)
+		out.Flush()
+		file.Close()
+	}
+}
+
+func percent(count uint64, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(count) / float64(total)
+}
+
+func (p *Profiler) WriteReport(w io.Writer) {
+	fmt.Fprintf(w, "Cycles: %d\n", p.cycles)
+	if p.drawCount > 0 {
+		fmt.Fprintf(w, "DXYN: %d draws (%.1f%%), %v, %v per draw\n", p.drawCount, percent(p.drawCount, p.cycles),
+			p.drawTime, p.drawTime/time.Duration(p.drawCount))
+	}
+	fmt.Fprintf(w, "FX0A: %d waiting cycles (%.1f%%), %v waiting for keys\n", p.keyWaits, percent(p.keyWaits, p.cycles), p.keyWaitTime)
+
+	fmt.Fprintf(w, "\nHot spots:\n%10s %6s  %-6s %-4s %s\n", "count", "%", "addr", "op", "instruction")
+	addresses := []int{}
+	for address, count := range p.pcCounts {
+		if count > 0 {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.SliceStable(addresses, func(i, j int) bool {
+		return p.pcCounts[addresses[i]] > p.pcCounts[addresses[j]]
+	})
+	for i, address := range addresses {
+		if i == PROFILE_HOT_SPOTS {
+			break
+		}
+		count := p.pcCounts[address]
+		fmt.Fprintf(w, "%10d %6.2f  0x%03X  %04X %s\n", count, percent(count, p.cycles), address,
+			uint16(opcodeAt(ProgramCounter(address))), Mnemonic(ProgramCounter(address)))
+	}
+
+	fmt.Fprintf(w, "\nOpcode classes:\n%10s %6s  %s\n", "count", "%", "class")
+	classes := make([]string, 0, len(p.classCounts))
+	for class := range p.classCounts {
+		classes = append(classes, class)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		if p.classCounts[classes[i]] != p.classCounts[classes[j]] {
+			return p.classCounts[classes[i]] > p.classCounts[classes[j]]
+		}
+		return classes[i] < classes[j]
+	})
+	for _, class := range classes {
+		fmt.Fprintf(w, "%10d %6.2f  %s\n", p.classCounts[class], percent(p.classCounts[class], p.cycles), class)
+	}
+
+	fmt.Fprintf(w, "\nSubroutines:\n%8s %10s %6s %10s %6s  %s\n", "calls", "inclusive", "%", "exclusive", "%", "name")
+	subroutines := make([]ProgramCounter, 0, len(p.subroutines))
+	for address := range p.subroutines {
+		subroutines = append(subroutines, address)
+	}
+	sort.Slice(subroutines, func(i, j int) bool {
+		a, b := p.subroutines[subroutines[i]], p.subroutines[subroutines[j]]
+		if a.inclusive != b.inclusive {
+			return a.inclusive > b.inclusive
+		}
+		return subroutines[i] < subroutines[j]
+	})
+	for _, address := range subroutines {
+		cost := p.subroutines[address]
+		fmt.Fprintf(w, "%8d %10d %6.2f %10d %6.2f  %s\n", cost.calls, cost.inclusive, percent(cost.inclusive, p.cycles),
+			cost.exclusive, percent(cost.exclusive, p.cycles), p.name(address))
+	}
+}
+
+// One line per call stack with its cycles, e.g. main;draw_paddle 1200
+func (p *Profiler) WriteFolded(w io.Writer) {
+	stacks := make([]string, 0, len(p.folded))
+	for stack := range p.folded {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	for _, stack := range stacks {
+		fmt.Fprintf(w, "%s %d\n", stack, p.folded[stack])
+	}
+}
+
+// Instructions of the rom and every executed address with their counts
+func (p *Profiler) WriteAnnotated(w io.Writer) {
+	program := disasm.Trace(p.rom, START_ADDRESS)
+	isListed := map[int]bool{}
+	addresses := []int{}
+	for address := range program.Instructions {
+		isListed[int(address)] = true
+		addresses = append(addresses, int(address))
+	}
+	for address, count := range p.pcCounts {
+		if count > 0 && !isListed[address] {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Ints(addresses)
+	for _, address := range addresses {
+		pc := ProgramCounter(address)
+		label := program.Labels[uint16(pc)]
+		if p.subroutines[pc] != nil || (p.Symbols != nil && p.Symbols.Label(uint16(pc)) != "") {
+			label = p.name(pc)
+		}
+		if label != "" {
+			fmt.Fprintf(w, "%s:\n", label)
+		}
+		count := p.pcCounts[address]
+		column := fmt.Sprintf("%10d %6.2f", count, percent(count, p.cycles))
+		if count == 0 {
+			column = fmt.Sprintf("%10s %6s", ".", "")
+		}
+		fmt.Fprintf(w, "%s  0x%03X: %04X  %s\n", column, address, uint16(opcodeAt(pc)), Mnemonic(pc))
+	}
+}
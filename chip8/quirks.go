@@ -0,0 +1,60 @@
+package chip8
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Behaviours which differ between CHIP-8 interpreters, roms are written for one of them
+type Quirks struct {
+	// 8XY1, 8XY2 and 8XY3 reset VF to 0 like the COSMAC VIP
+	VFReset bool
+	// FX55 and FX65 leave I at the address after the last register
+	IncrementIndex bool
+	// 8XY6 and 8XYE shift VY into VX instead of shifting VX in place
+	ShiftVY bool
+	// BNNN jumps to XNN plus VX instead of NNN plus V0
+	JumpVX bool
+	// Sprites are clipped at the display edges instead of wrapping around
+	Clipping bool
+}
+
+const DEFAULT_QUIRK_PROFILE = "modern"
+
+var QuirkProfiles = map[string]Quirks{
+	// Behaviour of most modern interpreters
+	"modern": {},
+	// Original COSMAC VIP interpreter
+	"cosmac": {VFReset: true, IncrementIndex: true, ShiftVY: true, Clipping: true},
+	// CHIP-48 and SUPER-CHIP on the HP48 calculators
+	"schip": {JumpVX: true, Clipping: true},
+}
+
+// Names of the profiles in order
+func QuirkProfileNames() []string {
+	names := make([]string, 0, len(QuirkProfiles))
+	for name := range QuirkProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func ParseQuirkProfile(name string) (Quirks, error) {
+	quirks, isExists := QuirkProfiles[name]
+	if !isExists {
+		return Quirks{}, fmt.Errorf("Unknown quirk profile %q, use %s", name, strings.Join(QuirkProfileNames(), ", "))
+	}
+	return quirks, nil
+}
+
+// Set the quirks of the machine from the profile name
+func SetQuirkProfile(name string) error {
+	quirks, err := ParseQuirkProfile(name)
+	if err != nil {
+		return err
+	}
+	chip8.Quirks = quirks
+	return nil
+}
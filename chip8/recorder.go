@@ -0,0 +1,484 @@
+package chip8
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Recording formats, the animations keep the frames in memory and are written when the
+// recording stops, the video streams are written frame by frame
+const (
+	RECORD_GIF  = "gif"
+	RECORD_APNG = "apng"
+	// YUV4MPEG2 4:4:4, read by ffmpeg and most players
+	RECORD_Y4M = "y4m"
+	// Raw rgb24 frames, ffmpeg -f rawvideo -pix_fmt rgb24 -s WxH -r 60 -i file
+	RECORD_RGB = "rgb"
+)
+
+// GIF delays are centiseconds and browsers slow down delays under 2, so the gif is
+// recorded at 50 frames per second
+const GIF_FRAME_RATE = 50
+
+const (
+	AUDIO_SAMPLE_RATE = 44100
+	BEEP_FREQUENCY    = 440
+	BEEP_VOLUME       = 8000
+)
+
+type RecordConfig struct {
+	Path string
+	// Taken from the extension of the path when empty
+	Format string
+	// Write the sound timer beeps as 16 bit mono wav to the file
+	WavPath string
+	// Pixels per display pixel, or per pixel of the upscaled image with the effects
+	Scale int
+}
+
+type Recorder struct {
+	RecordConfig
+	frameRate int
+	start     time.Duration
+	nextFrame int
+	// Frames without effects and phosphor are indexed with the palette the recording
+	// started with, nil records true colour
+	palette color.Palette
+	file    *os.File
+	out     *bufio.Writer
+	// Frames of the animations, a repeated frame extends the duration of the previous one
+	gif        *gif.GIF
+	apngHeader []byte
+	apngFrames [][]byte
+	apngDelays []uint16
+	lastFrame  []byte
+	wav        *wavWriter
+}
+
+var recorder *Recorder
+
+// Directory, format and scale of the recordings toggled with the hotkey
+var (
+	RecordDir    = "."
+	RecordFormat = RECORD_GIF
+	RecordScale  = 1
+)
+
+func RecordFormats() []string {
+	return []string{RECORD_GIF, RECORD_APNG, RECORD_Y4M, RECORD_RGB}
+}
+
+func ParseRecordFormat(format string) error {
+	for _, name := range RecordFormats() {
+		if format == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown recording format %q, use %s", format, strings.Join(RecordFormats(), ", "))
+}
+
+// Record every presented frame from now on until StopRecording
+func StartRecording(config RecordConfig) error {
+	if recorder != nil {
+		return fmt.Errorf("Already recording to %s", recorder.Path)
+	}
+	if config.Format == "" {
+		config.Format = strings.ToLower(strings.TrimPrefix(filepath.Ext(config.Path), "."))
+		if config.Format == "png" {
+			config.Format = RECORD_APNG
+		}
+	}
+	if err := ParseRecordFormat(config.Format); err != nil {
+		return err
+	}
+	if config.Scale < 1 {
+		config.Scale = 1
+	}
+	r := &Recorder{RecordConfig: config, frameRate: FRAME_RATE, start: emulatedTime()}
+	if len(Effects) == 0 && Smoothing.Mode != SMOOTHING_PHOSPHOR {
+		for _, c := range ActivePalette.Colors {
+			r.palette = append(r.palette, c)
+		}
+	}
+	var err error
+	switch config.Format {
+	case RECORD_GIF:
+		r.frameRate = GIF_FRAME_RATE
+		r.gif = &gif.GIF{}
+	case RECORD_Y4M, RECORD_RGB:
+		if r.file, err = os.Create(config.Path); err != nil {
+			return err
+		}
+		r.out = bufio.NewWriter(r.file)
+	}
+	if config.WavPath != "" {
+		if r.wav, err = newWavWriter(config.WavPath); err != nil {
+			if r.file != nil {
+				r.file.Close()
+			}
+			return err
+		}
+	}
+	recorder = r
+	return nil
+}
+
+// Write the recording, called by halt
+func StopRecording() error {
+	if recorder == nil {
+		return nil
+	}
+	r := recorder
+	recorder = nil
+	var err error
+	switch r.Format {
+	case RECORD_GIF:
+		err = r.writeGIF()
+	case RECORD_APNG:
+		err = r.writeAPNG()
+	default:
+		err = r.out.Flush()
+		if closeErr := r.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if r.wav != nil {
+		if wavErr := r.wav.close(); err == nil {
+			err = wavErr
+		}
+	}
+	return err
+}
+
+func IsRecording() bool {
+	return recorder != nil
+}
+
+// Start a recording named after the rom in RecordDir or stop the running one,
+// returns the path of the recording
+func ToggleRecording() (string, error) {
+	if recorder != nil {
+		path := recorder.Path
+		return path, StopRecording()
+	}
+	name := "chip8"
+	if chip8.Rom != "" {
+		name = strings.TrimSuffix(filepath.Base(chip8.Rom), filepath.Ext(chip8.Rom))
+	}
+	extension := RecordFormat
+	if extension == RECORD_APNG {
+		extension = "png"
+	}
+	path := filepath.Join(RecordDir, fmt.Sprintf("%s-%s.%s", name, time.Now().Format("20060102-150405.000"), extension))
+	return path, StartRecording(RecordConfig{Path: path, Format: RecordFormat, Scale: RecordScale})
+}
+
+// Called after every cycle, captures the frames and the audio due at the emulated time,
+// a frame is captured when its time has passed
+func recordCycle() {
+	if recorder == nil {
+		return
+	}
+	r := recorder
+	elapsed := emulatedTime() - r.start
+	for time.Duration(r.nextFrame+1)*time.Second/time.Duration(r.frameRate) <= elapsed {
+		if err := r.captureFrame(); err != nil {
+			log.Print("Recording failed! ", err)
+			// The frames so far are kept and the files are closed with their trailers
+			if err = StopRecording(); err != nil {
+				log.Print("Recording failed! ", err)
+			}
+			return
+		}
+		r.nextFrame++
+	}
+	if r.wav != nil {
+		r.wav.writeUntil(int64(elapsed)*AUDIO_SAMPLE_RATE/int64(time.Second), chip8.SoundTimer > 0)
+	}
+}
+
+func (r *Recorder) frameImage() *image.RGBA {
+	return scaleNearest(PostProcess(presentedImage(currentPresentedDisplay())), r.Scale)
+}
+
+func (r *Recorder) captureFrame() error {
+	img := r.frameImage()
+	switch r.Format {
+	case RECORD_GIF:
+		if r.isRepeated(img.Pix) {
+			r.gif.Delay[len(r.gif.Delay)-1] += 100 / GIF_FRAME_RATE
+			return nil
+		}
+		r.gif.Image = append(r.gif.Image, r.paletted(img))
+		r.gif.Delay = append(r.gif.Delay, 100/GIF_FRAME_RATE)
+	case RECORD_APNG:
+		if r.isRepeated(img.Pix) {
+			r.apngDelays[len(r.apngDelays)-1]++
+			return nil
+		}
+		if r.palette != nil {
+			return r.addAPNGFrame(r.paletted(img))
+		}
+		return r.addAPNGFrame(img)
+	case RECORD_Y4M:
+		return r.writeY4MFrame(img)
+	case RECORD_RGB:
+		for i := 0; i < len(img.Pix); i += 4 {
+			r.out.Write(img.Pix[i : i+3])
+		}
+	}
+	return nil
+}
+
+// Same pixels as the last captured frame
+func (r *Recorder) isRepeated(pixels []byte) bool {
+	if r.lastFrame != nil && bytes.Equal(r.lastFrame, pixels) {
+		return true
+	}
+	r.lastFrame = append(r.lastFrame[:0], pixels...)
+	return false
+}
+
+// Indexed copy of the image with the recording palette or the colours of the frame,
+// frames with too many colours are dithered to the Plan 9 palette
+func (r *Recorder) paletted(img *image.RGBA) *image.Paletted {
+	colors := r.palette
+	if colors == nil {
+		colors = framePalette(img)
+	}
+	if colors == nil {
+		frame := image.NewPaletted(img.Rect, palette.Plan9)
+		draw.FloydSteinberg.Draw(frame, img.Rect, img, image.Point{})
+		return frame
+	}
+	frame := image.NewPaletted(img.Rect, colors)
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < img.Rect.Dx(); x++ {
+			frame.SetColorIndex(x, y, uint8(colors.Index(img.RGBAAt(x, y))))
+		}
+	}
+	return frame
+}
+
+// Colours of the image, nil if there are more than a gif can index
+func framePalette(img *image.RGBA) color.Palette {
+	seen := map[color.RGBA]bool{}
+	var colors color.Palette
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < img.Rect.Dx(); x++ {
+			c := img.RGBAAt(x, y)
+			if !seen[c] {
+				if len(colors) == 256 {
+					return nil
+				}
+				seen[c] = true
+				colors = append(colors, c)
+			}
+		}
+	}
+	return colors
+}
+
+func (r *Recorder) writeGIF() error {
+	file, err := os.Create(r.Path)
+	if err != nil {
+		return err
+	}
+	if len(r.gif.Image) > 0 {
+		err = gif.EncodeAll(file, r.gif)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Keep the image data of the frame, the header chunks come from the first frame
+func (r *Recorder) addAPNGFrame(frame image.Image) error {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, frame); err != nil {
+		return err
+	}
+	var header, data []byte
+	chunks := encoded.Bytes()[8:]
+	for len(chunks) >= 12 {
+		length := binary.BigEndian.Uint32(chunks)
+		chunk := chunks[:12+length]
+		switch string(chunk[4:8]) {
+		case "IDAT":
+			data = append(data, chunk[8:8+length]...)
+		case "IEND":
+		default:
+			header = append(header, chunk...)
+		}
+		chunks = chunks[12+length:]
+	}
+	if r.apngHeader == nil {
+		r.apngHeader = header
+	}
+	r.apngFrames = append(r.apngFrames, data)
+	r.apngDelays = append(r.apngDelays, 1)
+	return nil
+}
+
+// The first frame is the default image, the other ones follow in fdAT chunks
+func (r *Recorder) writeAPNG() error {
+	if len(r.apngFrames) == 0 {
+		return nil
+	}
+	var out bytes.Buffer
+	out.WriteString("\x89PNG\r\n\x1a\n")
+	// IHDR comes first, acTL has to come before the image data
+	ihdrSize := 12 + int(binary.BigEndian.Uint32(r.apngHeader))
+	out.Write(r.apngHeader[:ihdrSize])
+	width := binary.BigEndian.Uint32(r.apngHeader[8:])
+	height := binary.BigEndian.Uint32(r.apngHeader[12:])
+	var actl []byte
+	actl = binary.BigEndian.AppendUint32(actl, uint32(len(r.apngFrames)))
+	// Loop forever
+	actl = binary.BigEndian.AppendUint32(actl, 0)
+	writePNGChunk(&out, "acTL", actl)
+	out.Write(r.apngHeader[ihdrSize:])
+	sequence := uint32(0)
+	for i, data := range r.apngFrames {
+		var fctl []byte
+		fctl = binary.BigEndian.AppendUint32(fctl, sequence)
+		fctl = binary.BigEndian.AppendUint32(fctl, width)
+		fctl = binary.BigEndian.AppendUint32(fctl, height)
+		// Offset, the delay in frames, no disposal and no blending
+		fctl = binary.BigEndian.AppendUint32(fctl, 0)
+		fctl = binary.BigEndian.AppendUint32(fctl, 0)
+		fctl = binary.BigEndian.AppendUint16(fctl, r.apngDelays[i])
+		fctl = binary.BigEndian.AppendUint16(fctl, uint16(r.frameRate))
+		fctl = append(fctl, 0, 0)
+		writePNGChunk(&out, "fcTL", fctl)
+		sequence++
+		if i == 0 {
+			writePNGChunk(&out, "IDAT", data)
+			continue
+		}
+		writePNGChunk(&out, "fdAT", append(binary.BigEndian.AppendUint32(nil, sequence), data...))
+		sequence++
+	}
+	writePNGChunk(&out, "IEND", nil)
+	return os.WriteFile(r.Path, out.Bytes(), 0644)
+}
+
+// BT.601 studio range 4:4:4 planes
+func (r *Recorder) writeY4MFrame(img *image.RGBA) error {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	if r.nextFrame == 0 {
+		fmt.Fprintf(r.out, "YUV4MPEG2 W%d H%d F%d:1 Ip A1:1 C444\n", width, height, r.frameRate)
+	}
+	planes := [3][]byte{make([]byte, width*height), make([]byte, width*height), make([]byte, width*height)}
+	for i := 0; i < width*height; i++ {
+		red, green, blue := float64(img.Pix[4*i]), float64(img.Pix[4*i+1]), float64(img.Pix[4*i+2])
+		planes[0][i] = uint8(16 + (65.738*red+129.057*green+25.064*blue)/256)
+		planes[1][i] = uint8(128 + (-37.945*red-74.494*green+112.439*blue)/256)
+		planes[2][i] = uint8(128 + (112.439*red-94.154*green-18.285*blue)/256)
+	}
+	r.out.WriteString("FRAME\n")
+	for _, plane := range planes {
+		if _, err := r.out.Write(plane); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 16 bit mono pcm, the sizes in the header are written when it is closed
+type wavWriter struct {
+	file    *os.File
+	out     *bufio.Writer
+	samples int64
+}
+
+func newWavWriter(path string) (*wavWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &wavWriter{file: file, out: bufio.NewWriter(file)}
+	header := []byte("RIFF\x00\x00\x00\x00WAVEfmt ")
+	header = binary.LittleEndian.AppendUint32(header, 16)
+	// PCM, mono, the sample rate, the byte rate, the block size and the bits per sample
+	header = binary.LittleEndian.AppendUint16(header, 1)
+	header = binary.LittleEndian.AppendUint16(header, 1)
+	header = binary.LittleEndian.AppendUint32(header, AUDIO_SAMPLE_RATE)
+	header = binary.LittleEndian.AppendUint32(header, AUDIO_SAMPLE_RATE*2)
+	header = binary.LittleEndian.AppendUint16(header, 2)
+	header = binary.LittleEndian.AppendUint16(header, 16)
+	header = append(header, "data\x00\x00\x00\x00"...)
+	w.out.Write(header)
+	return w, nil
+}
+
+// Write samples up to the total, a square wave while the beep is on
+func (w *wavWriter) writeUntil(total int64, isBeeping bool) {
+	for ; w.samples < total; w.samples++ {
+		sample := int16(0)
+		if isBeeping {
+			sample = BEEP_VOLUME
+			if w.samples*2*BEEP_FREQUENCY/AUDIO_SAMPLE_RATE%2 == 1 {
+				sample = -BEEP_VOLUME
+			}
+		}
+		w.out.Write([]byte{byte(sample), byte(sample >> 8)})
+	}
+}
+
+func (w *wavWriter) close() error {
+	err := w.out.Flush()
+	size := uint32(w.samples * 2)
+	for _, field := range []struct {
+		offset int64
+		value  uint32
+	}{{4, 36 + size}, {40, size}} {
+		if err == nil {
+			_, err = w.file.WriteAt(binary.LittleEndian.AppendUint32(nil, field.value), field.offset)
+		}
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Run the rom headless for the cycles with the input of the movie and record it,
+// the movie path can be empty
+func Record(romPath string, moviePath string, cycles uint64, config RecordConfig) error {
+	var input []KeyInput
+	if moviePath != "" {
+		var err error
+		if input, err = ReadMovie(moviePath); err != nil {
+			return err
+		}
+	}
+	// The recording starts at the emulated time of the cleared machine
+	frontend = headlessFrontend{}
+	reset()
+	if err := StartRecording(config); err != nil {
+		return err
+	}
+	err := runMovie(romPath, cycles, input)
+	if stopErr := StopRecording(); err == nil {
+		err = stopErr
+	}
+	if err == nil && chip8.Fault != nil {
+		err = chip8.Fault
+	}
+	return err
+}
@@ -0,0 +1,219 @@
+package chip8
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/gif"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Count the chunks of each type in the png
+func pngChunks(t *testing.T, data []byte) map[string]int {
+	t.Helper()
+	chunks := map[string]int{}
+	for offset := 8; offset+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		chunks[string(data[offset+4:offset+8])]++
+		offset += 12 + length
+	}
+	return chunks
+}
+
+func TestRecordAnimations(t *testing.T) {
+	defer SetSpeed(chip8.Speed)
+	SetSpeed(3)
+	dir := t.TempDir()
+	// 100 cycles of 3ms, the logo is drawn in the first cycles and then stays
+	gifPath := filepath.Join(dir, "ibm.gif")
+	if err := Record("../roms/IBM-Logo.ch8", "", 100, RecordConfig{Path: gifPath, Scale: 2}); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(gifPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	animation, err := gif.DecodeAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(animation.Image) < 2 || animation.Image[0].Bounds().Dx() != 2*WIDTH {
+		t.Fatalf("%d frames of %v", len(animation.Image), animation.Image[0].Bounds())
+	}
+	// 300ms at 50 frames per second, repeated frames extend the delays
+	total := 0
+	for _, delay := range animation.Delay {
+		total += delay
+	}
+	if total != 30 {
+		t.Errorf("gif lasts %d centiseconds, expected 30", total)
+	}
+	last := animation.Image[len(animation.Image)-1]
+	for x := 0; x < WIDTH; x++ {
+		for y := 0; y < HEIGHT; y++ {
+			if last.At(2*x, 2*y) != ActivePalette.Color(chip8.Display[x][y]) {
+				t.Fatalf("last frame differs from the display at %d,%d", x, y)
+			}
+		}
+	}
+
+	apngPath := filepath.Join(dir, "ibm.png")
+	if err := Record("../roms/IBM-Logo.ch8", "", 100, RecordConfig{Path: apngPath}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(apngPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Viewers without apng support show the first frame
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	chunks := pngChunks(t, data)
+	if chunks["acTL"] != 1 || chunks["IDAT"] != 1 || chunks["fcTL"] != chunks["fdAT"]+1 || chunks["fcTL"] < 2 {
+		t.Errorf("apng chunks %v", chunks)
+	}
+}
+
+func TestRecordVideoAndAudio(t *testing.T) {
+	defer SetSpeed(chip8.Speed)
+	SetSpeed(10)
+	dir := t.TempDir()
+	// Beep for 0x10 cycles, then wait for the key 5 and beep again
+	rom := []byte{0x60, 0x10, 0xF0, 0x18, 0xF1, 0x0A, 0xF0, 0x18, 0x12, 0x08}
+	romPath := filepath.Join(dir, "beep.ch8")
+	moviePath := filepath.Join(dir, "movie.json")
+	if err := os.WriteFile(romPath, rom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(moviePath, []byte(`[{"frame": 50, "keys": "5"}, {"frame": 52, "keys": ""}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	videoPath, wavPath := filepath.Join(dir, "beep.y4m"), filepath.Join(dir, "beep.wav")
+	// 100 cycles of 10ms
+	if err := Record(romPath, moviePath, 100, RecordConfig{Path: videoPath, WavPath: wavPath}); err != nil {
+		t.Fatal(err)
+	}
+	video, err := os.ReadFile(videoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, frames, _ := strings.Cut(string(video), "\n")
+	if header != "YUV4MPEG2 W64 H32 F60:1 Ip A1:1 C444" {
+		t.Errorf("y4m header %q", header)
+	}
+	// A second at 60 frames per second
+	if frameSize := len("FRAME\n") + 3*WIDTH*HEIGHT; len(frames) != 60*frameSize {
+		t.Errorf("%d bytes of frames, expected 60 of %d", len(frames), frameSize)
+	}
+
+	wav, err := os.ReadFile(wavPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := binary.LittleEndian.Uint32(wav[40:])
+	if string(wav[:4]) != "RIFF" || binary.LittleEndian.Uint32(wav[4:]) != 36+size || int(size) != len(wav)-44 {
+		t.Fatalf("wav sizes %d and %d for %d bytes", binary.LittleEndian.Uint32(wav[4:]), size, len(wav))
+	}
+	if size != 2*AUDIO_SAMPLE_RATE {
+		t.Errorf("%d samples, expected a second", size/2)
+	}
+	// Sound between the frames 2 and 18 and after the key press, silence in between
+	isBeeping := func(frame int) bool {
+		sample := 44 + 2*(frame*AUDIO_SAMPLE_RATE/100+10)
+		return binary.LittleEndian.Uint16(wav[sample:]) != 0
+	}
+	for frame, expected := range map[int]bool{5: true, 15: true, 30: false, 45: false, 56: true, 90: false} {
+		if isBeeping(frame) != expected {
+			t.Errorf("frame %d beeping %v", frame, !expected)
+		}
+	}
+}
+
+// Profile and Cover exit after -cycles, the recording is finished on the way out
+// The runs exit the process, so they run in a child of the test binary
+func TestRecordUntilCycles(t *testing.T) {
+	if command := os.Getenv("CHIP8_RECORD_COMMAND"); command != "" {
+		frontend = headlessFrontend{}
+		dir := os.Getenv("CHIP8_RECORD_DIR")
+		if err := StartRecording(RecordConfig{Path: filepath.Join(dir, command+".gif")}); err != nil {
+			t.Fatal(err)
+		}
+		if command == "profile" {
+			Profile("../roms/IBM-Logo.ch8", "", ProfileConfig{ReportPath: filepath.Join(dir, "report"), MaxCycles: 200}, 1, 1)
+		} else {
+			Cover("../roms/IBM-Logo.ch8", "", CoverageConfig{ReportPath: filepath.Join(dir, "report"), MaxCycles: 200}, 1, 1)
+		}
+		t.Fatal("The run didn't exit")
+	}
+	for _, command := range []string{"profile", "coverage"} {
+		dir := t.TempDir()
+		child := exec.Command(os.Args[0], "-test.run=^TestRecordUntilCycles$")
+		child.Env = append(os.Environ(), "CHIP8_RECORD_COMMAND="+command, "CHIP8_RECORD_DIR="+dir)
+		if output, err := child.CombinedOutput(); err != nil {
+			t.Fatalf("%s exited with %v: %s", command, err, output)
+		}
+		file, err := os.Open(filepath.Join(dir, command+".gif"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		animation, err := gif.DecodeAll(file)
+		file.Close()
+		if err != nil || len(animation.Image) == 0 {
+			t.Errorf("The %s recording has no frames: %v", command, err)
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(data []byte) (int, error) {
+	return 0, errors.New("Disk full")
+}
+
+// A frame which can't be written stops the recording, the files are closed and the
+// wav gets its sizes
+func TestRecordFailure(t *testing.T) {
+	defer SetSpeed(chip8.Speed)
+	SetSpeed(10)
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	frontend = headlessFrontend{}
+	reset()
+	defer reset()
+	dir := t.TempDir()
+	wavPath := filepath.Join(dir, "run.wav")
+	if err := StartRecording(RecordConfig{Path: filepath.Join(dir, "run.y4m"), WavPath: wavPath}); err != nil {
+		t.Fatal(err)
+	}
+	r := recorder
+	r.wav.writeUntil(AUDIO_SAMPLE_RATE/10, true)
+	r.out = bufio.NewWriterSize(failingWriter{}, 16)
+	chip8.Frame = 10
+	recordCycle()
+	if IsRecording() {
+		t.Fatal("The recording goes on after the failure")
+	}
+	if err := r.file.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("The video file is open: %v", err)
+	}
+	if err := r.wav.file.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("The wav file is open: %v", err)
+	}
+	wav, err := os.ReadFile(wavPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := binary.LittleEndian.Uint32(wav[40:]); size != AUDIO_SAMPLE_RATE/10*2 || int(size) != len(wav)-44 {
+		t.Errorf("The wav has %d bytes of samples in %d bytes", size, len(wav))
+	}
+}
@@ -1,42 +1,64 @@
+//go:build !nosdl && !js
+
 package chip8
 
 import (
 	"github.com/veandco/go-sdl2/sdl"
+	"image"
+	"image/color"
 	"log"
+	"time"
+	"unsafe"
 )
 
-// Monochrome display, 1 or 0
-// width -> 64, height -> 32
-// Width and height can be set differenlty on some interpreters
-const WIDTH = 64
-const HEIGHT = 32
-const DISPLAY_PADDING = 90
-const BORDER_PADDING = 10
-
-type Display [WIDTH][HEIGHT]uint8
-
-var DisplayScale int32
-
 var (
-	Window             *sdl.Window
-	Renderer           *sdl.Renderer
-	WindowWidth        int32 = 940
-	WindowHeight       int32 = 570
-	PixelColor               = sdl.Color{R: 255, G: 255, B: 255, A: 255}
-	BackgroundColor          = sdl.Color{R: 0, G: 0, B: 0, A: 255}
-	DisplayBorderColor       = sdl.Color{R: 0, G: 100, B: 100, A: 255}
+	Window   *sdl.Window
+	Renderer *sdl.Renderer
+	// Set from the display scale when the window is created
+	WindowWidth  int32
+	WindowHeight int32
+	// The display is uploaded to the texture once per render and scaled by SDL
+	DisplayTexture *sdl.Texture
+	// Texture of the post-processed image, recreated when the effects change its size
+	effectsTexture *sdl.Texture
+	// ARGB pixels of the texture, row by row
+	texturePixels [WIDTH * HEIGHT]uint32
+	// The HUD over the whole window and the time its image was drawn
+	hudTexture  *sdl.Texture
+	lastHUDDraw time.Time
 )
 
+// SDL window and audio device
+type sdlFrontend struct{}
+
+func (sdlFrontend) Start(display *Display)                   { StartDisplay(display) }
+func (sdlFrontend) Clear(display *Display)                   { ClearRenderer(display) }
+func (sdlFrontend) Render(display *Display)                  { RenderDisplay(display) }
+func (sdlFrontend) PlayAudio()                               { PlayAudio() }
+func (sdlFrontend) PauseAudio()                              { PauseAudio() }
+func (sdlFrontend) HandleEvents(quit func(), keypad *Keypad) { EventHandler(quit, keypad) }
+func (sdlFrontend) Close()                                   { CloseDisplay() }
+
 func init() {
+	frontends["sdl"] = sdlFrontend{}
+	frontend = sdlFrontend{}
+}
+
+// SDL is initialized with the window, headless runs never touch the video and audio devices
+func StartDisplay(display *Display) {
 	err := sdl.Init(sdl.INIT_VIDEO | sdl.INIT_AUDIO)
 	if err != nil {
 		log.Fatal("SDL initialization failed!", err)
 	}
-}
-func StartDisplay(display *Display) {
-	var err error
+	openAudio()
+	WindowWidth = WIDTH*DisplayScale + 2*DISPLAY_PADDING
+	WindowHeight = HEIGHT*DisplayScale + 2*DISPLAY_PADDING
+	var flags uint32 = sdl.WINDOW_SHOWN | sdl.WINDOW_RESIZABLE | sdl.WINDOW_ALLOW_HIGHDPI
+	if IsFullscreen {
+		flags |= sdl.WINDOW_FULLSCREEN_DESKTOP
+	}
 	Window, err = sdl.CreateWindow("CHIP-8", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED,
-		WindowWidth, WindowHeight, sdl.WINDOW_SHOWN | sdl.WINDOW_RESIZABLE)
+		WindowWidth, WindowHeight, flags)
 	if err != nil {
 		log.Fatal("Window creation failed!", err)
 	}
@@ -45,19 +67,35 @@ func StartDisplay(display *Display) {
 	if err != nil {
 		log.Fatal("Failed to create renderer!", err)
 	}
+	// The hint applies to the textures created after it
+	sdl.SetHint(sdl.HINT_RENDER_SCALE_QUALITY, TextureFilter)
+	DisplayTexture, err = Renderer.CreateTexture(sdl.PIXELFORMAT_ARGB8888, sdl.TEXTUREACCESS_STREAMING, WIDTH, HEIGHT)
+	if err != nil {
+		log.Fatal("Failed to create display texture!", err)
+	}
 	ClearRenderer(display)
 
 }
 
-func setDrawColor(color *sdl.Color) {
+// Release the window and the audio device if they were opened
+func CloseDisplay() {
+	if Window == nil {
+		return
+	}
+	Window.Destroy()
+	ClouseAudio()
+	sdl.Quit()
+	Window = nil
+}
+
+func setDrawColor(color *color.RGBA) {
 	Renderer.SetDrawColor(color.R, color.G, color.B, color.A)
 }
 func updateRenderer() {
 	Renderer.Present()
 }
 func ClearRenderer(display *Display) {
-	log.Print("Display cleaning...")
-	setDrawColor(&BackgroundColor)
+	setDrawColor(&ActivePalette.Colors[0])
 	for i := 0; i < len(display); i++ {
 		for j := 0; j < len(display[i]); j++ {
 			display[i][j] = 0
@@ -65,48 +103,113 @@ func ClearRenderer(display *Display) {
 	}
 	updateRenderer()
 }
+
+// Upload the display to the texture and let SDL scale it into the border
 func RenderDisplay(display *Display) {
-	setDrawColor(&BackgroundColor)
+	texture := DisplayTexture
+	if len(Effects) > 0 {
+		texture = imageTexture(&effectsTexture, PostProcess(presentedImage(display)))
+	} else {
+		updateDisplayTexture(display)
+	}
+	setDrawColor(&ActivePalette.Colors[0])
 	Renderer.Clear()
-	DrawDisplayBorder()
-	for j := 0; j < HEIGHT; j++ {
-		for i := 0; i < WIDTH; i++ {
-			pixelState := uint8ToBool(display[i][j])
-			DrawPixel(int32(i), int32(j), pixelState)
-		}
+	displayRect := currentDisplayRect()
+	DrawDisplayBorder(&displayRect)
+	Renderer.Copy(texture, nil, &displayRect)
+	countHUDRender(time.Now())
+	if IsHUDVisible {
+		drawHUD()
 	}
 	updateRenderer()
 }
-func uint8ToBool(num uint8) bool {
-	if num == 0x1 {
-		return true
-	} else {
-		return false
+
+func updateDisplayTexture(display *Display) {
+	var colors [len(ActivePalette.Colors)]uint32
+	for i := range colors {
+		colors[i] = argb(&ActivePalette.Colors[i])
+	}
+	for y := 0; y < HEIGHT; y++ {
+		for x := 0; x < WIDTH; x++ {
+			if DisplayBrightness[x][y] == FULL_BRIGHTNESS {
+				texturePixels[y*WIDTH+x] = colors[display[x][y]&3]
+			} else {
+				blended := ActivePalette.Blend(display[x][y], DisplayBrightness[x][y])
+				texturePixels[y*WIDTH+x] = argb(&blended)
+			}
+		}
 	}
+	DisplayTexture.UpdateRGBA(nil, texturePixels[:], WIDTH)
+}
 
+// Upload the image to the texture, which is recreated when the size of the image changed
+func imageTexture(texture **sdl.Texture, img *image.RGBA) *sdl.Texture {
+	width, height := int32(img.Rect.Dx()), int32(img.Rect.Dy())
+	if *texture != nil {
+		if _, _, textureWidth, textureHeight, err := (*texture).Query(); err != nil || textureWidth != width || textureHeight != height {
+			(*texture).Destroy()
+			*texture = nil
+		}
+	}
+	if *texture == nil {
+		var err error
+		*texture, err = Renderer.CreateTexture(uint32(sdl.PIXELFORMAT_RGBA32), sdl.TEXTUREACCESS_STREAMING, width, height)
+		if err != nil {
+			log.Fatal("Failed to create image texture!", err)
+		}
+	}
+	(*texture).Update(nil, unsafe.Pointer(&img.Pix[0]), img.Stride)
+	return *texture
 }
-func DrawPixel(x int32, y int32, isPixelOn bool) {
-	if isPixelOn {
-		setDrawColor(&PixelColor)
-	} else {
-		setDrawColor(&BackgroundColor)
+
+// Blend the HUD over the window, its image is redrawn at the refresh rate or when the size changed
+func drawHUD() {
+	width, height, err := Renderer.GetOutputSize()
+	if err != nil {
+		width, height = Window.GetSize()
 	}
-	pixelRect := sdl.Rect{
-		X: DISPLAY_PADDING + int32(x*DisplayScale),
-		Y: DISPLAY_PADDING + int32(y*DisplayScale),
-		W: int32(DisplayScale),
-		H: int32(DisplayScale),
+	isResized := hudImage == nil || hudImage.Rect.Dx() != int(width) || hudImage.Rect.Dy() != int(height)
+	if hudTexture == nil || isResized || time.Since(lastHUDDraw) >= time.Second/HUD_REFRESH_RATE {
+		imageTexture(&hudTexture, HUDImage(int(width), int(height)))
+		hudTexture.SetBlendMode(sdl.BLENDMODE_BLEND)
+		lastHUDDraw = time.Now()
 	}
-	Renderer.FillRect(&pixelRect)
+	Renderer.Copy(hudTexture, nil, nil)
+}
+
+// Display rectangle in the renderer output, which follows the window size
+func currentDisplayRect() sdl.Rect {
+	width, height, err := Renderer.GetOutputSize()
+	if err != nil {
+		width, height = Window.GetSize()
+	}
+	x, y, w, h := displayLayout(width, height)
+	return sdl.Rect{X: x, Y: y, W: w, H: h}
+}
+
+func ToggleFullscreen() {
+	IsFullscreen = !IsFullscreen
+	var flags uint32
+	if IsFullscreen {
+		flags = sdl.WINDOW_FULLSCREEN_DESKTOP
+	}
+	if err := Window.SetFullscreen(flags); err != nil {
+		log.Print("Failed to toggle fullscreen! ", err)
+		IsFullscreen = !IsFullscreen
+	}
+}
+
+// Pixel of PIXELFORMAT_ARGB8888
+func argb(color *color.RGBA) uint32 {
+	return uint32(color.A)<<24 | uint32(color.R)<<16 | uint32(color.G)<<8 | uint32(color.B)
 }
-func DrawDisplayBorder() {
-	setDrawColor(&BackgroundColor)
-	setDrawColor(&DisplayBorderColor)
+func DrawDisplayBorder(displayRect *sdl.Rect) {
+	setDrawColor(&ActivePalette.Border)
 	borderRect := sdl.Rect{
-		X: DISPLAY_PADDING - BORDER_PADDING,
-		Y: DISPLAY_PADDING - BORDER_PADDING,
-		W: int32(WIDTH*DisplayScale) + 2*BORDER_PADDING,
-		H: int32(HEIGHT*DisplayScale) + 2*BORDER_PADDING,
+		X: displayRect.X - BORDER_PADDING,
+		Y: displayRect.Y - BORDER_PADDING,
+		W: displayRect.W + 2*BORDER_PADDING,
+		H: displayRect.H + 2*BORDER_PADDING,
 	}
 	Renderer.FillRect(&borderRect)
 
@@ -1,10 +1,35 @@
 package chip8
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mehmetumit/CHIP-8/chip8/octo"
 )
 
 func ReadFile(filePath string) ([]byte, error) {
 	data, err := os.ReadFile(filePath)
 	return data, err
 }
+
+// Read a rom image, Octo sources (.8o) are compiled
+func ReadRom(filePath string) ([]byte, error) {
+	if strings.EqualFold(filepath.Ext(filePath), ".8o") {
+		return octo.CompileFile(filePath, START_ADDRESS)
+	}
+	return ReadFile(filePath)
+}
+
+// Rom image of a file which was read elsewhere, e.g. by the browser, Octo sources (.8o) are compiled
+func DecodeRom(name string, data []byte) ([]byte, error) {
+	if !strings.EqualFold(filepath.Ext(name), ".8o") {
+		return data, nil
+	}
+	rom, err := octo.Compile(string(data), START_ADDRESS)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return rom, nil
+}
@@ -0,0 +1,134 @@
+package chip8
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Directory of the screenshots taken with the hotkey
+var ScreenshotDir = "."
+
+// Raw screenshots have one pixel per display pixel in the palette colours, scaled ones are
+// what the window shows with the effects at the display scale
+func ScreenshotImage(isScaled bool) *image.RGBA {
+	if !isScaled {
+		return DisplayImage(&chip8.Display)
+	}
+	img := PostProcess(presentedImage(currentPresentedDisplay()))
+	factor := 1
+	if DisplayScale > 0 {
+		factor = int(WIDTH*DisplayScale) / img.Rect.Dx()
+	}
+	if factor < 1 {
+		factor = 1
+	}
+	return scaleNearest(img, factor)
+}
+
+// Rom, its hash and the frame for the text chunks of the screenshots
+func ScreenshotText() map[string]string {
+	text := map[string]string{
+		"Software": "CHIP-8",
+		"Frame":    strconv.FormatUint(chip8.Frame, 10),
+	}
+	if chip8.Rom != "" {
+		text["ROM"] = filepath.Base(chip8.Rom)
+		text["ROM SHA-1"] = chip8.RomHash
+	}
+	return text
+}
+
+// Write the screenshot of the machine as png
+func WriteScreenshot(w io.Writer, isScaled bool) error {
+	return WritePNG(w, ScreenshotImage(isScaled), ScreenshotText())
+}
+
+// Write the screenshot to a timestamped file named after the rom in ScreenshotDir
+// and return its path
+func Screenshot(isScaled bool) (string, error) {
+	name := "chip8"
+	if chip8.Rom != "" {
+		name = strings.TrimSuffix(filepath.Base(chip8.Rom), filepath.Ext(chip8.Rom))
+	}
+	path := filepath.Join(ScreenshotDir, fmt.Sprintf("%s-%s.png", name, time.Now().Format("20060102-150405.000")))
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := WriteScreenshot(file, isScaled); err != nil {
+		file.Close()
+		return "", err
+	}
+	return path, file.Close()
+}
+
+// Encode the image as png with a tEXt chunk per entry, in the order of the keywords
+func WritePNG(w io.Writer, img image.Image, text map[string]string) error {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		return err
+	}
+	// The signature and the IHDR chunk come first, the text chunks follow them
+	const HEADER_SIZE = 8 + 4 + 4 + 13 + 4
+	data := encoded.Bytes()
+	if _, err := w.Write(data[:HEADER_SIZE]); err != nil {
+		return err
+	}
+	keywords := make([]string, 0, len(text))
+	for keyword := range text {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+	for _, keyword := range keywords {
+		if err := writePNGChunk(w, "tEXt", []byte(keyword+"\x00"+text[keyword])); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(data[HEADER_SIZE:])
+	return err
+}
+
+func writePNGChunk(w io.Writer, chunkType string, data []byte) error {
+	chunk := make([]byte, 0, 12+len(data))
+	chunk = binary.BigEndian.AppendUint32(chunk, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	_, err := w.Write(chunk)
+	return err
+}
+
+// Text chunks of a png, keyword to text
+func ReadPNGText(r io.Reader) (map[string]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 || string(data[1:4]) != "PNG" {
+		return nil, fmt.Errorf("Not a png file")
+	}
+	text := map[string]string{}
+	for offset := 8; offset+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		if offset+12+length > len(data) {
+			return nil, fmt.Errorf("Truncated png chunk at %d", offset)
+		}
+		if string(data[offset+4:offset+8]) == "tEXt" {
+			keyword, value, _ := strings.Cut(string(data[offset+8:offset+8+length]), "\x00")
+			text[keyword] = value
+		}
+		offset += 12 + length
+	}
+	return text, nil
+}
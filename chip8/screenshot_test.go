@@ -0,0 +1,77 @@
+package chip8
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"image/png"
+	"os"
+	"testing"
+)
+
+func TestScreenshot(t *testing.T) {
+	defer func(scale int32) { DisplayScale = scale }(DisplayScale)
+	frontend = headlessFrontend{}
+	reset()
+	if err := loadRom("../roms/IBM-Logo.ch8"); err != nil {
+		t.Fatal(err)
+	}
+	loadFonts()
+	for i := 0; i < 100; i++ {
+		cycle()
+	}
+	rom, err := os.ReadFile("../roms/IBM-Logo.ch8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw bytes.Buffer
+	if err := WriteScreenshot(&raw, false); err != nil {
+		t.Fatal(err)
+	}
+	text, err := ReadPNGText(bytes.NewReader(raw.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"Software":  "CHIP-8",
+		"ROM":       "IBM-Logo.ch8",
+		"ROM SHA-1": fmt.Sprintf("%x", sha1.Sum(rom)),
+		"Frame":     "100",
+	}
+	for keyword, value := range expected {
+		if text[keyword] != value {
+			t.Errorf("%s is %q, expected %q", keyword, text[keyword], value)
+		}
+	}
+	// The decoder checks the checksums of the inserted chunks
+	img, err := png.Decode(bytes.NewReader(raw.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != WIDTH || img.Bounds().Dy() != HEIGHT {
+		t.Fatalf("raw screenshot is %v", img.Bounds())
+	}
+	for x := 0; x < WIDTH; x++ {
+		for y := 0; y < HEIGHT; y++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			expected := ActivePalette.Color(chip8.Display[x][y])
+			if uint8(r>>8) != expected.R || uint8(g>>8) != expected.G || uint8(b>>8) != expected.B {
+				t.Fatalf("pixel %d,%d differs from the display", x, y)
+			}
+		}
+	}
+
+	DisplayScale = 4
+	var scaled bytes.Buffer
+	if err := WriteScreenshot(&scaled, true); err != nil {
+		t.Fatal(err)
+	}
+	config, err := png.DecodeConfig(bytes.NewReader(scaled.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 4*WIDTH || config.Height != 4*HEIGHT {
+		t.Errorf("scaled screenshot is %dx%d", config.Width, config.Height)
+	}
+}
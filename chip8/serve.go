@@ -0,0 +1,350 @@
+package chip8
+
+import (
+	_ "embed"
+	"fmt"
+	"image/color"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Messages to the viewers, the first byte is the type
+const (
+	// The whole packed display
+	STREAM_FRAME = iota
+	// Pairs of index and value of the packed display bytes which changed since the last message
+	STREAM_DIFF
+	// 1 when the beep starts, 0 when it stops
+	STREAM_SOUND
+	// 1 for the viewer in control and 0 for the others, then the number of viewers in 2 bytes
+	STREAM_ROLE
+	// RGB of the background, the pixels and the border
+	STREAM_PALETTE
+)
+
+// Messages from the viewers, only the ones of the controller are handled
+const (
+	// Followed by the index of the key
+	STREAM_KEY_DOWN = iota
+	STREAM_KEY_UP
+	// Hand the control over to the next viewer
+	STREAM_PASS
+)
+
+// 8 pixels per byte, rows from the top and the leftmost pixel in the high bit
+const PACKED_DISPLAY_SIZE = WIDTH * HEIGHT / 8
+
+type PackedDisplay [PACKED_DISPLAY_SIZE]byte
+
+// Viewers which fall this many messages behind are dropped
+const STREAM_QUEUE_SIZE = 256
+
+//go:embed serve.html
+var streamPage []byte
+
+func PackDisplay(display *Display) PackedDisplay {
+	var packed PackedDisplay
+	for y := 0; y < HEIGHT; y++ {
+		for x := 0; x < WIDTH; x++ {
+			if display[x][y] != 0 {
+				packed[(y*WIDTH+x)/8] |= 0x80 >> (x % 8)
+			}
+		}
+	}
+	return packed
+}
+
+// Message of the changed bytes, or of the whole display when that is shorter
+func displayDiff(previous *PackedDisplay, current *PackedDisplay) []byte {
+	message := []byte{STREAM_DIFF}
+	for i := range current {
+		if current[i] != previous[i] {
+			message = append(message, byte(i), current[i])
+		}
+	}
+	if len(message) > 1+PACKED_DISPLAY_SIZE {
+		return append([]byte{STREAM_FRAME}, current[:]...)
+	}
+	return message
+}
+
+type streamViewer struct {
+	ws   *wsConn
+	name string
+	send chan []byte
+	// Dropped for falling behind, it is removed when its connection ends
+	isDropped bool
+}
+
+type streamInput struct {
+	viewer *streamViewer
+	data   []byte
+}
+
+// Headless frontend streaming the display to the websocket viewers of its web page
+// Viewers join, leave and send keys on their own goroutines, the emulation loop handles
+// them between cycles like the gdb packets
+type StreamServer struct {
+	listener net.Listener
+	// In the order they joined, the first one controls the machine
+	viewers []*streamViewer
+	joins   chan *streamViewer
+	leaves  chan *streamViewer
+	inputs  chan streamInput
+	// The display of the last render and the one the viewers have
+	frame    PackedDisplay
+	sent     PackedDisplay
+	lastSent time.Time
+	isBeep   bool
+}
+
+// Listen on a tcp address or on a unix socket with the unix: prefix
+func NewStreamServer(address string) (*StreamServer, error) {
+	network := "tcp"
+	if strings.HasPrefix(address, "unix:") {
+		network, address = "unix", strings.TrimPrefix(address, "unix:")
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	s := &StreamServer{
+		listener: listener,
+		joins:    make(chan *streamViewer),
+		leaves:   make(chan *streamViewer),
+		inputs:   make(chan streamInput),
+	}
+	go http.Serve(listener, s)
+	return s, nil
+}
+
+// Run the rom headless and stream it to the viewers of the page served on the address
+func Serve(romPath string, address string, speed uint8) {
+	server, err := NewStreamServer(address)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	frontend = server
+	setup(romPath, 0, speed)
+	if server.listener.Addr().Network() == "tcp" {
+		fmt.Printf("Serving on http://%s\n", server.listener.Addr())
+	} else {
+		fmt.Println("Serving on", server.listener.Addr())
+	}
+	loop()
+}
+
+// The page on / and the websocket of the viewers on /stream
+func (s *StreamServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(streamPage)
+	case "/stream":
+		s.stream(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Messages are written on their own goroutine, the handler reads the input of the viewer
+// until its connection ends
+func (s *StreamServer) stream(w http.ResponseWriter, r *http.Request) {
+	if !isSameOrigin(r) {
+		http.Error(w, "Cross-origin viewers are not allowed", http.StatusForbidden)
+		return
+	}
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	viewer := &streamViewer{ws: ws, name: r.RemoteAddr, send: make(chan []byte, STREAM_QUEUE_SIZE)}
+	go func() {
+		var err error
+		for message := range viewer.send {
+			if err == nil {
+				err = ws.WriteMessage(WS_BINARY, message)
+			}
+		}
+		ws.Close()
+	}()
+	s.joins <- viewer
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			break
+		}
+		s.inputs <- streamInput{viewer, data}
+	}
+	s.leaves <- viewer
+}
+
+// Browsers send the origin of the page, so other sites can't take the controls of
+// the viewers, clients which are not browsers send none
+func isSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+func (s *StreamServer) Start(display *Display) {
+	s.Render(display)
+}
+
+func (s *StreamServer) Clear(display *Display) {
+	for i := range display {
+		display[i] = [HEIGHT]uint8{}
+	}
+}
+
+// The viewers get the changes at most once per frame, when the events are handled
+func (s *StreamServer) Render(display *Display) {
+	s.frame = PackDisplay(display)
+}
+
+func (s *StreamServer) PlayAudio() {
+	if chip8.SoundTimer > 0 {
+		s.setBeep(true)
+	}
+}
+
+func (s *StreamServer) PauseAudio() {
+	s.setBeep(false)
+}
+
+func (s *StreamServer) setBeep(isBeep bool) {
+	if s.isBeep != isBeep {
+		s.isBeep = isBeep
+		s.broadcast(s.soundMessage())
+	}
+}
+
+func (s *StreamServer) soundMessage() []byte {
+	if s.isBeep {
+		return []byte{STREAM_SOUND, 1}
+	}
+	return []byte{STREAM_SOUND, 0}
+}
+
+func (s *StreamServer) HandleEvents(quit func(), keypad *Keypad) {
+	for isPending := true; isPending; {
+		select {
+		case viewer := <-s.joins:
+			s.join(viewer)
+		case viewer := <-s.leaves:
+			s.leave(viewer, keypad)
+		case input := <-s.inputs:
+			s.input(input, keypad)
+		default:
+			isPending = false
+		}
+	}
+	if s.frame != s.sent && time.Since(s.lastSent) >= time.Second/FRAME_RATE {
+		s.broadcast(displayDiff(&s.sent, &s.frame))
+		s.sent = s.frame
+		s.lastSent = time.Now()
+	}
+}
+
+// New viewers get the colours, the display the others have and the sound
+func (s *StreamServer) join(viewer *streamViewer) {
+	log.Printf("Viewer %s joined", viewer.name)
+	s.viewers = append(s.viewers, viewer)
+	palette := []byte{STREAM_PALETTE}
+	for _, c := range []*color.RGBA{&ActivePalette.Colors[0], &ActivePalette.Colors[1], &ActivePalette.Border} {
+		palette = append(palette, c.R, c.G, c.B)
+	}
+	s.queue(viewer, palette)
+	s.queue(viewer, append([]byte{STREAM_FRAME}, s.sent[:]...))
+	s.queue(viewer, s.soundMessage())
+	s.sendRoles()
+}
+
+func (s *StreamServer) leave(viewer *streamViewer, keypad *Keypad) {
+	for i, v := range s.viewers {
+		if v != viewer {
+			continue
+		}
+		log.Printf("Viewer %s left", viewer.name)
+		s.viewers = append(s.viewers[:i], s.viewers[i+1:]...)
+		close(viewer.send)
+		// The keys of the controller are released when the control passes on
+		if i == 0 {
+			*keypad = Keypad{}
+		}
+		s.sendRoles()
+		return
+	}
+}
+
+func (s *StreamServer) input(input streamInput, keypad *Keypad) {
+	if len(s.viewers) == 0 || input.viewer != s.viewers[0] || len(input.data) == 0 {
+		return
+	}
+	switch input.data[0] {
+	case STREAM_KEY_DOWN, STREAM_KEY_UP:
+		if len(input.data) != 2 || input.data[1] >= uint8(len(keypad)) {
+			return
+		}
+		index, isDown := input.data[1], input.data[0] == STREAM_KEY_DOWN
+		if keypad[index] != isDown {
+			if isDown {
+				tracef(TRACE_INPUT, TRACE_INFO, "key %X down", index)
+			} else {
+				tracef(TRACE_INPUT, TRACE_INFO, "key %X up", index)
+			}
+		}
+		keypad[index] = isDown
+	case STREAM_PASS:
+		s.viewers = append(s.viewers[1:], input.viewer)
+		*keypad = Keypad{}
+		s.sendRoles()
+	}
+}
+
+func (s *StreamServer) sendRoles() {
+	for i, viewer := range s.viewers {
+		role := byte(0)
+		if i == 0 {
+			role = 1
+		}
+		s.queue(viewer, []byte{STREAM_ROLE, role, byte(len(s.viewers) >> 8), byte(len(s.viewers))})
+	}
+}
+
+func (s *StreamServer) broadcast(message []byte) {
+	for _, viewer := range s.viewers {
+		s.queue(viewer, message)
+	}
+}
+
+// The emulation doesn't wait for slow viewers, their connection is closed instead
+func (s *StreamServer) queue(viewer *streamViewer, message []byte) {
+	if viewer.isDropped {
+		return
+	}
+	select {
+	case viewer.send <- message:
+	default:
+		log.Printf("Viewer %s fell behind, dropping it", viewer.name)
+		viewer.isDropped = true
+		viewer.ws.Close()
+	}
+}
+
+func (s *StreamServer) Close() {
+	s.listener.Close()
+	for _, viewer := range s.viewers {
+		viewer.ws.Close()
+	}
+}
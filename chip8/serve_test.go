@@ -0,0 +1,176 @@
+package chip8
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDisplayDiff(t *testing.T) {
+	var display Display
+	display[0][0] = 1
+	display[9][1] = 1
+	packed := PackDisplay(&display)
+	if packed[0] != 0x80 || packed[9] != 0x40 {
+		t.Fatalf("Packed bytes 0 and 9 are %02x %02x, want 80 40", packed[0], packed[9])
+	}
+	var empty PackedDisplay
+	if diff := displayDiff(&empty, &packed); !bytes.Equal(diff, []byte{STREAM_DIFF, 0, 0x80, 9, 0x40}) {
+		t.Errorf("Diff is %v", diff)
+	}
+	for i := range packed {
+		packed[i] = 0xFF
+	}
+	if diff := displayDiff(&empty, &packed); diff[0] != STREAM_FRAME || len(diff) != 1+PACKED_DISPLAY_SIZE {
+		t.Errorf("A full change gives the type %d with %d bytes, want the whole frame", diff[0], len(diff))
+	}
+}
+
+// Websocket client of the tests, frames of the clients are masked
+type testViewer struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Send the websocket handshake of /stream, without an Origin header if origin is empty
+func handshake(t *testing.T, address string, origin string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	header := ""
+	if origin != "" {
+		header = "Origin: " + origin + "\r\n"
+	}
+	fmt.Fprintf(conn, "GET /stream HTTP/1.1\r\nHost: %s\r\n%sUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", address, header, key)
+	r := bufio.NewReader(conn)
+	response, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, r, response
+}
+
+func dialViewer(t *testing.T, address string) *testViewer {
+	conn, r, response := handshake(t, address, "")
+	if response.StatusCode != http.StatusSwitchingProtocols || response.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Handshake answered %s with accept %q", response.Status, response.Header.Get("Sec-WebSocket-Accept"))
+	}
+	return &testViewer{conn, r}
+}
+
+func (v *testViewer) send(t *testing.T, payload ...byte) {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | WS_BINARY, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := v.conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Read messages until one of the type arrives
+func (v *testViewer) receive(t *testing.T, messageType byte) []byte {
+	v.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(v.r, header[:]); err != nil {
+			t.Fatal(err)
+		}
+		length := int(header[1] & 0x7F)
+		if length == 126 {
+			var extended [2]byte
+			io.ReadFull(v.r, extended[:])
+			length = int(binary.BigEndian.Uint16(extended[:]))
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(v.r, payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload[0] == messageType {
+			return payload
+		}
+	}
+}
+
+func TestStreamServer(t *testing.T) {
+	s := &StreamServer{
+		joins:  make(chan *streamViewer),
+		leaves: make(chan *streamViewer),
+		inputs: make(chan streamInput),
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	// The emulation loop of the test only handles the events
+	var keypad Keypad
+	keys := make(chan Keypad)
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case keys <- keypad:
+			default:
+				s.HandleEvents(func() {}, &keypad)
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+	address := strings.TrimPrefix(server.URL, "http://")
+	controller := dialViewer(t, address)
+	if role := controller.receive(t, STREAM_ROLE); !bytes.Equal(role, []byte{STREAM_ROLE, 1, 0, 1}) {
+		t.Fatalf("The first viewer got the role %v", role)
+	}
+	viewer := dialViewer(t, address)
+	if role := viewer.receive(t, STREAM_ROLE); !bytes.Equal(role, []byte{STREAM_ROLE, 0, 0, 2}) {
+		t.Fatalf("The second viewer got the role %v", role)
+	}
+	viewer.send(t, STREAM_KEY_DOWN, 0x5)
+	controller.send(t, STREAM_KEY_DOWN, 0xA)
+	deadline := time.Now().Add(5 * time.Second)
+	for pressed := <-keys; !pressed[0xA]; pressed = <-keys {
+		if pressed[0x5] || time.Now().After(deadline) {
+			t.Fatalf("Keypad is %v, want only A down from the controller", pressed)
+		}
+	}
+	// The control and the keys go with the controller
+	controller.conn.Close()
+	if role := viewer.receive(t, STREAM_ROLE); !bytes.Equal(role, []byte{STREAM_ROLE, 1, 0, 1}) {
+		t.Fatalf("The remaining viewer got the role %v", role)
+	}
+	if pressed := <-keys; pressed[0xA] {
+		t.Error("Key A is still down after the controller left")
+	}
+}
+
+func TestStreamOrigin(t *testing.T) {
+	s := &StreamServer{joins: make(chan *streamViewer, 1), leaves: make(chan *streamViewer, 1), inputs: make(chan streamInput)}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+	for origin, status := range map[string]int{
+		"http://" + address:           http.StatusSwitchingProtocols,
+		"http://evil.example":         http.StatusForbidden,
+		"http://" + address + ".evil": http.StatusForbidden,
+		"null":                        http.StatusForbidden,
+	} {
+		conn, _, response := handshake(t, address, origin)
+		conn.Close()
+		if response.StatusCode != status {
+			t.Errorf("Origin %s is answered with %s, want %d", origin, response.Status, status)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package chip8
+
+import (
+	"fmt"
+	"image/color"
+	"time"
+)
+
+// Sprites are erased and redrawn with XOR, presenting every instruction shows the gaps
+// between them, the smoothing modes present what the eye would have seen on a CRT instead
+const (
+	// Present after every instruction
+	SMOOTHING_OFF = "off"
+	// Present at most once per frame
+	SMOOTHING_FRAME = "frame"
+	// Present the pixels lit in any of the last frames
+	SMOOTHING_BLEND = "blend"
+	// Pixels fade out over the fade time after they are cleared
+	SMOOTHING_PHOSPHOR = "phosphor"
+)
+
+// Presented frames per second of the smoothing modes
+const FRAME_RATE = 60
+
+// Brightness of a fully lit pixel
+const FULL_BRIGHTNESS = 255
+
+type SmoothingConfig struct {
+	Mode string
+	// Frames blended by SMOOTHING_BLEND
+	BlendFrames int
+	// Time a cleared pixel takes to go dark in SMOOTHING_PHOSPHOR
+	Fade time.Duration
+}
+
+var Smoothing = SmoothingConfig{Mode: SMOOTHING_OFF, BlendFrames: 3, Fade: 100 * time.Millisecond}
+
+var (
+	// Display given to the frontend, pixels keep their value while they fade out
+	presentedDisplay Display
+	// Brightness of the presented pixels, only phosphor dims them
+	DisplayBrightness = fullBrightness()
+	blendHistory      []Display
+	lastPresent       time.Time
+)
+
+func fullBrightness() (brightness [WIDTH][HEIGHT]uint8) {
+	for x := range brightness {
+		for y := range brightness[x] {
+			brightness[x][y] = FULL_BRIGHTNESS
+		}
+	}
+	return brightness
+}
+
+func SetSmoothing(config SmoothingConfig) error {
+	switch config.Mode {
+	case SMOOTHING_OFF, SMOOTHING_FRAME, SMOOTHING_BLEND, SMOOTHING_PHOSPHOR:
+	default:
+		return fmt.Errorf("Unknown smoothing %q, use %s, %s, %s or %s", config.Mode,
+			SMOOTHING_OFF, SMOOTHING_FRAME, SMOOTHING_BLEND, SMOOTHING_PHOSPHOR)
+	}
+	if config.BlendFrames < 1 {
+		return fmt.Errorf("Blend frames must be at least 1, not %d", config.BlendFrames)
+	}
+	if config.Fade <= 0 {
+		return fmt.Errorf("Fade time must be positive, not %v", config.Fade)
+	}
+	Smoothing = config
+	resetSmoothing()
+	return nil
+}
+
+// Forget the presented frames, called when the machine is cleared
+func resetSmoothing() {
+	presentedDisplay = Display{}
+	DisplayBrightness = fullBrightness()
+	blendHistory = nil
+	lastPresent = time.Time{}
+}
+
+// Time the machine ran, a cycle takes Speed milliseconds so headless runs and recordings
+// see the same frames as the window
+func emulatedTime() time.Duration {
+	speed := time.Duration(chip8.Speed)
+	if speed == 0 {
+		speed = 1
+	}
+	return time.Duration(chip8.Frame) * speed * time.Millisecond
+}
+
+// Render the display if a frame passed since the last one, isForced renders it anyway
+func present(isForced bool) {
+	if Smoothing.Mode == SMOOTHING_OFF {
+		frontend.Render(&chip8.Display)
+		return
+	}
+	if presentAt(time.Time{}.Add(emulatedTime()), isForced) {
+		frontend.Render(&presentedDisplay)
+	}
+}
+
+// Display the frontend shows
+func currentPresentedDisplay() *Display {
+	if Smoothing.Mode == SMOOTHING_OFF {
+		return &chip8.Display
+	}
+	return &presentedDisplay
+}
+
+// Update the presented display at the time, false if it is not time for a frame yet
+func presentAt(now time.Time, isForced bool) bool {
+	elapsed := now.Sub(lastPresent)
+	if elapsed < time.Second/FRAME_RATE && !isForced {
+		return false
+	}
+	lastPresent = now
+	switch Smoothing.Mode {
+	case SMOOTHING_FRAME:
+		presentedDisplay = chip8.Display
+	case SMOOTHING_BLEND:
+		blendHistory = append(blendHistory, chip8.Display)
+		if len(blendHistory) > Smoothing.BlendFrames {
+			blendHistory = blendHistory[len(blendHistory)-Smoothing.BlendFrames:]
+		}
+		// The newest frame wins where several of them lit the pixel
+		presentedDisplay = Display{}
+		for _, frame := range blendHistory {
+			for x := range frame {
+				for y, pixel := range frame[x] {
+					if pixel != 0 {
+						presentedDisplay[x][y] = pixel
+					}
+				}
+			}
+		}
+	case SMOOTHING_PHOSPHOR:
+		// The first frame after a reset comes long after the zero time
+		if elapsed > Smoothing.Fade {
+			elapsed = Smoothing.Fade
+		}
+		// Rounded up so the pixel is dark when the fade time passed
+		fade := (int64(FULL_BRIGHTNESS)*int64(elapsed) + int64(Smoothing.Fade) - 1) / int64(Smoothing.Fade)
+		for x := range chip8.Display {
+			for y, pixel := range chip8.Display[x] {
+				switch {
+				case pixel != 0:
+					presentedDisplay[x][y] = pixel
+					DisplayBrightness[x][y] = FULL_BRIGHTNESS
+				case int64(DisplayBrightness[x][y]) > fade && presentedDisplay[x][y] != 0:
+					DisplayBrightness[x][y] -= uint8(fade)
+				default:
+					presentedDisplay[x][y] = 0
+					DisplayBrightness[x][y] = 0
+				}
+			}
+		}
+	}
+	return true
+}
+
+// Colour of the pixel value dimmed towards the background
+func (p *Palette) Blend(pixel uint8, brightness uint8) color.RGBA {
+	lit, background := p.Color(pixel), p.Colors[0]
+	mix := func(lit uint8, background uint8) uint8 {
+		return uint8((int(lit)*int(brightness) + int(background)*(FULL_BRIGHTNESS-int(brightness))) / FULL_BRIGHTNESS)
+	}
+	return color.RGBA{R: mix(lit.R, background.R), G: mix(lit.G, background.G), B: mix(lit.B, background.B), A: 255}
+}
@@ -0,0 +1,70 @@
+package chip8
+
+import (
+	"testing"
+	"time"
+)
+
+// Present the frames one frame time apart starting at the returned time
+func presentFrames(t *testing.T, mode string, frames ...Display) time.Time {
+	t.Helper()
+	if err := SetSmoothing(SmoothingConfig{Mode: mode, BlendFrames: 2, Fade: 4 * time.Second / FRAME_RATE}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(0, 0)
+	for _, frame := range frames {
+		now = now.Add(time.Second / FRAME_RATE)
+		chip8.Display = frame
+		if !presentAt(now, false) {
+			t.Fatal("frame not presented")
+		}
+	}
+	return now
+}
+
+func displayWith(pixels ...[2]int) (display Display) {
+	for _, pixel := range pixels {
+		display[pixel[0]][pixel[1]] = 1
+	}
+	return display
+}
+
+func TestSmoothing(t *testing.T) {
+	defer SetSmoothing(Smoothing)
+	defer func() { chip8.Display = Display{} }()
+
+	now := presentFrames(t, SMOOTHING_FRAME, displayWith([2]int{1, 1}))
+	chip8.Display = displayWith([2]int{2, 2})
+	if presentAt(now.Add(time.Millisecond), false) {
+		t.Error("presented twice within a frame")
+	}
+	if !presentAt(now.Add(time.Millisecond), true) || presentedDisplay != chip8.Display {
+		t.Error("forced present did not show the display")
+	}
+
+	// The sprite moves right, the oldest frame drops out of the blend
+	presentFrames(t, SMOOTHING_BLEND, displayWith([2]int{0, 0}), displayWith([2]int{1, 0}), displayWith([2]int{2, 0}))
+	if presentedDisplay != displayWith([2]int{1, 0}, [2]int{2, 0}) {
+		t.Errorf("blend shows %v", DisplayRows(&presentedDisplay)[0][:4])
+	}
+
+	// The cleared pixel loses a quarter of its brightness per frame, rounded up
+	presentFrames(t, SMOOTHING_PHOSPHOR, displayWith([2]int{0, 0}), Display{}, Display{})
+	if presentedDisplay[0][0] != 1 || DisplayBrightness[0][0] != FULL_BRIGHTNESS-2*64 {
+		t.Errorf("phosphor pixel %d at brightness %d", presentedDisplay[0][0], DisplayBrightness[0][0])
+	}
+	presentFrames(t, SMOOTHING_PHOSPHOR, displayWith([2]int{0, 0}), Display{}, Display{}, Display{}, Display{})
+	if presentedDisplay[0][0] != 0 || DisplayBrightness[0][0] != 0 {
+		t.Errorf("phosphor pixel %d at brightness %d after the fade", presentedDisplay[0][0], DisplayBrightness[0][0])
+	}
+}
+
+func TestPaletteBlend(t *testing.T) {
+	palette := Palettes["classic"]
+	if palette.Blend(1, FULL_BRIGHTNESS) != palette.Colors[1] || palette.Blend(1, 0) != palette.Colors[0] {
+		t.Error("blend does not reach the pixel and the background colours")
+	}
+	if half := palette.Blend(1, 128); half.R != 128 || half.G != 128 || half.B != 128 {
+		t.Errorf("half brightness white on black is %v", half)
+	}
+}
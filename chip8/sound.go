@@ -1,3 +1,5 @@
+//go:build !nosdl && !js
+
 package chip8
 
 // typedef unsigned char Uint8;
@@ -6,7 +8,6 @@ import "C"
 import (
 	"github.com/veandco/go-sdl2/sdl"
 	"log"
-	"reflect"
 	"unsafe"
 )
 
@@ -20,15 +21,14 @@ var (
 //export OnAudioPlayback
 func OnAudioPlayback(userdata unsafe.Pointer, stream *C.Uint8, length C.int) {
 	n := int(length)
-	hdr := reflect.SliceHeader{Data: uintptr(unsafe.Pointer(stream)), Len: n, Cap: n}
-	buf := *(*[]byte)(unsafe.Pointer(&hdr))
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(stream)), n)
 	for i := 0; i < n; i++ {
 		buf[i] = audio[offset]
 		offset = (offset + 1) % len(audio) // Increase audio offset and loop when it reaches the end
 	}
 }
 
-func init() {
+func openAudio() {
 	var err error
 
 	audio, spec = sdl.LoadWAV("./sounds/beep.wav")
@@ -45,12 +45,12 @@ func ClouseAudio() {
 	sdl.CloseAudioDevice(dev)
 }
 func PlayAudio() {
-	log.Print("Playing audio...")
+	tracef(TRACE_AUDIO, TRACE_INFO, "play")
 	// Start playback audio of device
 	sdl.PauseAudioDevice(dev, false)
 }
 func PauseAudio() {
-	log.Print("Audio paused!")
+	tracef(TRACE_AUDIO, TRACE_INFO, "pause")
 	// Stop playback audio of device
 	sdl.PauseAudioDevice(dev, true)
 }
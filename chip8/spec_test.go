@@ -0,0 +1,379 @@
+package chip8
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Machine state of the specification cases, written in a small language of
+// space separated assignments:
+//
+//	V0-VF, I, PC, SP, S0-SF (stack), DT, ST    V1=0x12 I=0x300
+//	M[address]=bytes                           M[0x300]=0xF0,0x90
+//	D[x,y]=pixel                               D[0,0]=1
+//	K=pressed keys                             K=5A
+//	FAULT                                      the machine stopped with an error
+type specState struct {
+	registers Registers
+	index     IndexRegister
+	pc        ProgramCounter
+	sp        StackPointer
+	stack     ProgramStack
+	delay     DelayTimer
+	sound     SoundTimer
+	memory    Memory
+	display   Display
+	keypad    Keypad
+	isFault   bool
+}
+
+type specCase struct {
+	name   string
+	before string
+	opcode Opcode
+	// Changes of the state, PC is advanced by 2 unless it is set
+	after string
+	// Quirks the case runs under, every profile when nil
+	quirks func(q Quirks) bool
+}
+
+func parseSpecNumber(s string) (int, error) {
+	value, err := strconv.ParseUint(s, 0, 16)
+	return int(value), err
+}
+
+// Apply the assignments of the text to the state
+func (s *specState) parse(text string) error {
+	for _, field := range strings.Fields(text) {
+		if field == "FAULT" {
+			s.isFault = true
+			continue
+		}
+		name, value, isAssignment := strings.Cut(field, "=")
+		if !isAssignment {
+			return fmt.Errorf("%q is not an assignment", field)
+		}
+		switch {
+		case strings.HasPrefix(name, "M[") && strings.HasSuffix(name, "]"):
+			address, err := parseSpecNumber(name[2 : len(name)-1])
+			if err != nil {
+				return fmt.Errorf("%s: %v", field, err)
+			}
+			for i, text := range strings.Split(value, ",") {
+				b, err := parseSpecNumber(text)
+				if err != nil {
+					return fmt.Errorf("%s: %v", field, err)
+				}
+				s.memory[(address+i)%len(s.memory)] = uint8(b)
+			}
+			continue
+		case strings.HasPrefix(name, "D[") && strings.HasSuffix(name, "]"):
+			xText, yText, _ := strings.Cut(name[2:len(name)-1], ",")
+			x, err := strconv.Atoi(xText)
+			if err != nil {
+				return fmt.Errorf("%s: %v", field, err)
+			}
+			y, err := strconv.Atoi(yText)
+			if err != nil {
+				return fmt.Errorf("%s: %v", field, err)
+			}
+			pixel, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s: %v", field, err)
+			}
+			s.display[x][y] = uint8(pixel)
+			continue
+		case name == "K":
+			s.keypad = Keypad{}
+			for _, key := range value {
+				index, err := strconv.ParseUint(string(key), 16, 4)
+				if err != nil {
+					return fmt.Errorf("%s: %v", field, err)
+				}
+				s.keypad[index] = true
+			}
+			continue
+		}
+		number, err := parseSpecNumber(value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", field, err)
+		}
+		switch {
+		case len(name) == 2 && name[0] == 'V':
+			index, err := strconv.ParseUint(name[1:], 16, 4)
+			if err != nil {
+				return fmt.Errorf("%s: %v", field, err)
+			}
+			s.registers[index] = Register(number)
+		case len(name) == 2 && name[0] == 'S' && name != "SP" && name != "ST":
+			index, err := strconv.ParseUint(name[1:], 16, 4)
+			if err != nil {
+				return fmt.Errorf("%s: %v", field, err)
+			}
+			s.stack[index] = ProgramCounter(number)
+		case name == "I":
+			s.index = IndexRegister(number)
+		case name == "PC":
+			s.pc = ProgramCounter(number)
+		case name == "SP":
+			s.sp = StackPointer(number)
+		case name == "DT":
+			s.delay = DelayTimer(number)
+		case name == "ST":
+			s.sound = SoundTimer(number)
+		default:
+			return fmt.Errorf("Unknown name %q", name)
+		}
+	}
+	return nil
+}
+
+func captureSpecState() specState {
+	return specState{
+		registers: chip8.Cpu.Registers,
+		index:     chip8.Cpu.IndexRegister,
+		pc:        chip8.Cpu.ProgramCounter,
+		sp:        chip8.Cpu.StackPointer,
+		stack:     chip8.Cpu.ProgramStack,
+		delay:     chip8.DelayTimer,
+		sound:     chip8.SoundTimer,
+		memory:    chip8.Cpu.Memory,
+		display:   chip8.Display,
+		keypad:    chip8.Keypad,
+		isFault:   chip8.Fault != nil,
+	}
+}
+
+func (s *specState) load() {
+	chip8.Cpu.Registers = s.registers
+	chip8.Cpu.IndexRegister = s.index
+	chip8.Cpu.ProgramCounter = s.pc
+	chip8.Cpu.StackPointer = s.sp
+	chip8.Cpu.ProgramStack = s.stack
+	chip8.DelayTimer = s.delay
+	chip8.SoundTimer = s.sound
+	chip8.Cpu.Memory = s.memory
+	chip8.Display = s.display
+	chip8.Keypad = s.keypad
+}
+
+// Differences written in the language of the states
+func (s *specState) compare(expected *specState) []string {
+	var differences []string
+	add := func(name string, got interface{}, want interface{}) {
+		differences = append(differences, fmt.Sprintf("%s: got %v, want %v", name, got, want))
+	}
+	hex := func(value int) string {
+		return fmt.Sprintf("0x%02X", value)
+	}
+	for i := range s.registers {
+		if s.registers[i] != expected.registers[i] {
+			add(fmt.Sprintf("V%X", i), hex(int(s.registers[i])), hex(int(expected.registers[i])))
+		}
+	}
+	if s.index != expected.index {
+		add("I", hex(int(s.index)), hex(int(expected.index)))
+	}
+	if s.pc != expected.pc {
+		add("PC", hex(int(s.pc)), hex(int(expected.pc)))
+	}
+	if s.sp != expected.sp {
+		add("SP", s.sp, expected.sp)
+	}
+	for i := range s.stack {
+		if s.stack[i] != expected.stack[i] {
+			add(fmt.Sprintf("S%X", i), hex(int(s.stack[i])), hex(int(expected.stack[i])))
+		}
+	}
+	if s.delay != expected.delay {
+		add("DT", s.delay, expected.delay)
+	}
+	if s.sound != expected.sound {
+		add("ST", s.sound, expected.sound)
+	}
+	for address := range s.memory {
+		if s.memory[address] != expected.memory[address] {
+			add(fmt.Sprintf("M[0x%03X]", address), hex(int(s.memory[address])), hex(int(expected.memory[address])))
+		}
+	}
+	for x := range s.display {
+		for y := range s.display[x] {
+			if s.display[x][y] != expected.display[x][y] {
+				add(fmt.Sprintf("D[%d,%d]", x, y), s.display[x][y], expected.display[x][y])
+			}
+		}
+	}
+	if s.keypad != expected.keypad {
+		add("K", s.keypad, expected.keypad)
+	}
+	if s.isFault != expected.isFault {
+		add("FAULT", s.isFault, expected.isFault)
+	}
+	return differences
+}
+
+func isShiftVY(q Quirks) bool        { return q.ShiftVY }
+func isShiftVX(q Quirks) bool        { return !q.ShiftVY }
+func isVFReset(q Quirks) bool        { return q.VFReset }
+func isVFKept(q Quirks) bool         { return !q.VFReset }
+func isJumpVX(q Quirks) bool         { return q.JumpVX }
+func isJumpV0(q Quirks) bool         { return !q.JumpVX }
+func isIncrementIndex(q Quirks) bool { return q.IncrementIndex }
+func isIndexKept(q Quirks) bool      { return !q.IncrementIndex }
+func isClipping(q Quirks) bool       { return q.Clipping }
+func isWrapping(q Quirks) bool       { return !q.Clipping }
+
+var specCases = []specCase{
+	{name: "00E0 clears the display", before: "D[0,0]=1 D[63,31]=1 D[10,5]=1", opcode: 0x00E0,
+		after: "D[0,0]=0 D[63,31]=0 D[10,5]=0"},
+	{name: "00EE returns", before: "SP=1 S0=0x20A", opcode: 0x00EE, after: "SP=0 PC=0x20A"},
+	{name: "00EE returns from the top of the stack", before: "SP=2 S0=0x20A S1=0x30C", opcode: 0x00EE, after: "SP=1 PC=0x30C"},
+	{name: "00EE without a call faults", before: "SP=0", opcode: 0x00EE, after: "FAULT"},
+
+	{name: "1NNN jumps", opcode: 0x1ABC, after: "PC=0xABC"},
+
+	{name: "2NNN calls", opcode: 0x2ABC, after: "SP=1 S0=0x202 PC=0xABC"},
+	{name: "2NNN nested call", before: "SP=1 S0=0x20A", opcode: 0x2ABC, after: "SP=2 S1=0x202 PC=0xABC"},
+	{name: "2NNN overflow faults", before: "SP=16", opcode: 0x2ABC, after: "FAULT"},
+
+	{name: "3XNN skips when equal", before: "V1=0x12", opcode: 0x3112, after: "PC=0x204"},
+	{name: "3XNN runs when not equal", before: "V1=0x13", opcode: 0x3112},
+
+	{name: "4XNN skips when not equal", before: "V1=0x13", opcode: 0x4112, after: "PC=0x204"},
+	{name: "4XNN runs when equal", before: "V1=0x12", opcode: 0x4112},
+
+	{name: "5XY0 skips when VX equals VY", before: "V1=0x05 V2=0x05", opcode: 0x5120, after: "PC=0x204"},
+	{name: "5XY0 compares VY, not NN", before: "V1=0x20 V2=0x21", opcode: 0x5120},
+
+	{name: "6XNN loads", opcode: 0x6A42, after: "VA=0x42"},
+
+	{name: "7XNN adds", before: "V1=0x10", opcode: 0x7105, after: "V1=0x15"},
+	{name: "7XNN wraps without the carry flag", before: "V1=0xFF VF=0x55", opcode: 0x7102, after: "V1=0x01"},
+
+	{name: "8XY0 copies", before: "V2=0x42", opcode: 0x8120, after: "V1=0x42"},
+
+	{name: "8XY1 or", before: "V1=0xF0 V2=0x0F VF=0x55", opcode: 0x8121, after: "V1=0xFF", quirks: isVFKept},
+	{name: "8XY1 or resets VF", before: "V1=0xF0 V2=0x0F VF=0x55", opcode: 0x8121, after: "V1=0xFF VF=0", quirks: isVFReset},
+	{name: "8XY2 and", before: "V1=0xF3 V2=0x3F VF=0x55", opcode: 0x8122, after: "V1=0x33", quirks: isVFKept},
+	{name: "8XY2 and resets VF", before: "V1=0xF3 V2=0x3F VF=0x55", opcode: 0x8122, after: "V1=0x33 VF=0", quirks: isVFReset},
+	{name: "8XY3 xor", before: "V1=0xF3 V2=0x3F VF=0x55", opcode: 0x8123, after: "V1=0xCC", quirks: isVFKept},
+	{name: "8XY3 xor resets VF", before: "V1=0xF3 V2=0x3F VF=0x55", opcode: 0x8123, after: "V1=0xCC VF=0", quirks: isVFReset},
+
+	{name: "8XY4 adds", before: "V1=0x10 V2=0x20 VF=0x55", opcode: 0x8124, after: "V1=0x30 VF=0"},
+	{name: "8XY4 sets the carry", before: "V1=0xFF V2=0x02", opcode: 0x8124, after: "V1=0x01 VF=1"},
+	{name: "8XY4 flag wins over VF as VX", before: "VF=0xFF V1=0x02", opcode: 0x8F14, after: "VF=1"},
+
+	{name: "8XY5 subtracts", before: "V1=0x30 V2=0x10", opcode: 0x8125, after: "V1=0x20 VF=1"},
+	{name: "8XY5 equal values do not borrow", before: "V1=0x30 V2=0x30", opcode: 0x8125, after: "V1=0x00 VF=1"},
+	{name: "8XY5 borrows", before: "V1=0x10 V2=0x30 VF=0x55", opcode: 0x8125, after: "V1=0xE0 VF=0"},
+	{name: "8XY5 flag wins over VF as VX", before: "VF=0x10 V1=0x30", opcode: 0x8F15, after: "VF=0"},
+
+	{name: "8XY6 shifts VX right and stores its low bit", before: "V1=0x05 V2=0x80", opcode: 0x8126, after: "V1=0x02 VF=1", quirks: isShiftVX},
+	{name: "8XY6 stores a clear low bit", before: "V1=0x04 VF=0x55", opcode: 0x8126, after: "V1=0x02 VF=0", quirks: isShiftVX},
+	{name: "8XY6 shifts VY right", before: "V1=0x80 V2=0x05", opcode: 0x8126, after: "V1=0x02 VF=1", quirks: isShiftVY},
+	{name: "8XY6 flag wins over VF as VX", before: "VF=0x03 V2=0x03", opcode: 0x8F26, after: "VF=1"},
+
+	{name: "8XY7 subtracts VX from VY", before: "V1=0x10 V2=0x30", opcode: 0x8127, after: "V1=0x20 VF=1"},
+	{name: "8XY7 borrows", before: "V1=0x30 V2=0x10", opcode: 0x8127, after: "V1=0xE0 VF=0"},
+	{name: "8XY7 flag wins over VF as VX", before: "VF=0x30 V1=0x10", opcode: 0x8F17, after: "VF=0"},
+
+	{name: "8XYE shifts VX left", before: "V1=0x81 V2=0x01", opcode: 0x812E, after: "V1=0x02 VF=1", quirks: isShiftVX},
+	{name: "8XYE stores only the top bit", before: "V1=0x40", opcode: 0x812E, after: "V1=0x80 VF=0", quirks: isShiftVX},
+	{name: "8XYE shifts VY left", before: "V1=0x01 V2=0x81", opcode: 0x812E, after: "V1=0x02 VF=1", quirks: isShiftVY},
+	{name: "8XYE flag wins over VF as VX", before: "VF=0x81 V2=0x81", opcode: 0x8F2E, after: "VF=1"},
+
+	{name: "9XY0 skips when not equal", before: "V1=0x05 V2=0x06", opcode: 0x9120, after: "PC=0x204"},
+	{name: "9XY0 runs when equal", before: "V1=0x05 V2=0x05", opcode: 0x9120},
+
+	{name: "ANNN loads I", opcode: 0xA123, after: "I=0x123"},
+
+	{name: "BNNN jumps with V0", before: "V0=0x10 V1=0x20", opcode: 0xB120, after: "PC=0x130", quirks: isJumpV0},
+	{name: "BXNN jumps with VX", before: "V0=0x10 V1=0x20", opcode: 0xB120, after: "PC=0x140", quirks: isJumpVX},
+	{name: "BNNN wraps in 12 bits", before: "V0=0x10 VF=0x10", opcode: 0xBFF8, after: "PC=0x008"},
+
+	{name: "CXNN masks with NN", before: "V1=0x55", opcode: 0xC100, after: "V1=0"},
+
+	{name: "DXYN draws", before: "I=0x300 M[0x300]=0xC0,0x80 V0=2 V1=3 VF=0x55", opcode: 0xD012,
+		after: "D[2,3]=1 D[3,3]=1 D[2,4]=1 VF=0"},
+	{name: "DXYN xors and reports collisions", before: "I=0x300 M[0x300]=0xC0 D[0,0]=1", opcode: 0xD001,
+		after: "D[0,0]=0 D[1,0]=1 VF=1"},
+	{name: "DXYN wraps the start position", before: "I=0x300 M[0x300]=0x80 V0=66 V1=33", opcode: 0xD011,
+		after: "D[2,1]=1"},
+	{name: "DXYN wraps past the edges", before: "I=0x300 M[0x300]=0xC0,0xC0 V0=63 V1=31", opcode: 0xD012,
+		after: "D[63,31]=1 D[0,31]=1 D[63,0]=1 D[0,0]=1", quirks: isWrapping},
+	{name: "DXYN clips past the edges", before: "I=0x300 M[0x300]=0xC0,0xC0 V0=63 V1=31", opcode: 0xD012,
+		after: "D[63,31]=1", quirks: isClipping},
+
+	{name: "EX9E skips when the key is pressed", before: "V1=5 K=5", opcode: 0xE19E, after: "PC=0x204"},
+	{name: "EX9E runs when the key is not pressed", before: "V1=5 K=6", opcode: 0xE19E},
+	{name: "EX9E uses the low nibble of VX", before: "V1=0xF5 K=5", opcode: 0xE19E, after: "PC=0x204"},
+	{name: "EXA1 skips when the key is not pressed", before: "V1=5 K=6", opcode: 0xE1A1, after: "PC=0x204"},
+	{name: "EXA1 runs when the key is pressed", before: "V1=5 K=5", opcode: 0xE1A1},
+	{name: "EXA1 uses the low nibble of VX", before: "V1=0xF5 K=5", opcode: 0xE1A1},
+
+	{name: "FX07 reads the delay timer", before: "DT=0x20", opcode: 0xF107, after: "V1=0x20"},
+
+	{name: "FX0A waits for a key", opcode: 0xF10A, after: "PC=0x200"},
+	{name: "FX0A stores the pressed key", before: "K=7", opcode: 0xF10A, after: "V1=7"},
+
+	{name: "FX15 sets the delay timer", before: "V1=0x20", opcode: 0xF115, after: "DT=0x20"},
+	{name: "FX18 sets the sound timer", before: "V1=0x20", opcode: 0xF118, after: "ST=0x20"},
+
+	{name: "FX1E adds to I", before: "I=0x300 V1=0x20", opcode: 0xF11E, after: "I=0x320"},
+	{name: "FX1E does not change VF", before: "I=0xFFF V1=0x01 VF=0x55", opcode: 0xF11E, after: "I=0x1000"},
+
+	{name: "FX29 points I to the character", before: "V1=0x0A", opcode: 0xF129, after: "I=0x082"},
+	{name: "FX29 uses the low nibble of VX", before: "V1=0x1A", opcode: 0xF129, after: "I=0x082"},
+
+	{name: "FX33 stores the decimal digits", before: "I=0x300 V1=254", opcode: 0xF133, after: "M[0x300]=2,5,4"},
+	{name: "FX33 wraps at the end of memory", before: "I=0xFFE V1=123", opcode: 0xF133, after: "M[0xFFE]=1,2,3"},
+
+	{name: "FX55 stores V0 to VX", before: "I=0x300 V0=1 V1=2 V2=3 V3=4", opcode: 0xF255,
+		after: "M[0x300]=1,2,3", quirks: isIndexKept},
+	{name: "FX55 increments I", before: "I=0x300 V0=1 V1=2 V2=3 V3=4", opcode: 0xF255,
+		after: "M[0x300]=1,2,3 I=0x303", quirks: isIncrementIndex},
+
+	{name: "FX65 loads V0 to VX", before: "I=0x300 M[0x300]=1,2,3,4", opcode: 0xF265,
+		after: "V0=1 V1=2 V2=3", quirks: isIndexKept},
+	{name: "FX65 increments I", before: "I=0x300 M[0x300]=1,2,3,4", opcode: 0xF265,
+		after: "V0=1 V1=2 V2=3 I=0x303", quirks: isIncrementIndex},
+}
+
+// Every opcode case under every quirk profile it applies to
+func TestOpcodeSpec(t *testing.T) {
+	for _, profile := range QuirkProfileNames() {
+		quirks := QuirkProfiles[profile]
+		for _, c := range specCases {
+			if c.quirks != nil && !c.quirks(quirks) {
+				continue
+			}
+			c := c
+			t.Run(profile+"/"+c.name, func(t *testing.T) {
+				frontend = headlessFrontend{}
+				reset()
+				chip8.Quirks = quirks
+				before := specState{pc: ProgramCounter(START_ADDRESS)}
+				if err := before.parse(c.before); err != nil {
+					t.Fatal(err)
+				}
+				before.memory[before.pc] = uint8(c.opcode >> 8)
+				before.memory[before.pc+1] = uint8(c.opcode)
+				expected := before
+				expected.pc += 2
+				if err := expected.parse(c.after); err != nil {
+					t.Fatal(err)
+				}
+				before.load()
+				fetch()
+				chip8.Cpu.ProgramCounter += 2
+				decodeAndExecute()
+				got := captureSpecState()
+				for _, difference := range got.compare(&expected) {
+					t.Errorf("%04X %s", uint16(c.opcode), difference)
+				}
+			})
+		}
+	}
+}
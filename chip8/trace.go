@@ -0,0 +1,275 @@
+package chip8
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"strings"
+)
+
+type TraceCategory uint8
+
+const (
+	TRACE_CPU TraceCategory = 1 << iota
+	TRACE_DRAW
+	TRACE_TIMER
+	TRACE_INPUT
+	TRACE_AUDIO
+	TRACE_ALL = TRACE_CPU | TRACE_DRAW | TRACE_TIMER | TRACE_INPUT | TRACE_AUDIO
+)
+
+var traceCategoryNames = map[TraceCategory]string{
+	TRACE_CPU:   "cpu",
+	TRACE_DRAW:  "draw",
+	TRACE_TIMER: "timer",
+	TRACE_INPUT: "input",
+	TRACE_AUDIO: "audio",
+}
+
+type TraceLevel uint8
+
+const (
+	TRACE_OFF TraceLevel = iota
+	// Instructions and events
+	TRACE_INFO
+	// Sprite rows and every timer tick
+	TRACE_DEBUG
+)
+
+// One json line of the trace file, keys are short to keep the records compact
+type TraceRecord struct {
+	Cycle    uint64 `json:"cycle"`
+	Category string `json:"cat"`
+	PC       string `json:"pc"`
+	Opcode   string `json:"op,omitempty"`
+	Mnemonic string `json:"asm,omitempty"`
+	// Registers changed by the instruction, V0-VF, I, SP, DT and ST
+	Changes map[string]int `json:"set,omitempty"`
+	// Display hash after the instructions which change it
+	Display string `json:"fb,omitempty"`
+	Message string `json:"msg,omitempty"`
+}
+
+type TraceConfig struct {
+	Path       string
+	Level      TraceLevel
+	Categories TraceCategory
+	// Inclusive range of the instruction addresses to trace
+	StartPC ProgramCounter
+	EndPC   ProgramCounter
+}
+
+type Tracer struct {
+	TraceConfig
+	out     *bufio.Writer
+	file    io.Closer
+	encoder *json.Encoder
+	cycle   uint64
+	// Address and registers of the instruction being executed
+	pc               ProgramCounter
+	before           machineRegisters
+	isDisplayChanged bool
+}
+
+// Nil when tracing is off
+var tracer *Tracer
+
+type machineRegisters struct {
+	registers    Registers
+	index        IndexRegister
+	stackPointer StackPointer
+	delayTimer   DelayTimer
+	soundTimer   SoundTimer
+}
+
+func ParseTraceLevel(s string) (TraceLevel, error) {
+	switch s {
+	case "off":
+		return TRACE_OFF, nil
+	case "info":
+		return TRACE_INFO, nil
+	case "debug":
+		return TRACE_DEBUG, nil
+	}
+	return TRACE_OFF, fmt.Errorf("Unknown trace level %q, use off, info or debug", s)
+}
+
+// Comma separated category names or all
+func ParseTraceCategories(s string) (TraceCategory, error) {
+	categories := TraceCategory(0)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "all" {
+			categories |= TRACE_ALL
+			continue
+		}
+		isFound := false
+		for category, categoryName := range traceCategoryNames {
+			if categoryName == name {
+				categories |= category
+				isFound = true
+			}
+		}
+		if !isFound {
+			return 0, fmt.Errorf("Unknown trace category %q, use cpu, draw, timer, input, audio or all", name)
+		}
+	}
+	return categories, nil
+}
+
+// Parse start-end, either side can be omitted
+func ParseTraceRange(s string) (ProgramCounter, ProgramCounter, error) {
+	start, end := ProgramCounter(0), ProgramCounter(len(chip8.Cpu.Memory)-1)
+	if s == "" {
+		return start, end, nil
+	}
+	startText, endText, isRange := strings.Cut(s, "-")
+	if !isRange {
+		endText = startText
+	}
+	if startText != "" {
+		value, err := parseNumber(startText, 16)
+		if err != nil {
+			return 0, 0, err
+		}
+		start = ProgramCounter(value)
+	}
+	if endText != "" {
+		value, err := parseNumber(endText, 16)
+		if err != nil {
+			return 0, 0, err
+		}
+		end = ProgramCounter(value)
+	}
+	return start, end, nil
+}
+
+// Start writing the trace records to the file, - is stdout
+func StartTrace(config TraceConfig) error {
+	if config.Level == TRACE_OFF || config.Categories == 0 {
+		return nil
+	}
+	var out io.Writer = os.Stdout
+	t := &Tracer{TraceConfig: config}
+	if config.Path != "-" {
+		file, err := os.Create(config.Path)
+		if err != nil {
+			return err
+		}
+		out, t.file = file, file
+	}
+	t.out = bufio.NewWriter(out)
+	t.encoder = json.NewEncoder(t.out)
+	tracer = t
+	return nil
+}
+
+// Flush and close the trace file
+func StopTrace() {
+	if tracer == nil {
+		return
+	}
+	tracer.out.Flush()
+	if tracer.file != nil {
+		tracer.file.Close()
+	}
+	tracer = nil
+}
+
+func isTracing(category TraceCategory, level TraceLevel) bool {
+	return tracer != nil && tracer.Categories&category != 0 && tracer.Level >= level &&
+		tracer.StartPC <= tracer.pc && tracer.pc <= tracer.EndPC
+}
+
+// Write an event record, the arguments are only formatted when the category is traced
+func tracef(category TraceCategory, level TraceLevel, format string, args ...interface{}) {
+	if !isTracing(category, level) {
+		return
+	}
+	tracer.encoder.Encode(TraceRecord{
+		Cycle:    tracer.cycle,
+		Category: traceCategoryNames[category],
+		PC:       fmt.Sprintf("0x%03X", tracer.pc),
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func currentRegisters() machineRegisters {
+	return machineRegisters{
+		registers:    chip8.Cpu.Registers,
+		index:        chip8.Cpu.IndexRegister,
+		stackPointer: chip8.Cpu.StackPointer,
+		delayTimer:   chip8.DelayTimer,
+		soundTimer:   chip8.SoundTimer,
+	}
+}
+
+// Called at the start of the cycle, records are attributed to the instruction at PC
+func traceCycleStart() {
+	if tracer == nil {
+		return
+	}
+	tracer.cycle++
+	tracer.pc = chip8.Cpu.ProgramCounter
+	tracer.before = currentRegisters()
+	tracer.isDisplayChanged = false
+}
+
+// Called by the instructions which change the display
+func traceDisplayChanged() {
+	if tracer != nil {
+		tracer.isDisplayChanged = true
+	}
+}
+
+// FNV-1a hash of the pixels, column by column
+func DisplayHash(display *Display) string {
+	hash := fnv.New64a()
+	for x := range display {
+		hash.Write(display[x][:])
+	}
+	return fmt.Sprintf("%016x", hash.Sum64())
+}
+
+// Write the cpu record of the executed instruction with the registers changed in
+// the cycle, timer ticks included, and the display hash if it is changed
+func traceInstructionEnd() {
+	if !isTracing(TRACE_CPU, TRACE_INFO) {
+		return
+	}
+	before, after := tracer.before, currentRegisters()
+	changes := map[string]int{}
+	for i := range after.registers {
+		if after.registers[i] != before.registers[i] {
+			changes[fmt.Sprintf("V%X", i)] = int(after.registers[i])
+		}
+	}
+	if after.index != before.index {
+		changes["I"] = int(after.index)
+	}
+	if after.stackPointer != before.stackPointer {
+		changes["SP"] = int(after.stackPointer)
+	}
+	if after.delayTimer != before.delayTimer {
+		changes["DT"] = int(after.delayTimer)
+	}
+	if after.soundTimer != before.soundTimer {
+		changes["ST"] = int(after.soundTimer)
+	}
+	display := ""
+	if tracer.isDisplayChanged {
+		display = DisplayHash(&chip8.Display)
+	}
+	tracer.encoder.Encode(TraceRecord{
+		Cycle:    tracer.cycle,
+		Category: traceCategoryNames[TRACE_CPU],
+		PC:       fmt.Sprintf("0x%03X", tracer.pc),
+		Opcode:   fmt.Sprintf("%04X", uint16(chip8.Cpu.Opcode)),
+		Mnemonic: Mnemonic(tracer.pc),
+		Changes:  changes,
+		Display:  display,
+	})
+}
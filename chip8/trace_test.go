@@ -0,0 +1,133 @@
+package chip8
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Loads VA and I, draws a 5 row sprite, sets the delay timer and loops
+var traceProgram = []byte{
+	0x6A, 0x05,
+	0xA2, 0x0C,
+	0xD0, 0x15,
+	0xFA, 0x15,
+	0x12, 0x08,
+	0x00, 0x00,
+	0xF0, 0x90, 0x90, 0x90, 0xF0,
+}
+
+// Run the cycles of the program with the tracer writing into memory and return the lines
+func traceCycles(t *testing.T, config TraceConfig, cycles int) []string {
+	frontend = headlessFrontend{}
+	reset()
+	defer reset()
+	copy(chip8.Cpu.Memory[START_ADDRESS:], traceProgram)
+	var out bytes.Buffer
+	tracer = &Tracer{TraceConfig: config, out: bufio.NewWriter(&out)}
+	tracer.encoder = json.NewEncoder(tracer.out)
+	for i := 0; i < cycles; i++ {
+		cycle()
+	}
+	StopTrace()
+	return strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+}
+
+func decodeTrace(t *testing.T, lines []string) []TraceRecord {
+	records := make([]TraceRecord, len(lines))
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &records[i]); err != nil {
+			t.Fatalf("Line %d %q: %v", i+1, line, err)
+		}
+	}
+	return records
+}
+
+func TestTraceRecords(t *testing.T) {
+	config := TraceConfig{Level: TRACE_DEBUG, Categories: TRACE_ALL, EndPC: 0xFFF}
+	lines := traceCycles(t, config, 6)
+	if lines[0] != `{"cycle":1,"cat":"cpu","pc":"0x200","op":"6A05","asm":"LD VA, 0x05","set":{"VA":5}}` {
+		t.Errorf("The first record is %s", lines[0])
+	}
+	records := decodeTrace(t, lines)
+	var cpu []TraceRecord
+	messages := map[string]TraceRecord{}
+	for _, record := range records {
+		if record.Category == "cpu" {
+			cpu = append(cpu, record)
+		} else {
+			messages[record.Category+" "+record.Message] = record
+		}
+	}
+	if len(cpu) != 6 {
+		t.Fatalf("%d cpu records, want 6", len(cpu))
+	}
+	if record := cpu[1]; record.PC != "0x202" || record.Changes["I"] != 0x20C || len(record.Changes) != 1 {
+		t.Errorf("The second cpu record is %+v", record)
+	}
+	if record := cpu[2]; record.Opcode != "D015" || len(record.Display) != 16 || len(record.Changes) != 0 {
+		t.Errorf("The draw is %+v", record)
+	}
+	if record := cpu[4]; record.Cycle != 5 || record.Mnemonic != "JP 0x208" || record.Changes["DT"] != 4 || record.Display != "" {
+		t.Errorf("The jump after setting the timer is %+v", record)
+	}
+	for _, message := range []string{
+		"draw sprite 5 rows at 0,0 from 0x20C collision=0",
+		"draw row 0: 11110000",
+		"timer delay=4",
+	} {
+		if _, isExists := messages[message]; !isExists {
+			t.Errorf("No record %q", message)
+		}
+	}
+	if record := messages["draw row 4: 11110000"]; record.Cycle != 3 || record.PC != "0x204" {
+		t.Errorf("The last sprite row is %+v", record)
+	}
+}
+
+func TestTraceFilters(t *testing.T) {
+	tests := []struct {
+		name   string
+		config TraceConfig
+		// Category, cycle and message of the records
+		records []string
+	}{
+		{"info level", TraceConfig{Level: TRACE_INFO, Categories: TRACE_DRAW | TRACE_TIMER, EndPC: 0xFFF},
+			[]string{"draw 3 sprite 5 rows at 0,0 from 0x20C collision=0"}},
+		{"cpu category", TraceConfig{Level: TRACE_DEBUG, Categories: TRACE_CPU, StartPC: 0x206, EndPC: 0xFFF},
+			[]string{"cpu 4 ", "cpu 5 ", "cpu 6 "}},
+		{"pc range", TraceConfig{Level: TRACE_INFO, Categories: TRACE_ALL, StartPC: 0x202, EndPC: 0x204},
+			[]string{"cpu 2 ", "draw 3 sprite 5 rows at 0,0 from 0x20C collision=0", "cpu 3 "}},
+	}
+	for _, test := range tests {
+		var records []string
+		for _, record := range decodeTrace(t, traceCycles(t, test.config, 6)) {
+			records = append(records, strings.Join([]string{record.Category, fmt.Sprint(record.Cycle), record.Message}, " "))
+		}
+		if strings.Join(records, "\n") != strings.Join(test.records, "\n") {
+			t.Errorf("%s: records are %q, want %q", test.name, records, test.records)
+		}
+	}
+}
+
+func TestParseTraceRange(t *testing.T) {
+	tests := []struct {
+		text       string
+		start, end ProgramCounter
+	}{
+		{"", 0, 0xFFF},
+		{"0x200-0x2FF", 0x200, 0x2FF},
+		{"0x300-", 0x300, 0xFFF},
+		{"-0x280", 0, 0x280},
+		{"0x2A0", 0x2A0, 0x2A0},
+	}
+	for _, test := range tests {
+		start, end, err := ParseTraceRange(test.text)
+		if err != nil || start != test.start || end != test.end {
+			t.Errorf("%q is %03X-%03X %v, want %03X-%03X", test.text, start, end, err, test.start, test.end)
+		}
+	}
+}
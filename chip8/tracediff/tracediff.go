@@ -0,0 +1,206 @@
+// Package tracediff aligns two execution traces written by chip8 -trace and finds
+// the first cycle where PC, the registers, I, the timers or the display diverge
+//
+// Records are aligned by their cycle, the machine state of both traces is rebuilt
+// from the changes of the cpu records, so a reference emulator only needs to
+// write the same json lines:
+//
+//	{"cycle":2,"cat":"cpu","pc":"0x202","op":"A22A","set":{"I":554},"fb":"<hash>"}
+package tracediff
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type Record struct {
+	Cycle    uint64         `json:"cycle"`
+	Category string         `json:"cat"`
+	PC       string         `json:"pc"`
+	Opcode   string         `json:"op"`
+	Mnemonic string         `json:"asm"`
+	Changes  map[string]int `json:"set"`
+	Display  string         `json:"fb"`
+}
+
+// Cpu records of a trace file
+type Trace struct {
+	Name    string
+	Records []Record
+}
+
+// Machine state after a record
+type State struct {
+	PC        string
+	Opcode    string
+	Registers map[string]int
+	Display   string
+}
+
+type Difference struct {
+	Field string
+	A, B  string
+}
+
+type Divergence struct {
+	Cycle uint64
+	// Index of the diverging records, len(Records) if the trace ended
+	IndexA, IndexB int
+	Differences    []Difference
+}
+
+// Registers in the order they are compared and printed
+var registerNames = []string{"V0", "V1", "V2", "V3", "V4", "V5", "V6", "V7",
+	"V8", "V9", "VA", "VB", "VC", "VD", "VE", "VF", "I", "SP", "DT", "ST"}
+
+// Read the cpu records, records of other categories are skipped
+func Read(name string, r io.Reader) (*Trace, error) {
+	trace := &Trace{Name: name}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, lineNum, err)
+		}
+		if record.Category == "cpu" {
+			trace.Records = append(trace.Records, record)
+		}
+	}
+	return trace, scanner.Err()
+}
+
+func newState() *State {
+	return &State{Registers: map[string]int{}}
+}
+
+func (s *State) apply(record Record) {
+	s.PC = record.PC
+	s.Opcode = record.Opcode
+	for name, value := range record.Changes {
+		s.Registers[name] = value
+	}
+	if record.Display != "" {
+		s.Display = record.Display
+	}
+}
+
+func compare(a, b *State) []Difference {
+	var differences []Difference
+	add := func(field, valueA, valueB string) {
+		if valueA != valueB {
+			differences = append(differences, Difference{Field: field, A: valueA, B: valueB})
+		}
+	}
+	add("PC", a.PC, b.PC)
+	add("opcode", a.Opcode, b.Opcode)
+	for _, name := range registerNames {
+		add(name, fmt.Sprintf("0x%02X", a.Registers[name]), fmt.Sprintf("0x%02X", b.Registers[name]))
+	}
+	add("display", a.Display, b.Display)
+	return differences
+}
+
+// Return the first divergence, nil if the traces are the same
+func Diff(a, b *Trace) *Divergence {
+	stateA, stateB := newState(), newState()
+	i, j := 0, 0
+	for i < len(a.Records) && j < len(b.Records) {
+		recordA, recordB := a.Records[i], b.Records[j]
+		if recordA.Cycle != recordB.Cycle {
+			cycle := recordA.Cycle
+			if recordB.Cycle < cycle {
+				cycle = recordB.Cycle
+			}
+			return &Divergence{Cycle: cycle, IndexA: i, IndexB: j, Differences: []Difference{
+				{Field: "cycle", A: fmt.Sprint(recordA.Cycle), B: fmt.Sprint(recordB.Cycle)},
+			}}
+		}
+		stateA.apply(recordA)
+		stateB.apply(recordB)
+		if differences := compare(stateA, stateB); len(differences) > 0 {
+			return &Divergence{Cycle: recordA.Cycle, IndexA: i, IndexB: j, Differences: differences}
+		}
+		i++
+		j++
+	}
+	if i == len(a.Records) && j == len(b.Records) {
+		return nil
+	}
+	divergence := &Divergence{IndexA: i, IndexB: j}
+	if i < len(a.Records) {
+		divergence.Cycle = a.Records[i].Cycle
+		divergence.Differences = []Difference{{Field: "end", A: "running", B: "ended"}}
+	} else {
+		divergence.Cycle = b.Records[j].Cycle
+		divergence.Differences = []Difference{{Field: "end", A: "ended", B: "running"}}
+	}
+	return divergence
+}
+
+func (r Record) String() string {
+	names := make([]string, 0, len(r.Changes))
+	for name := range r.Changes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return registerIndex(names[i]) < registerIndex(names[j])
+	})
+	changes := make([]string, len(names))
+	for i, name := range names {
+		changes[i] = fmt.Sprintf("%s=0x%02X", name, r.Changes[name])
+	}
+	if r.Display != "" {
+		changes = append(changes, "display="+r.Display)
+	}
+	line := fmt.Sprintf("%8d %-6s %-4s %-20s %s", r.Cycle, r.PC, r.Opcode, r.Mnemonic, strings.Join(changes, " "))
+	return strings.TrimRight(line, " ")
+}
+
+func registerIndex(name string) int {
+	for i, registerName := range registerNames {
+		if registerName == name {
+			return i
+		}
+	}
+	return len(registerNames)
+}
+
+// Write the differences and the records around the divergence of both traces
+func (d *Divergence) Write(w io.Writer, a, b *Trace, context int) {
+	fmt.Fprintf(w, "Traces diverge at cycle %d\n", d.Cycle)
+	for _, difference := range d.Differences {
+		fmt.Fprintf(w, "  %-8s %s: %s  %s: %s\n", difference.Field, a.Name, difference.A, b.Name, difference.B)
+	}
+	for _, side := range []struct {
+		trace *Trace
+		index int
+	}{{a, d.IndexA}, {b, d.IndexB}} {
+		fmt.Fprintf(w, "\n%s:\n", side.trace.Name)
+		start, end := side.index-context, side.index+context
+		if start < 0 {
+			start = 0
+		}
+		if end >= len(side.trace.Records) {
+			end = len(side.trace.Records) - 1
+		}
+		for i := start; i <= end; i++ {
+			marker := "  "
+			if i == side.index {
+				marker = "=>"
+			}
+			fmt.Fprintf(w, "%s%s\n", marker, side.trace.Records[i])
+		}
+		if side.index >= len(side.trace.Records) {
+			fmt.Fprintln(w, "=> end of trace")
+		}
+	}
+}
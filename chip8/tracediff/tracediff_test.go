@@ -0,0 +1,116 @@
+package tracediff
+
+import (
+	"strings"
+	"testing"
+)
+
+const reference = `{"cycle":1,"cat":"cpu","pc":"0x200","op":"00E0","asm":"CLS","fb":"a1"}
+{"cycle":1,"cat":"timer","pc":"0x202","msg":"delay=0"}
+{"cycle":2,"cat":"cpu","pc":"0x202","op":"6A05","asm":"LD VA, 0x05","set":{"VA":5}}
+{"cycle":3,"cat":"cpu","pc":"0x204","op":"A22A","asm":"LD I, 0x22A","set":{"I":554}}
+{"cycle":4,"cat":"cpu","pc":"0x206","op":"D01F","asm":"DRW V0, V1, 15","set":{"VF":0},"fb":"b2"}
+`
+
+func readTrace(t *testing.T, name string, lines string) *Trace {
+	trace, err := Read(name, strings.NewReader(lines))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trace
+}
+
+func TestRead(t *testing.T) {
+	trace := readTrace(t, "a", reference)
+	if len(trace.Records) != 4 {
+		t.Fatalf("Read %d cpu records, want 4", len(trace.Records))
+	}
+	if record := trace.Records[2]; record.Cycle != 3 || record.PC != "0x204" || record.Changes["I"] != 554 {
+		t.Errorf("Record 2 is %+v", record)
+	}
+	if _, err := Read("broken", strings.NewReader("\n{\"cycle\":")); err == nil || !strings.HasPrefix(err.Error(), "broken:2: ") {
+		t.Errorf("A broken line gives the error %v", err)
+	}
+}
+
+func TestDiffIdentical(t *testing.T) {
+	// Records of other categories are not compared
+	other := strings.Replace(reference, `"msg":"delay=0"`, `"msg":"delay=1"`, 1)
+	if divergence := Diff(readTrace(t, "a", reference), readTrace(t, "b", other)); divergence != nil {
+		t.Errorf("Identical traces diverge at cycle %d: %+v", divergence.Cycle, divergence.Differences)
+	}
+}
+
+func TestDiffRegister(t *testing.T) {
+	a := readTrace(t, "a", reference)
+	b := readTrace(t, "b", strings.Replace(reference, `"set":{"VA":5}`, `"set":{"VA":6}`, 1))
+	divergence := Diff(a, b)
+	if divergence == nil {
+		t.Fatal("No divergence")
+	}
+	want := Difference{Field: "VA", A: "0x05", B: "0x06"}
+	if divergence.Cycle != 2 || divergence.IndexA != 1 || divergence.IndexB != 1 ||
+		len(divergence.Differences) != 1 || divergence.Differences[0] != want {
+		t.Errorf("Divergence is %+v", divergence)
+	}
+	var out strings.Builder
+	divergence.Write(&out, a, b, 1)
+	for _, line := range []string{
+		"Traces diverge at cycle 2",
+		"  VA       a: 0x05  b: 0x06",
+		"=>       2 0x202  6A05 LD VA, 0x05          VA=0x05",
+		"=>       2 0x202  6A05 LD VA, 0x05          VA=0x06",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("No line %q in\n%s", line, out.String())
+		}
+	}
+}
+
+// The display hash is carried over the records which don't draw
+func TestDiffDisplay(t *testing.T) {
+	b := readTrace(t, "b", strings.Replace(reference, `"fb":"b2"`, `"fb":"c3"`, 1))
+	divergence := Diff(readTrace(t, "a", reference), b)
+	if divergence == nil {
+		t.Fatal("No divergence")
+	}
+	want := Difference{Field: "display", A: "b2", B: "c3"}
+	if divergence.Cycle != 4 || len(divergence.Differences) != 1 || divergence.Differences[0] != want {
+		t.Errorf("Divergence is %+v", divergence)
+	}
+	b = readTrace(t, "b", strings.Replace(reference, `"fb":"a1"`, `"fb":"a2"`, 1))
+	if divergence = Diff(readTrace(t, "a", reference), b); divergence == nil || divergence.Cycle != 1 {
+		t.Errorf("Divergence is %+v", divergence)
+	}
+}
+
+func TestDiffLength(t *testing.T) {
+	lines := strings.SplitAfter(reference, "\n")
+	short := strings.Join(lines[:3], "")
+	a, b := readTrace(t, "a", reference), readTrace(t, "b", short)
+	divergence := Diff(a, b)
+	if divergence == nil {
+		t.Fatal("No divergence")
+	}
+	want := Difference{Field: "end", A: "running", B: "ended"}
+	if divergence.Cycle != 3 || divergence.IndexA != 2 || divergence.IndexB != 2 || divergence.Differences[0] != want {
+		t.Errorf("Divergence is %+v", divergence)
+	}
+	var out strings.Builder
+	divergence.Write(&out, a, b, 1)
+	if !strings.HasSuffix(out.String(), "=> end of trace\n") {
+		t.Errorf("The end of the short trace is not marked in\n%s", out.String())
+	}
+	divergence = Diff(b, a)
+	want = Difference{Field: "end", A: "ended", B: "running"}
+	if divergence == nil || divergence.Cycle != 3 || divergence.Differences[0] != want {
+		t.Errorf("Divergence is %+v", divergence)
+	}
+	// Traces sampling different cycles diverge at the first missing one
+	skipped := strings.Replace(reference, `"cycle":3`, `"cycle":5`, 1)
+	divergence = Diff(a, readTrace(t, "b", skipped))
+	want = Difference{Field: "cycle", A: "3", B: "5"}
+	if divergence == nil || divergence.Cycle != 3 || divergence.Differences[0] != want {
+		t.Errorf("Divergence is %+v", divergence)
+	}
+}
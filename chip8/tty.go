@@ -0,0 +1,236 @@
+package chip8
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Characters the display is drawn with, half blocks are two pixels high and keep the
+// colour of every pixel, braille cells are 2x4 dots in a single colour
+const (
+	TTY_HALFBLOCK = "halfblock"
+	TTY_BRAILLE   = "braille"
+)
+
+var TTYMode = TTY_HALFBLOCK
+
+// Terminals only report key presses, a key is held down until no repeat of it came for
+// this long, longer than the delay before the keyboard starts repeating
+const TTY_KEY_HOLD = 550 * time.Millisecond
+
+// Ctrl-C quits, raw mode doesn't turn it into a signal
+const TTY_QUIT_KEY = 0x03
+
+func SetTTYMode(mode string) error {
+	if mode != TTY_HALFBLOCK && mode != TTY_BRAILLE {
+		return fmt.Errorf("Unknown tty mode %q, use %s or %s", mode, TTY_HALFBLOCK, TTY_BRAILLE)
+	}
+	TTYMode = mode
+	return nil
+}
+
+// ANSI terminal with 24 bit colours, the keyboard is read in raw mode through stty
+type ttyFrontend struct {
+	// stty settings restored on close
+	settings  string
+	isStarted bool
+	keys      chan byte
+	lastFrame string
+	lastDraw  time.Time
+	held      map[uint8]time.Time
+	logOutput io.Writer
+	closeOnce sync.Once
+}
+
+func (t *ttyFrontend) Start(display *Display) {
+	settings, err := stty("-g")
+	if err != nil {
+		log.Fatal("The tty frontend needs a terminal! ", err)
+	}
+	t.settings = strings.TrimSpace(settings)
+	if _, err := stty("raw", "-echo"); err != nil {
+		log.Fatal("Failed to set the terminal to raw mode! ", err)
+	}
+	t.isStarted = true
+	t.held = map[uint8]time.Time{}
+	t.keys = make(chan byte, 64)
+	go func() {
+		buffer := make([]byte, 64)
+		for {
+			n, err := os.Stdin.Read(buffer)
+			if err != nil {
+				close(t.keys)
+				return
+			}
+			for _, key := range buffer[:n] {
+				t.keys <- key
+			}
+		}
+	}()
+	// Logs would scroll the display away
+	t.logOutput = log.Writer()
+	log.SetOutput(io.Discard)
+	// Alternate screen, hidden cursor, cleared, with the hint under the display
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l\x1b[2J")
+	fmt.Fprintf(os.Stdout, "\x1b[%d;1HCtrl-C quits", ttyRows(TTYMode)+2)
+	t.Render(display)
+}
+
+func (t *ttyFrontend) Clear(display *Display) {
+	for i := range display {
+		display[i] = [HEIGHT]uint8{}
+	}
+}
+
+// Draw the display if it changed, at most once per frame since the terminal can be
+// at the other end of a slow connection
+func (t *ttyFrontend) Render(display *Display) {
+	if !t.isStarted || time.Since(t.lastDraw) < time.Second/FRAME_RATE {
+		return
+	}
+	frame := ttyFrame(display, TTYMode)
+	if frame == t.lastFrame {
+		return
+	}
+	t.lastFrame = frame
+	t.lastDraw = time.Now()
+	os.Stdout.WriteString(frame)
+}
+
+// The bell rings once per beep
+func (t *ttyFrontend) PlayAudio() {
+	if t.isStarted {
+		os.Stdout.WriteString("\a")
+	}
+}
+
+func (t *ttyFrontend) PauseAudio() {}
+
+func (t *ttyFrontend) HandleEvents(quit func(), keypad *Keypad) {
+	now := time.Now()
+	for isReading := true; isReading; {
+		select {
+		case key, isOpen := <-t.keys:
+			if !isOpen || key == TTY_QUIT_KEY {
+				t.keys = nil
+				quit()
+				return
+			}
+			if index, isExists := keyMap[toLower(key)]; isExists {
+				if _, isHeld := t.held[index]; !isHeld {
+					tracef(TRACE_INPUT, TRACE_INFO, "key %X down", index)
+				}
+				t.held[index] = now
+				keypad[index] = true
+			}
+		default:
+			isReading = false
+		}
+	}
+	for index, pressed := range t.held {
+		if now.Sub(pressed) > TTY_KEY_HOLD {
+			tracef(TRACE_INPUT, TRACE_INFO, "key %X up", index)
+			delete(t.held, index)
+			keypad[index] = false
+		}
+	}
+}
+
+// Restore the terminal, also called by halt after the quit key
+func (t *ttyFrontend) Close() {
+	if !t.isStarted {
+		return
+	}
+	t.closeOnce.Do(func() {
+		os.Stdout.WriteString("\x1b[0m\x1b[?25h\x1b[?1049l")
+		stty(t.settings)
+		log.SetOutput(t.logOutput)
+	})
+}
+
+func toLower(key byte) byte {
+	if 'A' <= key && key <= 'Z' {
+		return key + 'a' - 'A'
+	}
+	return key
+}
+
+func stty(args ...string) (string, error) {
+	command := exec.Command("stty", args...)
+	command.Stdin = os.Stdin
+	output, err := command.Output()
+	return string(output), err
+}
+
+// Escape sequences drawing the display from the top left corner of the terminal in the
+// colours of the palette, a colour is only set when it changes
+func ttyFrame(display *Display, mode string) string {
+	var frame bytes.Buffer
+	var foreground, background string
+	setColors := func(fg string, bg string) {
+		if fg != foreground {
+			frame.WriteString("\x1b[38;2;" + fg + "m")
+			foreground = fg
+		}
+		if bg != background {
+			frame.WriteString("\x1b[48;2;" + bg + "m")
+			background = bg
+		}
+	}
+	pixelColor := func(x int, y int) string {
+		c := ActivePalette.Blend(display[x][y], DisplayBrightness[x][y])
+		return fmt.Sprintf("%d;%d;%d", c.R, c.G, c.B)
+	}
+	rows := ttyRows(mode)
+	cellHeight := HEIGHT / rows
+	for row := 0; row < rows; row++ {
+		fmt.Fprintf(&frame, "\x1b[%d;1H", row+1)
+		y := row * cellHeight
+		if mode != TTY_BRAILLE {
+			for x := 0; x < WIDTH; x++ {
+				setColors(pixelColor(x, y), pixelColor(x, y+1))
+				frame.WriteString("▀")
+			}
+			continue
+		}
+		for x := 0; x < WIDTH; x += 2 {
+			// Dots of the braille cell, the colour is the one of its first lit pixel
+			dots := rune(0)
+			fg := pixelColor(x, y)
+			isLit := false
+			for i, bit := range brailleDots {
+				dx, dy := i%2, i/2
+				if display[x+dx][y+dy] != 0 {
+					dots |= bit
+					if !isLit {
+						fg = pixelColor(x+dx, y+dy)
+						isLit = true
+					}
+				}
+			}
+			bg := ActivePalette.Colors[0]
+			setColors(fg, fmt.Sprintf("%d;%d;%d", bg.R, bg.G, bg.B))
+			frame.WriteRune(0x2800 + dots)
+		}
+	}
+	frame.WriteString("\x1b[0m")
+	return frame.String()
+}
+
+// Terminal rows of the display
+func ttyRows(mode string) int {
+	if mode == TTY_BRAILLE {
+		return HEIGHT / 4
+	}
+	return HEIGHT / 2
+}
+
+// Bits of the braille dots, left and right column of each row from the top
+var brailleDots = [8]rune{0x01, 0x08, 0x02, 0x10, 0x04, 0x20, 0x40, 0x80}
@@ -0,0 +1,50 @@
+package chip8
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var escapeSequence = regexp.MustCompile("\x1b\\[[0-9;]*[a-zA-Z]")
+
+// Characters of the frame, one string per terminal row
+func ttyText(frame string) []string {
+	rows := strings.Split(frame, "\x1b[")
+	var text []string
+	for _, row := range rows[1:] {
+		if strings.HasSuffix(strings.SplitN(row, "H", 2)[0], ";1") {
+			text = append(text, "")
+		}
+		text[len(text)-1] += escapeSequence.ReplaceAllString("\x1b["+row, "")
+	}
+	return text
+}
+
+func TestTTYFrame(t *testing.T) {
+	defer SetPalette(ActivePaletteName())
+	SetPalette("classic")
+	var display Display
+	display[0][0] = 1
+	display[1][1] = 1
+	display[3][3] = 1
+
+	halfblock := ttyFrame(&display, TTY_HALFBLOCK)
+	text := ttyText(halfblock)
+	if len(text) != HEIGHT/2 || len([]rune(text[0])) != WIDTH {
+		t.Fatalf("%d rows of %d characters", len(text), len([]rune(text[0])))
+	}
+	// The top pixel is the foreground, the bottom one the background
+	if !strings.HasPrefix(halfblock, "\x1b[1;1H\x1b[38;2;255;255;255m\x1b[48;2;0;0;0m▀\x1b[38;2;0;0;0m\x1b[48;2;255;255;255m▀") {
+		t.Errorf("first cells %q", halfblock[:80])
+	}
+
+	braille := ttyText(ttyFrame(&display, TTY_BRAILLE))
+	if len(braille) != HEIGHT/4 || len([]rune(braille[0])) != WIDTH/2 {
+		t.Fatalf("%d rows of %d braille cells", len(braille), len([]rune(braille[0])))
+	}
+	// Dots 1 and 5 in the first cell, dot 8 of the bottom right in the second
+	if cells := []rune(braille[0]); cells[0] != '⠑' || cells[1] != '⢀' || cells[2] != '⠀' {
+		t.Errorf("braille cells %q", string(cells[:3]))
+	}
+}
@@ -0,0 +1,281 @@
+//go:build js && wasm
+
+package chip8
+
+import (
+	"fmt"
+	"log"
+	"syscall/js"
+)
+
+// Id of the canvas element of the page the browser frontend draws on
+var CanvasID = "chip8"
+
+// Gain of the square wave of the beep
+const WEB_BEEP_VOLUME = 0.05
+
+// Cycles to catch up with after the tab was in the background are dropped beyond this
+const MAX_FRAME_TIME = 250.0
+
+// The canvas of the page, keyboard events of the document and a Web Audio beep
+// The display is drawn one pixel per display pixel, the page scales the canvas
+type canvasFrontend struct {
+	canvas  js.Value
+	context js.Value
+	audio   js.Value
+	gain    js.Value
+	// Key changes since the last poll, the browser delivers them between frames
+	events  []canvasKeyEvent
+	frame   Display
+	isDirty bool
+	isBeep  bool
+}
+
+type canvasKeyEvent struct {
+	index  uint8
+	isDown bool
+}
+
+func (c *canvasFrontend) Start(display *Display) {
+	document := js.Global().Get("document")
+	c.canvas = document.Call("getElementById", CanvasID)
+	if c.canvas.IsNull() {
+		log.Fatalf("The page has no canvas with the id %q!", CanvasID)
+	}
+	c.context = c.canvas.Call("getContext", "2d")
+	document.Call("addEventListener", "keydown", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		c.key(args[0], true)
+		return nil
+	}))
+	document.Call("addEventListener", "keyup", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		c.key(args[0], false)
+		return nil
+	}))
+	// Keys released while the page had no focus would stay down
+	js.Global().Call("addEventListener", "blur", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		for _, index := range keyMap {
+			c.events = append(c.events, canvasKeyEvent{index, false})
+		}
+		return nil
+	}))
+	c.startAudio()
+	c.Render(display)
+}
+
+func (c *canvasFrontend) key(event js.Value, isDown bool) {
+	c.resumeAudio()
+	key := event.Get("key").String()
+	if len(key) != 1 || event.Get("ctrlKey").Bool() || event.Get("metaKey").Bool() {
+		return
+	}
+	index, isExists := keyMap[toLower(key[0])]
+	if !isExists {
+		return
+	}
+	event.Call("preventDefault")
+	if event.Get("repeat").Bool() {
+		return
+	}
+	c.events = append(c.events, canvasKeyEvent{index, isDown})
+}
+
+func (c *canvasFrontend) Clear(display *Display) {
+	for i := range display {
+		display[i] = [HEIGHT]uint8{}
+	}
+}
+
+// The display is kept and drawn once per animation frame
+func (c *canvasFrontend) Render(display *Display) {
+	c.frame = *display
+	c.isDirty = true
+}
+
+func (c *canvasFrontend) draw() {
+	if !c.isDirty {
+		return
+	}
+	c.isDirty = false
+	img := PostProcess(presentedImage(&c.frame))
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	if c.canvas.Get("width").Int() != width || c.canvas.Get("height").Int() != height {
+		c.canvas.Set("width", width)
+		c.canvas.Set("height", height)
+	}
+	pixels := js.Global().Get("Uint8ClampedArray").New(len(img.Pix))
+	js.CopyBytesToJS(pixels, img.Pix)
+	c.context.Call("putImageData", js.Global().Get("ImageData").New(pixels, width, height), 0, 0)
+}
+
+// A square wave runs muted from the start, the beep only opens its gain
+func (c *canvasFrontend) startAudio() {
+	constructor := js.Global().Get("AudioContext")
+	if constructor.IsUndefined() {
+		constructor = js.Global().Get("webkitAudioContext")
+	}
+	if constructor.IsUndefined() {
+		log.Print("Web Audio is not available, the beep is muted")
+		return
+	}
+	c.audio = constructor.New()
+	oscillator := c.audio.Call("createOscillator")
+	oscillator.Set("type", "square")
+	oscillator.Get("frequency").Set("value", BEEP_FREQUENCY)
+	c.gain = c.audio.Call("createGain")
+	c.gain.Get("gain").Set("value", 0)
+	oscillator.Call("connect", c.gain)
+	c.gain.Call("connect", c.audio.Get("destination"))
+	oscillator.Call("start")
+}
+
+// Browsers keep the audio suspended until the user interacts with the page
+func (c *canvasFrontend) resumeAudio() {
+	if c.audio.Truthy() && c.audio.Get("state").String() == "suspended" {
+		c.audio.Call("resume")
+	}
+}
+
+func (c *canvasFrontend) PlayAudio() {
+	c.setBeep(true)
+}
+
+func (c *canvasFrontend) PauseAudio() {
+	c.setBeep(false)
+}
+
+func (c *canvasFrontend) setBeep(isBeep bool) {
+	if !c.gain.Truthy() || c.isBeep == isBeep {
+		return
+	}
+	c.isBeep = isBeep
+	volume := 0.0
+	if isBeep {
+		volume = WEB_BEEP_VOLUME
+	}
+	c.gain.Get("gain").Call("setValueAtTime", volume, c.audio.Get("currentTime"))
+}
+
+func (c *canvasFrontend) HandleEvents(quit func(), keypad *Keypad) {
+	for _, event := range c.events {
+		if keypad[event.index] != event.isDown {
+			if event.isDown {
+				tracef(TRACE_INPUT, TRACE_INFO, "key %X down", event.index)
+			} else {
+				tracef(TRACE_INPUT, TRACE_INFO, "key %X up", event.index)
+			}
+		}
+		keypad[event.index] = event.isDown
+	}
+	c.events = c.events[:0]
+}
+
+// The page stays, only the beep stops
+func (c *canvasFrontend) Close() {
+	c.setBeep(false)
+}
+
+// Run the machine on the canvas of the page, the page loads the roms through the
+// global chip8 object, e.g. chip8.load("Pong.ch8", bytes)
+// Every load restarts the machine, the methods return an error message or null
+func RunInBrowser(speed uint8) {
+	canvas := &canvasFrontend{}
+	frontend = canvas
+	chip8.Speed = speed
+	frontend.Start(&chip8.Display)
+	isRunning := false
+	api := map[string]interface{}{
+		"load": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			if len(args) != 2 {
+				return "load takes the file name and its bytes"
+			}
+			data := make([]byte, args[1].Get("length").Int())
+			js.CopyBytesToGo(data, args[1])
+			rom, err := DecodeRom(args[0].String(), data)
+			if err == nil {
+				canvas.PauseAudio()
+				reset()
+				err = loadRomData(args[0].String(), rom)
+				loadFonts()
+			}
+			isRunning = err == nil
+			present(true)
+			return jsError(err)
+		}),
+		"palette": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			if len(args) != 1 {
+				return "palette takes the palette name"
+			}
+			err := SetPalette(args[0].String())
+			present(true)
+			return jsError(err)
+		}),
+		"quirks": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			if len(args) != 1 {
+				return "quirks takes the profile name"
+			}
+			return jsError(SetQuirkProfile(args[0].String()))
+		}),
+		"effects": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			if len(args) != 1 {
+				return "effects takes the comma separated effect names"
+			}
+			effects, err := ParseEffects(args[0].String())
+			if err == nil {
+				SetEffects(effects)
+				present(true)
+			}
+			return jsError(err)
+		}),
+		"speed": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			// NaN fails the range check too
+			if len(args) != 1 || args[0].Type() != js.TypeNumber || !(args[0].Float() >= 0 && args[0].Float() <= 255) {
+				return "speed takes the milliseconds of a cycle, 0-255"
+			}
+			SetSpeed(uint8(args[0].Int()))
+			return nil
+		}),
+	}
+	js.Global().Set("chip8", js.ValueOf(api))
+	// The cycles due since the last animation frame run at once, each of them takes
+	// Speed milliseconds like in the loop of the window
+	var due, last float64
+	var step js.Func
+	step = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		now := args[0].Float()
+		if last == 0 {
+			last = now
+		}
+		frameTime := now - last
+		if frameTime > MAX_FRAME_TIME {
+			frameTime = MAX_FRAME_TIME
+		}
+		last = now
+		cycleTime := float64(chip8.Speed)
+		if cycleTime < 1 {
+			cycleTime = 1
+		}
+		canvas.HandleEvents(halt, &chip8.Keypad)
+		if isRunning {
+			for due += frameTime; due >= cycleTime && chip8.Fault == nil; due -= cycleTime {
+				cycle()
+			}
+			if chip8.Fault != nil {
+				log.Print(chip8.Fault)
+				canvas.PauseAudio()
+				isRunning = false
+			}
+		}
+		canvas.draw()
+		js.Global().Call("requestAnimationFrame", step)
+		return nil
+	})
+	js.Global().Call("requestAnimationFrame", step)
+	select {}
+}
+
+func jsError(err error) interface{} {
+	if err == nil {
+		return nil
+	}
+	return fmt.Sprint(err)
+}
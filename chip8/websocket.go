@@ -0,0 +1,193 @@
+package chip8
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Opcodes of the websocket frames
+const (
+	WS_CONTINUATION = 0x0
+	WS_TEXT         = 0x1
+	WS_BINARY       = 0x2
+	WS_CLOSE        = 0x8
+	WS_PING         = 0x9
+	WS_PONG         = 0xA
+)
+
+// Messages from the clients are key presses, larger ones are refused
+const WS_MAX_MESSAGE_SIZE = 64 * 1024
+
+// Appended to the key of the client to prove the server speaks websocket
+const WS_ACCEPT_GUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Server side of a websocket connection, RFC 6455 without extensions
+// Messages are read on one goroutine, writes can come from any of them
+type wsConn struct {
+	conn      net.Conn
+	r         *bufio.Reader
+	writeLock sync.Mutex
+}
+
+// Answer the handshake of the request and take over its connection
+// The request is left to the handler when it is not a websocket handshake
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		return nil, fmt.Errorf("Not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, fmt.Errorf("Unsupported websocket version %q", r.Header.Get("Sec-WebSocket-Version"))
+	}
+	hijacker, isHijacker := w.(http.Hijacker)
+	if !isHijacker {
+		return nil, fmt.Errorf("The connection can't be upgraded")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", webSocketAccept(key))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, r: rw.Reader}, nil
+}
+
+func webSocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + WS_ACCEPT_GUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Comma separated header values, e.g. Connection: keep-alive, Upgrade
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Read the next text or binary message, pings are answered on the way
+// io.EOF is returned after the client closed the connection
+func (ws *wsConn) ReadMessage() (byte, []byte, error) {
+	var message []byte
+	messageOpcode := byte(0)
+	for {
+		isFinal, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case WS_PING:
+			if err := ws.WriteMessage(WS_PONG, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case WS_PONG:
+			continue
+		case WS_CLOSE:
+			ws.WriteMessage(WS_CLOSE, nil)
+			return 0, nil, io.EOF
+		case WS_CONTINUATION:
+			if messageOpcode == 0 {
+				return 0, nil, fmt.Errorf("Continuation frame without a message")
+			}
+		case WS_TEXT, WS_BINARY:
+			if messageOpcode != 0 {
+				return 0, nil, fmt.Errorf("New message before the end of the last one")
+			}
+			messageOpcode = opcode
+		default:
+			return 0, nil, fmt.Errorf("Unknown websocket opcode %d", opcode)
+		}
+		if len(message)+len(payload) > WS_MAX_MESSAGE_SIZE {
+			return 0, nil, fmt.Errorf("Websocket message is larger than %d bytes", WS_MAX_MESSAGE_SIZE)
+		}
+		message = append(message, payload...)
+		if isFinal {
+			return messageOpcode, message, nil
+		}
+	}
+}
+
+// Frames of the clients are masked
+func (ws *wsConn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(ws.r, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	isFinal := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, fmt.Errorf("Unmasked websocket frame from the client")
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(ws.r, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(ws.r, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > WS_MAX_MESSAGE_SIZE {
+		return false, 0, nil, fmt.Errorf("Websocket frame is larger than %d bytes", WS_MAX_MESSAGE_SIZE)
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.r, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return isFinal, opcode, payload, nil
+}
+
+// Write the message in a single unmasked frame
+func (ws *wsConn) WriteMessage(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+	ws.writeLock.Lock()
+	defer ws.writeLock.Unlock()
+	_, err := ws.conn.Write(frame)
+	return err
+}
+
+func (ws *wsConn) Close() error {
+	return ws.conn.Close()
+}
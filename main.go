@@ -3,20 +3,665 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/mehmetumit/CHIP-8/chip8"
+	"github.com/mehmetumit/CHIP-8/chip8/asm"
+	"github.com/mehmetumit/CHIP-8/chip8/disasm"
+	"github.com/mehmetumit/CHIP-8/chip8/octo"
+	"github.com/mehmetumit/CHIP-8/chip8/tracediff"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "debug":
+			debug(os.Args[2:])
+			return
+		case "disasm":
+			disassemble(os.Args[2:])
+			return
+		case "asm":
+			assemble(os.Args[2:])
+			return
+		case "octo":
+			compileOcto(os.Args[2:])
+			return
+		case "gdb":
+			serveGDB(os.Args[2:])
+			return
+		case "dap":
+			serveDAP(os.Args[2:])
+			return
+		case "serve":
+			serve(os.Args[2:])
+			return
+		case "profile":
+			profile(os.Args[2:])
+			return
+		case "coverage":
+			cover(os.Args[2:])
+			return
+		case "test":
+			runTests(os.Args[2:])
+			return
+		case "record":
+			record(os.Args[2:])
+			return
+		case "tracediff":
+			diffTraces(os.Args[2:])
+			return
+		}
+	}
 	var romPath string
 	var displayScale int
 	var speed uint
+	var frontend string
+	var ttyMode string
 	flag.StringVar(&romPath, "path", "./roms/Instruction-Test.ch8", "The file path of rom")
 	flag.UintVar(&speed, "speed", 3, "The emulation speed")
 	flag.IntVar(&displayScale, "scale", 12, "The display scale")
+	flag.StringVar(&frontend, "frontend", chip8.DefaultFrontend(), "The frontend: "+strings.Join(chip8.FrontendNames(), ", "))
+	flag.StringVar(&ttyMode, "tty-mode", chip8.TTY_HALFBLOCK, "The characters of the tty frontend: halfblock or braille")
+	quirks := addQuirksFlag(flag.CommandLine)
+	trace := addTraceFlags(flag.CommandLine)
+	display := addDisplayFlags(flag.CommandLine)
+	window := addWindowFlags(flag.CommandLine)
+	recording := addRecordFlags(flag.CommandLine)
 
 	flag.Parse()
-	fmt.Println(displayScale)
-	fmt.Println(speed)
+	setQuirks(*quirks)
+	if err := chip8.SetFrontend(frontend); err != nil {
+		log.Fatal(err)
+	}
+	if err := chip8.SetTTYMode(ttyMode); err != nil {
+		log.Fatal(err)
+	}
+	display.set()
+	window.set()
+	recording.start()
+	trace.start()
 	chip8.Boot(romPath, int32(displayScale), uint8(speed))
 }
+
+type traceFlags struct {
+	path       string
+	level      string
+	categories string
+	pcRange    string
+}
+
+// Tracing flags of the commands which run the machine
+func addTraceFlags(flags *flag.FlagSet) *traceFlags {
+	t := &traceFlags{}
+	flags.StringVar(&t.path, "trace", "", "Write json trace records to the file, - for stdout (default off)")
+	flags.StringVar(&t.level, "trace-level", "info", "The trace level: info or debug")
+	flags.StringVar(&t.categories, "trace-categories", "cpu", "Comma separated trace categories: cpu, draw, timer, input, audio or all")
+	flags.StringVar(&t.pcRange, "trace-pc", "", "Only trace instructions in the address range, e.g. 0x200-0x2FF")
+	return t
+}
+
+func (t *traceFlags) start() {
+	if t.path == "" {
+		return
+	}
+	level, err := chip8.ParseTraceLevel(t.level)
+	if err != nil {
+		log.Fatal(err)
+	}
+	categories, err := chip8.ParseTraceCategories(t.categories)
+	if err != nil {
+		log.Fatal(err)
+	}
+	start, end, err := chip8.ParseTraceRange(t.pcRange)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = chip8.StartTrace(chip8.TraceConfig{Path: t.path, Level: level, Categories: categories, StartPC: start, EndPC: end})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type displayFlags struct {
+	palette   string
+	colors    string
+	smoothing chip8.SmoothingConfig
+	effects   string
+}
+
+// Colour and effect flags of the commands which draw the display
+func addDisplayFlags(flags *flag.FlagSet) *displayFlags {
+	d := &displayFlags{}
+	flags.StringVar(&d.palette, "palette", chip8.DEFAULT_PALETTE, "The colour palette: "+strings.Join(chip8.PaletteNames(), ", ")+", F9 cycles them")
+	flags.StringVar(&d.colors, "colors", "", "Custom hex colours of the background, plane 1, plane 2, both planes and the border, e.g. #000,#FFF (default the palette)")
+	flags.StringVar(&d.smoothing.Mode, "smoothing", chip8.Smoothing.Mode, "Flicker reduction: off, frame (present once per frame), blend (the last frames) or phosphor (fading pixels)")
+	flags.IntVar(&d.smoothing.BlendFrames, "blend-frames", chip8.Smoothing.BlendFrames, "The frames blended by -smoothing blend")
+	flags.DurationVar(&d.smoothing.Fade, "fade", chip8.Smoothing.Fade, "The time a pixel takes to fade out with -smoothing phosphor")
+	flags.StringVar(&d.effects, "effects", "", "Comma separated post-processing effects: "+strings.Join(chip8.EffectNames(), ", ")+", F8 cycles them (default none)")
+	return d
+}
+
+func (d *displayFlags) set() {
+	if err := chip8.SetPalette(d.palette); err != nil {
+		log.Fatal(err)
+	}
+	if d.colors != "" {
+		if err := chip8.SetCustomColors(d.colors); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := chip8.SetSmoothing(d.smoothing); err != nil {
+		log.Fatal(err)
+	}
+	effects, err := chip8.ParseEffects(d.effects)
+	if err != nil {
+		log.Fatal(err)
+	}
+	chip8.SetEffects(effects)
+}
+
+type windowFlags struct {
+	textureFilter string
+	scaling       string
+	isFullscreen  bool
+	isHUD         bool
+	screenshotDir string
+}
+
+// Flags of the commands which open the window
+func addWindowFlags(flags *flag.FlagSet) *windowFlags {
+	w := &windowFlags{}
+	flags.StringVar(&w.textureFilter, "filter", chip8.TEXTURE_FILTER_NEAREST, "The scaling filter of the display: nearest or linear")
+	flags.StringVar(&w.scaling, "scaling", chip8.SCALING_INTEGER, "Scale the display to the window by integer or fractional steps")
+	flags.BoolVar(&w.isFullscreen, "fullscreen", false, "Start in fullscreen, F11 toggles it")
+	flags.BoolVar(&w.isHUD, "hud", false, "Show the registers, stack, next instructions, rates and held keys around the display, F7 toggles it")
+	flags.StringVar(&w.screenshotDir, "screenshots", ".", "The directory of the screenshots and recordings, F12 takes one of the window and Shift+F12 of the raw display")
+	return w
+}
+
+func (w *windowFlags) set() {
+	if err := chip8.SetTextureFilter(w.textureFilter); err != nil {
+		log.Fatal(err)
+	}
+	if err := chip8.SetScaling(w.scaling); err != nil {
+		log.Fatal(err)
+	}
+	chip8.IsFullscreen = w.isFullscreen
+	chip8.IsHUDVisible = w.isHUD
+	chip8.ScreenshotDir = w.screenshotDir
+	chip8.RecordDir = w.screenshotDir
+}
+
+type recordFlags struct {
+	config chip8.RecordConfig
+}
+
+// Recording flags of the commands which open the window
+func addRecordFlags(flags *flag.FlagSet) *recordFlags {
+	r := &recordFlags{}
+	flags.StringVar(&r.config.Path, "record", "", "Record the run to the file, the format is taken from the extension unless -record-format is set (default off)")
+	flags.StringVar(&r.config.Format, "record-format", "", "The recording format: "+strings.Join(chip8.RecordFormats(), ", ")+", F10 records in it (default gif)")
+	flags.StringVar(&r.config.WavPath, "record-wav", "", "Record the beeps to the wav file along with -record")
+	flags.IntVar(&r.config.Scale, "record-scale", 1, "Pixels per display pixel in the recordings")
+	return r
+}
+
+func (r *recordFlags) start() {
+	if r.config.Format != "" {
+		if err := chip8.ParseRecordFormat(r.config.Format); err != nil {
+			log.Fatal(err)
+		}
+		chip8.RecordFormat = r.config.Format
+	}
+	chip8.RecordScale = r.config.Scale
+	if r.config.Path == "" {
+		return
+	}
+	if err := chip8.StartRecording(r.config); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Quirk profile flag of the commands which run the machine
+func addQuirksFlag(flags *flag.FlagSet) *string {
+	return flags.String("quirks", chip8.DEFAULT_QUIRK_PROFILE, "The quirk profile: "+strings.Join(chip8.QuirkProfileNames(), ", "))
+}
+
+func setQuirks(name string) {
+	if err := chip8.SetQuirkProfile(name); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Parse flags which can also come after the positional arguments
+func parseArgs(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// chip8 debug [-scale n] [-speed n] [-symbols file] <rom>
+func debug(args []string) {
+	var displayScale int
+	var speed uint
+	var symbolsPath string
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	flags.UintVar(&speed, "speed", 3, "The emulation speed")
+	flags.IntVar(&displayScale, "scale", 12, "The display scale")
+	flags.StringVar(&symbolsPath, "symbols", "", "The symbol file written by the assembler (default <rom>.sym if exists)")
+	quirks := addQuirksFlag(flags)
+	trace := addTraceFlags(flags)
+	display := addDisplayFlags(flags)
+	window := addWindowFlags(flags)
+	recording := addRecordFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: chip8 debug [flags] <rom>")
+		flags.PrintDefaults()
+	}
+	positional := parseArgs(flags, args)
+	if len(positional) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	setQuirks(*quirks)
+	display.set()
+	window.set()
+	recording.start()
+	trace.start()
+	chip8.Debug(positional[0], symbolsPath, int32(displayScale), uint8(speed))
+}
+
+// chip8 gdb [-listen address] [-scale n] [-speed n] <rom>
+func serveGDB(args []string) {
+	var displayScale int
+	var speed uint
+	var address string
+	flags := flag.NewFlagSet("gdb", flag.ExitOnError)
+	flags.UintVar(&speed, "speed", 3, "The emulation speed")
+	flags.IntVar(&displayScale, "scale", 12, "The display scale")
+	flags.StringVar(&address, "listen", "localhost:1234", "The tcp address or unix:<path> socket of the gdb stub")
+	quirks := addQuirksFlag(flags)
+	trace := addTraceFlags(flags)
+	display := addDisplayFlags(flags)
+	window := addWindowFlags(flags)
+	recording := addRecordFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: chip8 gdb [flags] <rom>")
+		flags.PrintDefaults()
+	}
+	positional := parseArgs(flags, args)
+	if len(positional) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	setQuirks(*quirks)
+	display.set()
+	window.set()
+	recording.start()
+	trace.start()
+	chip8.ServeGDB(positional[0], address, int32(displayScale), uint8(speed))
+}
+
+// chip8 serve [-listen address] [-speed n] <rom>
+func serve(args []string) {
+	var speed uint
+	var address string
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.UintVar(&speed, "speed", 3, "The emulation speed")
+	flags.StringVar(&address, "listen", "localhost:8080", "The tcp address or unix:<path> socket of the web page")
+	quirks := addQuirksFlag(flags)
+	trace := addTraceFlags(flags)
+	display := addDisplayFlags(flags)
+	recording := addRecordFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: chip8 serve [flags] <rom>")
+		flags.PrintDefaults()
+	}
+	positional := parseArgs(flags, args)
+	if len(positional) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	setQuirks(*quirks)
+	display.set()
+	recording.start()
+	trace.start()
+	chip8.Serve(positional[0], address, uint8(speed))
+}
+
+// chip8 dap [-listen address] [-scale n] [-speed n]
+func serveDAP(args []string) {
+	var displayScale int
+	var speed uint
+	var address string
+	flags := flag.NewFlagSet("dap", flag.ExitOnError)
+	flags.UintVar(&speed, "speed", 3, "The emulation speed")
+	flags.IntVar(&displayScale, "scale", 12, "The display scale")
+	flags.StringVar(&address, "listen", "", "The tcp address or unix:<path> socket of the server (default stdin and stdout)")
+	display := addDisplayFlags(flags)
+	window := addWindowFlags(flags)
+	recording := addRecordFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: chip8 dap [flags]")
+		flags.PrintDefaults()
+	}
+	if len(parseArgs(flags, args)) != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	display.set()
+	window.set()
+	recording.start()
+	chip8.ServeDAP(address, int32(displayScale), uint8(speed))
+}
+
+// chip8 disasm [-octo] [-o file] <rom>
+func disassemble(args []string) {
+	var isOcto bool
+	var outputPath string
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	flags.BoolVar(&isOcto, "octo", false, "Write Octo syntax instead of plain assembly")
+	flags.StringVar(&outputPath, "o", "", "The output file path (default stdout)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: chip8 disasm [flags] <rom>")
+		flags.PrintDefaults()
+	}
+	positional := parseArgs(flags, args)
+	if len(positional) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	rom, err := chip8.ReadRom(positional[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	syntax := disasm.PLAIN
+	if isOcto {
+		syntax = disasm.OCTO
+	}
+	output := os.Stdout
+	if outputPath != "" {
+		if output, err = os.Create(outputPath); err != nil {
+			log.Fatal(err)
+		}
+		defer output.Close()
+	}
+	if err = disasm.Trace(rom, chip8.START_ADDRESS).Write(output, syntax); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// chip8 asm [-o file] [-symbols file] <source>
+func assemble(args []string) {
+	var outputPath string
+	var symbolsPath string
+	flags := flag.NewFlagSet("asm", flag.ExitOnError)
+	flags.StringVar(&outputPath, "o", "", "The output rom path (default <source>.ch8)")
+	flags.StringVar(&symbolsPath, "symbols", "", "The symbol file path for the debugger (default <output>.sym)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: chip8 asm [flags] <source>")
+		flags.PrintDefaults()
+	}
+	positional := parseArgs(flags, args)
+	if len(positional) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	sourcePath := positional[0]
+	if outputPath == "" {
+		outputPath = strings.TrimSuffix(sourcePath, filepath.Ext(sourcePath)) + ".ch8"
+	}
+	if symbolsPath == "" {
+		symbolsPath = strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".sym"
+	}
+	rom, symbols, err := asm.AssembleFile(sourcePath, chip8.START_ADDRESS)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err = os.WriteFile(outputPath, rom, 0644); err != nil {
+		log.Fatal(err)
+	}
+	symbolsFile, err := os.Create(symbolsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer symbolsFile.Close()
+	if err = symbols.Write(symbolsFile); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s: %d bytes, %d labels\n", outputPath, len(rom), len(symbols.Labels))
+}
+
+// chip8 octo [-o file] <source.8o>
+func compileOcto(args []string) {
+	var outputPath string
+	flags := flag.NewFlagSet("octo", flag.ExitOnError)
+	flags.StringVar(&outputPath, "o", "", "The output rom path (default <source>.ch8)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: chip8 octo [flags] <source.8o>")
+		flags.PrintDefaults()
+	}
+	positional := parseArgs(flags, args)
+	if len(positional) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	sourcePath := positional[0]
+	if outputPath == "" {
+		outputPath = strings.TrimSuffix(sourcePath, filepath.Ext(sourcePath)) + ".ch8"
+	}
+	rom, err := octo.CompileFile(sourcePath, chip8.START_ADDRESS)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err = os.WriteFile(outputPath, rom, 0644); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s: %d bytes\n", outputPath, len(rom))
+}
+
+// chip8 profile [-o file] [-folded file] [-annotate file] [-symbols file] [-cycles n] <rom>
+func profile(args []string) {
+	var displayScale int
+	var speed uint
+	var symbolsPath string
+	var config chip8.ProfileConfig
+	flags := flag.NewFlagSet("profile", flag.ExitOnError)
+	flags.UintVar(&speed, "speed", 3, "The emulation speed")
+	flags.IntVar(&displayScale, "scale", 12, "The display scale")
+	flags.StringVar(&config.ReportPath, "o", "-", "The text report path, - for stdout")
+	flags.StringVar(&config.FoldedPath, "folded", "", "Write folded call stacks for flame graphs to the file")
+	flags.StringVar(&config.AnnotatePath, "annotate", "", "Write the disassembly annotated with execution counts to the file")
+	flags.StringVar(&symbolsPath, "symbols", "", "The symbols file (default <rom>.sym if it exists)")
+	flags.Uint64Var(&config.MaxCycles, "cycles", 0, "Stop after the number of cycles (default run until exit)")
+	quirks := addQuirksFlag(flags)
+	trace := addTraceFlags(flags)
+	display := addDisplayFlags(flags)
+	window := addWindowFlags(flags)
+	recording := addRecordFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: chip8 profile [flags] <rom>")
+		flags.PrintDefaults()
+	}
+	positional := parseArgs(flags, args)
+	if len(positional) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	setQuirks(*quirks)
+	display.set()
+	window.set()
+	recording.start()
+	trace.start()
+	chip8.Profile(positional[0], symbolsPath, config, int32(displayScale), uint8(speed))
+}
+
+// chip8 coverage [-o file] [-lcov file] [-symbols file] [-cycles n] <rom>
+func cover(args []string) {
+	var displayScale int
+	var speed uint
+	var symbolsPath string
+	var config chip8.CoverageConfig
+	flags := flag.NewFlagSet("coverage", flag.ExitOnError)
+	flags.UintVar(&speed, "speed", 3, "The emulation speed")
+	flags.IntVar(&displayScale, "scale", 12, "The display scale")
+	flags.StringVar(&config.ReportPath, "o", "-", "The text report path, - for stdout")
+	flags.StringVar(&config.LcovPath, "lcov", "", "Write the LCOV file of the assembler source lines to the file, needs symbols")
+	flags.StringVar(&symbolsPath, "symbols", "", "The symbols file (default <rom>.sym if it exists)")
+	flags.Uint64Var(&config.MaxCycles, "cycles", 0, "Stop after the number of cycles (default run until exit)")
+	quirks := addQuirksFlag(flags)
+	trace := addTraceFlags(flags)
+	display := addDisplayFlags(flags)
+	window := addWindowFlags(flags)
+	recording := addRecordFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: chip8 coverage [flags] <rom>")
+		flags.PrintDefaults()
+	}
+	positional := parseArgs(flags, args)
+	if len(positional) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	setQuirks(*quirks)
+	display.set()
+	window.set()
+	recording.start()
+	trace.start()
+	chip8.Cover(positional[0], symbolsPath, config, int32(displayScale), uint8(speed))
+}
+
+// chip8 record [-o file] [-input movie.json] [-cycles n] [-wav file] <rom>
+func record(args []string) {
+	var speed uint
+	var moviePath string
+	var cycles uint64
+	var config chip8.RecordConfig
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	flags.UintVar(&speed, "speed", 3, "The emulation speed, milliseconds of a cycle")
+	flags.StringVar(&config.Path, "o", "recording.gif", "The recording path")
+	flags.StringVar(&config.Format, "format", "", "The recording format: "+strings.Join(chip8.RecordFormats(), ", ")+" (default from the extension)")
+	flags.StringVar(&config.WavPath, "wav", "", "Record the beeps to the wav file")
+	flags.IntVar(&config.Scale, "scale", 1, "Pixels per display pixel")
+	flags.StringVar(&moviePath, "input", "", "The movie, a json array of {\"frame\": n, \"keys\": \"hex digits\"} key inputs")
+	flags.Uint64Var(&cycles, "cycles", 1000, "The cycles to run, each takes -speed milliseconds of emulated time")
+	quirks := addQuirksFlag(flags)
+	display := addDisplayFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: chip8 record [flags] <rom>")
+		flags.PrintDefaults()
+	}
+	positional := parseArgs(flags, args)
+	if len(positional) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	setQuirks(*quirks)
+	display.set()
+	chip8.SetSpeed(uint8(speed))
+	// Rom loading logs would be mixed with the result
+	log.SetOutput(io.Discard)
+	err := chip8.Record(positional[0], moviePath, cycles, config)
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s: %d cycles\n", config.Path, cycles)
+}
+
+// chip8 test [-junit file] [-update] [suite.json...]
+func runTests(args []string) {
+	var junitPath string
+	var isUpdate bool
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	flags.StringVar(&junitPath, "junit", "", "Write a JUnit XML report to the file")
+	flags.BoolVar(&isUpdate, "update", false, "Write the golden images from the current displays")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: chip8 test [flags] [suite.json...] (default ./roms/conformance.json)")
+		flags.PrintDefaults()
+	}
+	positional := parseArgs(flags, args)
+	if len(positional) == 0 {
+		positional = []string{"./roms/conformance.json"}
+	}
+	var tests []chip8.ConformanceTest
+	for _, path := range positional {
+		suite, err := chip8.ReadConformanceSuite(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tests = append(tests, suite...)
+	}
+	// Rom loading logs would be mixed with the results
+	log.SetOutput(io.Discard)
+	results := make([]chip8.ConformanceResult, len(tests))
+	isPassed := true
+	for i, test := range tests {
+		results[i] = chip8.RunConformanceTest(test, isUpdate)
+		isPassed = isPassed && results[i].Passed
+	}
+	log.SetOutput(os.Stderr)
+	chip8.WriteConformanceResults(os.Stdout, results)
+	if junitPath != "" {
+		file, err := os.Create(junitPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = chip8.WriteJUnit(file, "chip8", results)
+		file.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if !isPassed {
+		os.Exit(1)
+	}
+}
+
+// chip8 tracediff [-context n] <a.trace> <b.trace>
+func diffTraces(args []string) {
+	var context int
+	flags := flag.NewFlagSet("tracediff", flag.ExitOnError)
+	flags.IntVar(&context, "context", 5, "The number of records shown around the divergence")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: chip8 tracediff [flags] <a.trace> <b.trace>")
+		flags.PrintDefaults()
+	}
+	positional := parseArgs(flags, args)
+	if len(positional) != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	traces := make([]*tracediff.Trace, 2)
+	for i, path := range positional {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		traces[i], err = tracediff.Read(path, file)
+		file.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	divergence := tracediff.Diff(traces[0], traces[1])
+	if divergence == nil {
+		fmt.Printf("Traces are the same for %d cycles\n", len(traces[0].Records))
+		return
+	}
+	divergence.Write(os.Stdout, traces[0], traces[1], context)
+	os.Exit(1)
+}
@@ -0,0 +1,10 @@
+//go:build js && wasm
+
+// Browser build of the emulator, index.html loads the roms into it
+package main
+
+import "github.com/mehmetumit/CHIP-8/chip8"
+
+func main() {
+	chip8.RunInBrowser(3)
+}